@@ -36,16 +36,32 @@ import (
 	"mp4"
         "log"
         "net/http"
-        //"io"
+        "io"
         "io/ioutil"
         "path"
         "syscall"
         "strings"
+        "sort"
+        "container/list"
         "encoding/json"
+        "encoding/hex"
+        "encoding/base64"
+        "encoding/binary"
+        "crypto/sha256"
+        "crypto/subtle"
+        "crypto/hmac"
+        "crypto/rand"
+        "net"
+        "net/url"
+        "regexp"
+        "html"
         "strconv"
         "errors"
 	"fmt"
 	"flag"
+	"math"
+	"sync"
+	"time"
 )
 
 func readFile(filename string) (data []byte, r error) {
@@ -75,12 +91,1247 @@ func readFile(filename string) (data []byte, r error) {
   return
 }
 
+// Parse a "<key id>:<key>" couple of hexadecimal 16 bytes values
+func parseKey(hexKeyId string, hexKey string) (keyId [16]byte, key [16]byte, err error) {
+  b, err := hex.DecodeString(strings.TrimSpace(hexKeyId))
+  if err != nil || len(b) != 16 {
+    err = errors.New("invalid key id '" + hexKeyId + "'")
+    return
+  }
+  copy(keyId[:], b)
+  b, err = hex.DecodeString(strings.TrimSpace(hexKey))
+  if err != nil || len(b) != 16 {
+    err = errors.New("invalid key for key id '" + hexKeyId + "'")
+    return
+  }
+  copy(key[:], b)
+
+  return
+}
+
+type contentKey struct {
+  KeyId [16]byte
+  Key   [16]byte
+}
+
+// Read a key file with "<key id>:<key>" hexadecimal lines, empty lines and lines beginning with # are ignored
+func readKeyFile(filename string) (keys []contentKey, err error) {
+  data, err := readFile(filename)
+  if err != nil {
+    return
+  }
+  for _, line := range strings.Split(string(data), "\n") {
+    line = strings.TrimSpace(line)
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    split1 := strings.Split(line, ":")
+    if len(split1) != 2 {
+      err = errors.New("invalid line '" + line + "' in key file")
+      return
+    }
+    var k contentKey
+    k.KeyId, k.Key, err = parseKey(split1[0], split1[1])
+    if err != nil {
+      return
+    }
+    keys = append(keys, k)
+  }
+
+  return
+}
+
+// Find the content key of a track of an asset, in its key file (first key or the one of KeyId), in its keys imported
+// from a CPIX document (the one of KeyId or of the track type, in the first key period) or in the JSON config
+func readDrmKey(drm mp4.DrmConfig, dir string, trackType string) (keyId [16]byte, key [16]byte, err error) {
+  if drm.KeyFile != "" {
+    keys, e := readKeyFile(dir + "/" + drm.KeyFile)
+    if e != nil {
+      err = e
+      return
+    }
+    for _, k := range keys {
+      if drm.KeyId == "" || strings.EqualFold(hex.EncodeToString(k.KeyId[:]), strings.TrimSpace(drm.KeyId)) {
+        keyId = k.KeyId
+        key = k.Key
+        return
+      }
+    }
+    err = errors.New("key not found in key file")
+    return
+  }
+  if drm.Keys != nil {
+    // Without key rotation, the keys of the first key period (lowest index) are used
+    periodId := ""
+    var periodIndex uint32
+    for i, p := range drm.KeyPeriods {
+      if i == 0 || p.Index < periodIndex {
+        periodId = p.Id
+        periodIndex = p.Index
+      }
+    }
+    var found *mp4.DrmKey
+    for i, k := range drm.Keys {
+      if k.PeriodId != "" && k.PeriodId != periodId {
+        continue
+      }
+      if drm.KeyId != "" {
+        if strings.EqualFold(k.KeyId, strings.TrimSpace(drm.KeyId)) {
+          found = &drm.Keys[i]
+          break
+        }
+      } else if k.TrackType == trackType || (k.TrackType == "" && found == nil) {
+        found = &drm.Keys[i]
+        if k.TrackType == trackType {
+          break
+        }
+      }
+    }
+    if found == nil {
+      err = errors.New("no key found for " + trackType + " tracks")
+      return
+    }
+    keyId, key, err = parseKey(found.KeyId, found.Key)
+    return
+  }
+  keyId, key, err = parseKey(drm.KeyId, drm.Key)
+
+  return
+}
+
+// Key periods of a track without their PSSH boxes: the keys of its key file used in turn for rotation seconds each,
+// or its keys imported from a CPIX document in the order of the key periods. The CPIX periods are used from their
+// start to their end (relative to the start of the first period) when they all have them, for rotation seconds
+// each otherwise.
+func readKeyPeriods(drm mp4.DrmConfig, dir string, trackType string, rotation uint32) (periods []mp4.DashKeyPeriod, err error) {
+  duration := uint64(rotation) * 1000
+  if drm.KeyFile != "" {
+    keys, e := readKeyFile(dir + "/" + drm.KeyFile)
+    if e != nil {
+      err = e
+      return
+    }
+    if len(keys) == 0 {
+      err = errors.New("no key found in key file")
+      return
+    }
+    for i, k := range keys {
+      periods = append(periods, mp4.DashKeyPeriod{ KeyId: k.KeyId, Key: k.Key, Start: uint64(i) * duration, End: uint64(i + 1) * duration })
+    }
+    return
+  }
+  if len(drm.KeyPeriods) == 0 {
+    err = errors.New("key rotation requires a key file or CPIX key periods")
+    return
+  }
+  cpixPeriods := make([]mp4.DrmKeyPeriod, len(drm.KeyPeriods))
+  copy(cpixPeriods, drm.KeyPeriods)
+  sort.SliceStable(cpixPeriods, func(i, j int) bool { return cpixPeriods[i].Index < cpixPeriods[j].Index })
+  var starts, ends []time.Time
+  for _, p := range cpixPeriods {
+    if p.Start == "" || p.End == "" {
+      starts = nil
+      break
+    }
+    start, e := parseDateTime(p.Start)
+    if e != nil {
+      err = e
+      return
+    }
+    end, e := parseDateTime(p.End)
+    if e != nil {
+      err = e
+      return
+    }
+    if !end.After(start) {
+      err = errors.New("key period '" + p.Id + "' ends before its start")
+      return
+    }
+    starts = append(starts, start)
+    ends = append(ends, end)
+  }
+  for i, p := range cpixPeriods {
+    var found *mp4.DrmKey
+    for j, k := range drm.Keys {
+      if k.PeriodId != p.Id {
+        continue
+      }
+      if k.TrackType == trackType || (k.TrackType == "" && found == nil) {
+        found = &drm.Keys[j]
+        if k.TrackType == trackType {
+          break
+        }
+      }
+    }
+    if found == nil {
+      err = errors.New("no key found for " + trackType + " tracks in key period '" + p.Id + "'")
+      return
+    }
+    period := mp4.DashKeyPeriod{ Start: uint64(i) * duration, End: uint64(i + 1) * duration }
+    if starts != nil {
+      if starts[i].Before(starts[0]) {
+        err = errors.New("key period '" + p.Id + "' starts before the first key period")
+        return
+      }
+      period.Start = uint64(starts[i].Sub(starts[0]) / time.Millisecond)
+      period.End = uint64(ends[i].Sub(starts[0]) / time.Millisecond)
+    }
+    period.KeyId, period.Key, err = parseKey(found.KeyId, found.Key)
+    if err != nil {
+      return
+    }
+    periods = append(periods, period)
+  }
+
+  return
+}
+
+// Parse a xs:dateTime, with or without time zone (UTC)
+func parseDateTime(value string) (t time.Time, err error) {
+  t, err = time.Parse(time.RFC3339Nano, value)
+  if err != nil {
+    t, err = time.Parse("2006-01-02T15:04:05.999999999", value)
+  }
+  if err != nil {
+    err = errors.New("invalid date and time '" + value + "'")
+  }
+
+  return
+}
+
+// PSSH Boxes of a key: generated for Widevine and PlayReady, or imported from a CPIX document
+func createPsshBoxes(drm mp4.DrmConfig, keyId [16]byte, key [16]byte, scheme string) (pssh []mp4.PsshBox, err error) {
+  if drm.Widevine != nil {
+    contentId, e := hex.DecodeString(drm.Widevine.ContentId)
+    if e != nil {
+      err = errors.New("invalid Widevine content id '" + drm.Widevine.ContentId + "'")
+      return
+    }
+    pssh = append(pssh, mp4.WidevinePsshBox([][16]byte{ keyId }, drm.Widevine.Provider, contentId, scheme))
+  }
+  if drm.PlayReady != nil {
+    pssh = append(pssh, mp4.PlayReadyPsshBox(keyId, key, scheme, drm.PlayReady.LaUrl))
+  }
+  for _, system := range drm.Systems {
+    if system.Pssh == "" || !strings.EqualFold(system.KeyId, hex.EncodeToString(keyId[:])) {
+      continue
+    }
+    p, e := mp4.ParsePsshBox(system.Pssh)
+    if e != nil {
+      err = e
+      return
+    }
+    pssh = append(pssh, p)
+  }
+
+  return
+}
+
+func readJsonConfig(filename string) (jConfig mp4.JsonConfig, err error) {
+  data, err := readFile(filename)
+  if err != nil {
+    return
+  }
+  err = json.Unmarshal(data, &jConfig)
+  if err != nil {
+    return
+  }
+  // Pre-encrypted tracks are signalled with the key id and the PSSH Boxes of their source file
+  for trackType := range jConfig.Tracks {
+    for _, t := range jConfig.Tracks[trackType] {
+      if t.Config == nil || t.Config.Protection == nil {
+        continue
+      }
+      t.Config.Encryption = &mp4.DashEncryption{ Scheme: t.Config.Protection.Scheme, KeyId: t.Config.Protection.KeyId }
+      copy(t.Config.Encryption.ConstantIv[:], t.Config.Protection.ConstantIv)
+      for _, b64 := range t.Config.Protection.Pssh {
+        pssh, e := mp4.ParsePsshBox(b64)
+        if e != nil {
+          err = e
+          return
+        }
+        t.Config.Encryption.Pssh = append(t.Config.Encryption.Pssh, pssh)
+      }
+    }
+  }
+  if jConfig.Drm == nil {
+    return
+  }
+  scheme := jConfig.Drm.Scheme
+  if scheme == "" {
+    scheme = "cenc"
+  }
+  if scheme != "cenc" && scheme != "cbcs" {
+    err = errors.New("unsupported protection scheme '" + scheme + "'")
+    return
+  }
+  for trackType := range jConfig.Tracks {
+    for _, t := range jConfig.Tracks[trackType] {
+      if t.Config == nil || t.Config.Protection != nil {
+        continue
+      }
+      // Subsamples are only computed for the NAL units of AVC and HEVC tracks
+      if t.Config.Video != nil && t.Config.Video.Codec != "" && t.Config.Video.HvcC == nil {
+        err = errors.New("encryption of " + t.Config.Video.Codec + " track " + t.Name + " is not supported")
+        return
+      }
+      var periods []mp4.DashKeyPeriod
+      if jConfig.Drm.KeyRotation > 0 {
+        periods, err = readKeyPeriods(*jConfig.Drm, path.Dir(filename), trackType, jConfig.Drm.KeyRotation)
+        if err != nil {
+          return
+        }
+        for i, p := range periods {
+          pssh, e := createPsshBoxes(*jConfig.Drm, p.KeyId, p.Key, scheme)
+          if e != nil {
+            err = e
+            return
+          }
+          // Players find the key ids of the new periods in the fragments
+          periods[i].Pssh = append(pssh, mp4.CommonPsshBox([][16]byte{ p.KeyId }))
+        }
+      } else {
+        keyId, key, e := readDrmKey(*jConfig.Drm, path.Dir(filename), trackType)
+        if e != nil {
+          err = e
+          return
+        }
+        pssh, e := createPsshBoxes(*jConfig.Drm, keyId, key, scheme)
+        if e != nil {
+          err = e
+          return
+        }
+        periods = append(periods, mp4.DashKeyPeriod{ KeyId: keyId, Key: key, Pssh: pssh })
+      }
+      keyId := periods[0].KeyId
+      // Each track has its own initialization vectors
+      h := sha256.Sum256([]byte(hex.EncodeToString(keyId[:]) + t.Name + "=" + strconv.FormatUint(t.Bandwidth, 10)))
+      t.Config.Encryption = &mp4.DashEncryption{ Scheme: scheme, KeyId: keyId, Key: periods[0].Key, Iv: binary.BigEndian.Uint64(h[:8]) }
+      copy(t.Config.Encryption.ConstantIv[:], h[:16])
+      t.Config.Encryption.Pssh = periods[0].Pssh
+      if jConfig.Drm.KeyRotation > 0 {
+        t.Config.Encryption.KeyPeriods = periods
+      }
+    }
+  }
+
+  return
+}
+
+// Protected assets (with a Drm section or pre-encrypted tracks) are delivered with Common Encryption in DASH, and in
+// HLS fragmented MP4 with the cbcs scheme
+func isProtected(jConfig mp4.JsonConfig) bool {
+  for _, tracks := range jConfig.Tracks {
+    for _, t := range tracks {
+      if t.Config != nil && t.Config.Encryption != nil {
+        return true
+      }
+    }
+  }
+
+  return false
+}
+
+func isHlsProtected(jConfig mp4.JsonConfig) bool {
+  for _, tracks := range jConfig.Tracks {
+    for _, t := range tracks {
+      if t.Config != nil && t.Config.Encryption != nil && t.Config.Encryption.Scheme != "cbcs" {
+        return false
+      }
+    }
+  }
+
+  return isProtected(jConfig)
+}
+
+// MPEG-2 TS segments are encrypted with AES-128 and are always made from clear samples, so not from pre-encrypted tracks
+func isTsProtected(jConfig mp4.JsonConfig) bool {
+  if jConfig.HlsEncryption == nil {
+    return false
+  }
+  for _, tracks := range jConfig.Tracks {
+    for _, t := range tracks {
+      if t.Config != nil && t.Config.Protection != nil {
+        return false
+      }
+    }
+  }
+
+  return true
+}
+
+func clearConfig(dConf *mp4.DashConfig) *mp4.DashConfig {
+  if dConf == nil {
+    return nil
+  }
+  c := *dConf
+  c.Encryption = nil
+
+  return &c
+}
+
+// HLS AES-128 key of an asset
+func readHlsKey(jConfig mp4.JsonConfig, dir string) (keyId [16]byte, key [16]byte, err error) {
+  keyId, key, err = readDrmKey(mp4.DrmConfig{ KeyId: jConfig.HlsEncryption.KeyId, Key: jConfig.HlsEncryption.Key, KeyFile: jConfig.HlsEncryption.KeyFile }, dir, "")
+
+  return
+}
+
+// Initialization vector of a HLS AES-128 segment, explicit is false when it is the media sequence number
+// which players use when the EXT-X-KEY tag has no IV attribute
+func hlsSegmentIv(jConfig mp4.JsonConfig, keyId [16]byte, mediaSequence uint32) (iv [16]byte, explicit bool, err error) {
+  switch jConfig.HlsEncryption.Iv {
+    case "", "sequence":
+      binary.BigEndian.PutUint32(iv[12:16], mediaSequence)
+    case "hash":
+      h := sha256.Sum256([]byte(hex.EncodeToString(keyId[:]) + ":" + strconv.FormatUint(uint64(mediaSequence), 10)))
+      copy(iv[:], h[:16])
+      explicit = true
+    default:
+      b, e := hex.DecodeString(jConfig.HlsEncryption.Iv)
+      if e != nil || len(b) != 16 {
+        err = errors.New("invalid IV '" + jConfig.HlsEncryption.Iv + "'")
+        return
+      }
+      copy(iv[:], b)
+      explicit = true
+  }
+
+  return
+}
+
+// URI of the HLS AES-128 key of an asset, with the same asset path as the segments (<asset>.json) so that their
+// signed URL token is valid for the key too
+func hlsKeyUri(dir string, videoId string, keyId [16]byte) string {
+  return fmt.Sprintf("/keys%s.json/%s", path.Join(dir, videoId), hex.EncodeToString(keyId[:]))
+}
+
+// Media sequence number of a HLS TS segment from its fragment number: its position in the playlist (starting at 1),
+// fragment numbers have gaps when segments are extended to the next keyframe
+func hlsMediaSequence(segments []mp4.DashSegment, segmentNumber uint32) (mediaSequence uint32, ok bool) {
+  for i, segment := range segments {
+    if segment.Number == segmentNumber {
+      return uint32(1 + i), true
+    }
+  }
+
+  return
+}
+
+// Key identifier in UUID format (eg: 10000000-1000-1000-1000-100000000001)
+func keyIdToUuid(keyId [16]byte) string {
+  s := hex.EncodeToString(keyId[:])
+
+  return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
+func createContentProtection(t mp4.TrackEntry, clearKeyUrl string) (s string) {
+  if t.Config == nil || t.Config.Encryption == nil {
+    return
+  }
+  s = `      <ContentProtection` + "\n"
+  s += `        schemeIdUri="urn:mpeg:dash:mp4protection:2011"` + "\n"
+  s += fmt.Sprintf(`        value="%s"`, t.Config.Encryption.Scheme) + "\n"
+  s += fmt.Sprintf(`        cenc:default_KID="%s"/>`, keyIdToUuid(t.Config.Encryption.KeyId)) + "\n"
+  // With key rotation the PSSH Boxes of the key periods are only in the fragments
+  rotation := len(t.Config.Encryption.KeyPeriods) > 0
+  for _, pssh := range t.Config.Encryption.Pssh {
+    if pssh.SystemId == mp4.CommonSystemId {
+      continue
+    }
+    s += `      <ContentProtection` + "\n"
+    s += fmt.Sprintf(`        schemeIdUri="urn:uuid:%s"`, keyIdToUuid(pssh.SystemId))
+    switch pssh.SystemId {
+      case mp4.WidevineSystemId:
+        s += "\n" + `        value="Widevine"`
+      case mp4.PlayReadySystemId:
+        s += "\n" + `        value="MSPR 2.0"`
+    }
+    if rotation {
+      s += "/>\n"
+      continue
+    }
+    s += ">\n"
+    s += fmt.Sprintf(`        <cenc:pssh>%s</cenc:pssh>`, base64.StdEncoding.EncodeToString(pssh.Bytes())) + "\n"
+    if pssh.SystemId == mp4.PlayReadySystemId {
+      s += fmt.Sprintf(`        <mspr:pro>%s</mspr:pro>`, base64.StdEncoding.EncodeToString(pssh.Data)) + "\n"
+    }
+    s += `      </ContentProtection>` + "\n"
+  }
+  if clearKeyUrl != "" && findClearKey(t.Config.Encryption.KeyId) != nil {
+    s += `      <ContentProtection` + "\n"
+    s += `        schemeIdUri="urn:uuid:e2719d58-a985-b3c9-781a-b030af78d30e"` + "\n"
+    s += `        value="ClearKey1.0">` + "\n"
+    s += fmt.Sprintf(`        <dashif:laurl>%s</dashif:laurl>`, clearKeyUrl) + "\n"
+    s += fmt.Sprintf(`        <clearkey:Laurl Lic_type="EME-1.0">%s</clearkey:Laurl>`, clearKeyUrl) + "\n"
+    s += `      </ContentProtection>` + "\n"
+  }
+
+  return
+}
+
+// Parse a track identifier like "video_eng=400000"
+func parseTrackId(trackId string) (trackType string, trackName string, trackBandwidth uint64, err error) {
+  split1 := strings.Split(trackId, "=")
+  if len(split1) != 2 {
+    err = errors.New("invalid track identifier '" + trackId + "'")
+    return
+  }
+  trackName = split1[0]
+  trackType = strings.Split(trackName, "_")[0]
+  trackBandwidth, err = strconv.ParseUint(split1[1], 10, 64)
+
+  return
+}
+
+func findTrack(jConfig mp4.JsonConfig, trackType string, trackName string, trackBandwidth uint64) (track *mp4.TrackEntry) {
+  for i, t := range jConfig.Tracks[trackType] {
+    if t.Name == trackName && t.Bandwidth == trackBandwidth {
+      track = &jConfig.Tracks[trackType][i]
+      return
+    }
+  }
+
+  return
+}
+
+// Codecs parameter of an HEVC track (ISO/IEC 14496-15 Annex E) like "hvc1.1.6.L93.B0": profile space and profile,
+// compatibility flags in reverse bit order, tier and level, then the constraint flags bytes without the trailing zero ones
+func hevcCodecs(entry string, hvcC mp4.HvcCBox) (codecs string) {
+  profileSpace := []string{ "", "A", "B", "C" }[hvcC.GeneralProfileSpaceFlagIdc >> 6]
+  var compatibilityFlags uint32
+  for i := uint(0); i < 32; i++ {
+    if hvcC.GeneralProfileCompatibilityFlags & (1 << i) != 0 {
+      compatibilityFlags |= 1 << (31 - i)
+    }
+  }
+  tier := "L"
+  if hvcC.GeneralProfileSpaceFlagIdc & 0x20 != 0 {
+    tier = "H"
+  }
+  codecs = fmt.Sprintf("%s.%s%d.%X.%s%d", entry, profileSpace, hvcC.GeneralProfileSpaceFlagIdc & 0x1F, compatibilityFlags, tier, hvcC.GeneralLevelIdc)
+  constraints := make([]byte, 6)
+  binary.BigEndian.PutUint32(constraints[0:4], hvcC.GeneralConstraintIndicatorFlagsHigh)
+  binary.BigEndian.PutUint16(constraints[4:6], hvcC.GeneralConstraintIndicatorFlagsLow)
+  last := len(constraints) - 1
+  for last >= 0 && constraints[last] == 0 {
+    last--
+  }
+  for i := 0; i <= last; i++ {
+    codecs += fmt.Sprintf(".%X", constraints[i])
+  }
+
+  return
+}
+
+// Codecs parameter of a VP9 track like "vp09.00.41.08": profile, level and bit depth, followed by the colour fields
+// when they differ from the default 4:2:0 BT.709 limited range ones
+func vp9Codecs(vpcC mp4.VpcCBox) (codecs string) {
+  codecs = fmt.Sprintf("vp09.%.2d.%.2d.%.2d", vpcC.Profile, vpcC.Level, vpcC.BitDepth)
+  fullRange := 0
+  if vpcC.VideoFullRangeFlag {
+    fullRange = 1
+  }
+  if vpcC.ChromaSubsampling != 1 || vpcC.ColourPrimaries != 1 || vpcC.TransferCharacteristics != 1 || vpcC.MatrixCoefficients != 1 || fullRange != 0 {
+    codecs += fmt.Sprintf(".%.2d.%.2d.%.2d.%.2d.%.2d", vpcC.ChromaSubsampling, vpcC.ColourPrimaries, vpcC.TransferCharacteristics, vpcC.MatrixCoefficients, fullRange)
+  }
+
+  return
+}
+
+// Codecs parameter of an AV1 track like "av01.0.08M.08": profile, level, tier and bit depth
+func av1Codecs(av1C mp4.Av1CBox) string {
+  tier := "M"
+  if av1C.SeqTier0 != 0 {
+    tier = "H"
+  }
+
+  return fmt.Sprintf("av01.%d.%.2d%s.%.2d", av1C.SeqProfile, av1C.SeqLevelIdx0, tier, av1C.BitDepth())
+}
+
+// Codecs parameter of an mp4v track: "mp4v.20." followed by the decimal MPEG-4 Part 2 profile and level (eg:
+// "mp4v.20.245"), or the hexadecimal object type indication of the other video formats (eg: "mp4v.6C" for JPEG)
+func mp4vCodecs(v mp4.DashVideoEntry) string {
+  if v.ObjectTypeIndication != 0x20 {
+    return fmt.Sprintf("mp4v.%.2X", v.ObjectTypeIndication)
+  }
+  if v.ProfileLevelIndication == 0 {
+    return "mp4v.20"
+  }
+
+  return fmt.Sprintf("mp4v.20.%d", v.ProfileLevelIndication)
+}
+
+func trackCodecs(t mp4.TrackEntry) (codecs string) {
+  switch t.Config.Type {
+    case "video":
+      v := t.Config.Video
+      switch {
+        case v.HvcC != nil:
+          codecs = hevcCodecs(v.Codec, *v.HvcC)
+        case v.VpcC != nil:
+          codecs = vp9Codecs(*v.VpcC)
+        case v.Av1C != nil:
+          codecs = av1Codecs(*v.Av1C)
+        case v.Esds != nil:
+          codecs = mp4vCodecs(*v)
+        default:
+          codecs = fmt.Sprintf("avc1.%.2X%.2X%.2X", v.CodecInfo[0], v.CodecInfo[1], v.CodecInfo[2])
+      }
+    case "audio":
+      a := t.Config.Audio
+      switch {
+        case a.Codec != "":
+          // ac-3, ec-3, opus or flac
+          codecs = strings.ToLower(a.Codec)
+        case a.ObjectTypeIndication != 0 && a.ObjectTypeIndication != 0x40:
+          codecs = fmt.Sprintf("mp4a.%.2X", a.ObjectTypeIndication)
+        case a.Ps:
+          codecs = "mp4a.40.29"
+        case a.Sbr:
+          codecs = "mp4a.40.5"
+        case a.ObjectType != 0:
+          codecs = fmt.Sprintf("mp4a.40.%d", a.ObjectType)
+        default:
+          codecs = "mp4a.40.2"
+      }
+  }
+
+  return
+}
+
+// DASH role of a track, tracks without role are the main ones
+func trackRole(t mp4.TrackEntry) string {
+  if t.Role == "" {
+    return "main"
+  }
+
+  return t.Role
+}
+
+// Name of a track shown by the players: its label or its language followed by its role
+func trackLabel(t mp4.TrackEntry) (label string) {
+  if t.Label != "" {
+    return t.Label
+  }
+  label = t.Lang
+  if trackRole(t) != "main" {
+    label += " (" + trackRole(t) + ")"
+  }
+
+  return
+}
+
+// AAC tracks are mp4a ones with an MPEG-4 audio object type, MP3 ones are mp4a.6B or mp4a.69
+func isAacTrack(t mp4.TrackEntry) bool {
+  a := t.Config.Audio
+
+  return a.Codec == "" && (a.ObjectTypeIndication == 0 || a.ObjectTypeIndication == 0x40)
+}
+
+// Audio tracks grouped by language, role and codec (AAC, AC-3, E-AC-3, Opus, FLAC or MP3), in the order of the package file
+func audioGroups(tracks []mp4.TrackEntry) (groups [][]mp4.TrackEntry) {
+  index := make(map[string]int)
+  for _, t := range tracks {
+    key := t.Lang + "/" + trackRole(t) + "/" + hlsAudioGroupId(t)
+    i, ok := index[key]
+    if !ok {
+      i = len(groups)
+      index[key] = i
+      groups = append(groups, nil)
+    }
+    groups[i] = append(groups[i], t)
+  }
+
+  return
+}
+
+// Video tracks grouped by codec (AVC, HEVC, VP9, AV1 or MPEG-4 Part 2), AVC first then in the order of the package file
+func videoGroups(tracks []mp4.TrackEntry) (groups [][]mp4.TrackEntry) {
+  index := map[string]int{ "avc": 0 }
+  groups = append(groups, nil)
+  for _, t := range tracks {
+    v := t.Config.Video
+    key := "avc"
+    switch {
+      case v.HvcC != nil:
+        key = "hevc"
+      case v.VpcC != nil:
+        key = "vp9"
+      case v.Av1C != nil:
+        key = "av1"
+      case v.Esds != nil:
+        key = "mp4v"
+    }
+    i, ok := index[key]
+    if !ok {
+      i = len(groups)
+      index[key] = i
+      groups = append(groups, nil)
+    }
+    groups[i] = append(groups[i], t)
+  }
+  if groups[0] == nil {
+    groups = groups[1:]
+  }
+
+  return
+}
+
+// Index of the audio group selected by default: the first main one
+func defaultAudioGroup(groups [][]mp4.TrackEntry) int {
+  for i, group := range groups {
+    if trackRole(group[0]) == "main" {
+      return i
+    }
+  }
+
+  return 0
+}
+
+func presentationDuration(jConf mp4.JsonConfig) (duration float64) {
+  if jConf.Tracks["video"] != nil {
+    duration = float64(jConf.Tracks["video"][0].Config.Duration) / float64(jConf.Tracks["video"][0].Config.Timescale)
+  } else {
+    duration = float64(jConf.Tracks["audio"][0].Config.Duration) / float64(jConf.Tracks["audio"][0].Config.Timescale)
+  }
+
+  return
+}
+
+// HLS audio group of a track: AAC renditions are in the "audio" group, the other ones in a group per codec
+func hlsAudioGroupId(t mp4.TrackEntry) string {
+  if isAacTrack(t) {
+    return "audio"
+  }
+
+  return "audio-" + trackCodecs(t)
+}
+
+// Quoted strings of HLS attributes cannot contain double quotes or line breaks
+func hlsQuotedString(s string) string {
+  return strings.NewReplacer(`"`, "'", "\r", " ", "\n", " ").Replace(s)
+}
+
+func createHlsMasterPlaylist(jConf mp4.JsonConfig, videoId string) (playlist string, err error) {
+  playlist = "#EXTM3U\n"
+  playlist += "## Created with Afrostream Media Server\n"
+  playlist += "#EXT-X-VERSION:7\n"
+  playlist += "#EXT-X-INDEPENDENT-SEGMENTS\n"
+  playlist += "\n"
+
+  // One audio group per codec, the video variants are listed once per audio group
+  var audioGroupIds []string
+  maxAudioBandwidth := make(map[string]uint64)
+  audioCodecs := make(map[string]string)
+  for _, t := range jConf.Tracks["audio"] {
+    id := hlsAudioGroupId(t)
+    if _, ok := audioCodecs[id]; !ok {
+      audioGroupIds = append(audioGroupIds, id)
+      audioCodecs[id] = trackCodecs(t)
+    }
+    if t.Bandwidth > maxAudioBandwidth[id] {
+      maxAudioBandwidth[id] = t.Bandwidth
+    }
+  }
+  // Only one rendition per language and role in an audio group
+  groups := audioGroups(jConf.Tracks["audio"])
+  for _, id := range audioGroupIds {
+    var idGroups [][]mp4.TrackEntry
+    for _, group := range groups {
+      if hlsAudioGroupId(group[0]) == id {
+        idGroups = append(idGroups, group)
+      }
+    }
+    defaultGroup := defaultAudioGroup(idGroups)
+    for i, group := range idGroups {
+      t := group[0]
+      isDefault := "NO"
+      if i == defaultGroup {
+        isDefault = "YES"
+      }
+      autoSelect := "YES"
+      characteristics := ""
+      switch trackRole(t) {
+        case "commentary":
+          autoSelect = "NO"
+        case "description":
+          characteristics = `,CHARACTERISTICS="public.accessibility.describes-video"`
+      }
+      playlist += fmt.Sprintf(`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="%s",LANGUAGE="%s",NAME="%s",AUTOSELECT=%s,DEFAULT=%s%s,CHANNELS="%d",URI="hls/%s-%s=%d.m3u8"`, id, t.Lang, hlsQuotedString(trackLabel(t)), autoSelect, isDefault, characteristics, mp4.AudioChannelCount(*t.Config.Audio), videoId, t.Name, t.Bandwidth) + "\n"
+    }
+  }
+  for i, t := range jConf.Tracks["subtitle"] {
+    isDefault := "NO"
+    if i == 0 {
+      isDefault = "YES"
+    }
+    playlist += fmt.Sprintf(`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",LANGUAGE="%s",NAME="%s",AUTOSELECT=YES,DEFAULT=%s,URI="hls/%s-%s=%d.m3u8"`, t.Lang, hlsQuotedString(trackLabel(t)), isDefault, videoId, t.Name, t.Bandwidth) + "\n"
+  }
+
+  if jConf.Tracks["video"] != nil {
+    variants := audioGroupIds
+    if variants == nil {
+      variants = []string{ "" }
+    }
+    for _, id := range variants {
+      for _, t := range jConf.Tracks["video"] {
+        s := fmt.Sprintf(`#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d`, t.Bandwidth + maxAudioBandwidth[id], t.Config.Video.Width, t.Config.Video.Height)
+        if id != "" {
+          s += fmt.Sprintf(`,CODECS="%s,%s",AUDIO="%s"`, trackCodecs(t), audioCodecs[id], id)
+        } else {
+          s += fmt.Sprintf(`,CODECS="%s"`, trackCodecs(t))
+        }
+        if jConf.Tracks["subtitle"] != nil {
+          s += `,SUBTITLES="subs"`
+        }
+        playlist += s + "\n"
+        playlist += fmt.Sprintf(`hls/%s-%s=%d.m3u8`, videoId, t.Name, t.Bandwidth) + "\n"
+      }
+    }
+  } else {
+    if jConf.Tracks["audio"] == nil {
+      err = errors.New("cannot found valid audio or video tracks")
+      return
+    }
+    for _, t := range jConf.Tracks["audio"] {
+      playlist += fmt.Sprintf(`#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS="%s"`, t.Bandwidth, trackCodecs(t)) + "\n"
+      playlist += fmt.Sprintf(`hls/%s-%s=%d.m3u8`, videoId, t.Name, t.Bandwidth) + "\n"
+    }
+  }
+
+  return
+}
+
+// EXT-X-KEY tag of a key of a cbcs protected asset, {kid} is replaced by the hexadecimal key id in SkdUri
+func createHlsSampleAesKey(jConf mp4.JsonConfig, keyId [16]byte) string {
+  skdUri := ""
+  if jConf.Drm != nil {
+    skdUri = jConf.Drm.SkdUri
+  }
+  if skdUri == "" {
+    skdUri = "skd://{kid}"
+  }
+  skdUri = strings.Replace(skdUri, "{kid}", hex.EncodeToString(keyId[:]), -1)
+
+  return fmt.Sprintf(`#EXT-X-KEY:METHOD=SAMPLE-AES,URI="%s",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"`, skdUri) + "\n"
+}
+
+func createHlsMediaPlaylist(jConf mp4.JsonConfig, t mp4.TrackEntry, videoId string, dir string) (playlist string, err error) {
+  var segments []mp4.DashSegment
+  var targetDuration float64
+  if t.Config != nil {
+    segments = mp4.GetDashSegmentsWithConf(*t.Config, dir + "/" + t.File, jConf.SegmentDuration)
+    if segments == nil {
+      err = errors.New("cannot found any segment for track " + t.Name)
+      return
+    }
+    for _, segment := range segments {
+      d := float64(segment.Duration) / float64(t.Config.Timescale)
+      if d > targetDuration {
+        targetDuration = d
+      }
+    }
+  } else {
+    // External subtitles are sent in one segment
+    targetDuration = presentationDuration(jConf)
+  }
+
+  playlist = "#EXTM3U\n"
+  playlist += "## Created with Afrostream Media Server\n"
+  playlist += "#EXT-X-VERSION:7\n"
+  playlist += fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(targetDuration)))
+  playlist += "#EXT-X-MEDIA-SEQUENCE:1\n"
+  playlist += "#EXT-X-PLAYLIST-TYPE:VOD\n"
+  playlist += "#EXT-X-INDEPENDENT-SEGMENTS\n"
+  if t.Config != nil {
+    var keyId [16]byte
+    if t.Config.Encryption != nil {
+      period, _, e := t.Config.Encryption.KeyPeriodAt(segments[0].Time, t.Config.Timescale)
+      if e != nil {
+        err = e
+        return
+      }
+      keyId = period.KeyId
+      playlist += createHlsSampleAesKey(jConf, keyId)
+    }
+    playlist += fmt.Sprintf(`#EXT-X-MAP:URI="../dash/%s-%s=%d.dash"`, videoId, t.Name, t.Bandwidth) + "\n"
+    for _, segment := range segments {
+      // With key rotation a new key is signalled at the first segment of each key period
+      if t.Config.Encryption != nil {
+        period, _, e := t.Config.Encryption.KeyPeriodAt(segment.Time, t.Config.Timescale)
+        if e != nil {
+          err = e
+          return
+        }
+        if period.KeyId != keyId {
+          keyId = period.KeyId
+          playlist += createHlsSampleAesKey(jConf, keyId)
+        }
+      }
+      playlist += fmt.Sprintf("#EXTINF:%.3f,\n", float64(segment.Duration) / float64(t.Config.Timescale))
+      playlist += fmt.Sprintf("../dash/%s-%s=%d-%d.m4s\n", videoId, t.Name, t.Bandwidth, segment.Number)
+    }
+  } else {
+    playlist += fmt.Sprintf("#EXTINF:%.3f,\n", targetDuration)
+    playlist += fmt.Sprintf("../subtitles/%s\n", t.File)
+  }
+  playlist += "#EXT-X-ENDLIST\n"
+
+  return
+}
+
+// Audio track muxed with the video tracks in MPEG-2 TS segments: the first AAC one
+func tsAudioTrack(jConf mp4.JsonConfig) (track *mp4.TrackEntry) {
+  for i, t := range jConf.Tracks["audio"] {
+    if isAacTrack(t) {
+      return &jConf.Tracks["audio"][i]
+    }
+  }
+
+  return
+}
+
+func createHlsTsMasterPlaylist(jConf mp4.JsonConfig, videoId string) (playlist string, err error) {
+  playlist = "#EXTM3U\n"
+  playlist += "## Created with Afrostream Media Server\n"
+  playlist += "#EXT-X-VERSION:3\n"
+  playlist += "\n"
+
+  audioTrack := tsAudioTrack(jConf)
+  if jConf.Tracks["video"] != nil {
+    for _, t := range jConf.Tracks["video"] {
+      // Only AVC video can be muxed in MPEG-2 TS
+      if t.Config.Video.Codec != "" {
+        continue
+      }
+      if audioTrack != nil {
+        playlist += fmt.Sprintf(`#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS="%s,%s"`, t.Bandwidth + audioTrack.Bandwidth, t.Config.Video.Width, t.Config.Video.Height, trackCodecs(t), trackCodecs(*audioTrack)) + "\n"
+      } else {
+        playlist += fmt.Sprintf(`#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS="%s"`, t.Bandwidth, t.Config.Video.Width, t.Config.Video.Height, trackCodecs(t)) + "\n"
+      }
+      playlist += fmt.Sprintf(`ts/%s-%s=%d.m3u8`, videoId, t.Name, t.Bandwidth) + "\n"
+    }
+  } else {
+    if audioTrack == nil {
+      err = errors.New("cannot found valid audio or video tracks")
+      return
+    }
+    for _, t := range jConf.Tracks["audio"] {
+      // Only AAC audio can be muxed in MPEG-2 TS
+      if !isAacTrack(t) {
+        continue
+      }
+      playlist += fmt.Sprintf(`#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS="%s"`, t.Bandwidth, trackCodecs(t)) + "\n"
+      playlist += fmt.Sprintf(`ts/%s-%s=%d.m3u8`, videoId, t.Name, t.Bandwidth) + "\n"
+    }
+  }
+
+  return
+}
+
+func createHlsTsMediaPlaylist(jConf mp4.JsonConfig, t mp4.TrackEntry, videoId string, dir string) (playlist string, err error) {
+  segments := mp4.GetDashSegmentsWithConf(*t.Config, dir + "/" + t.File, jConf.SegmentDuration)
+  if segments == nil {
+    err = errors.New("cannot found any segment for track " + t.Name)
+    return
+  }
+  var targetDuration float64
+  for _, segment := range segments {
+    d := float64(segment.Duration) / float64(t.Config.Timescale)
+    if d > targetDuration {
+      targetDuration = d
+    }
+  }
+
+  playlist = "#EXTM3U\n"
+  playlist += "## Created with Afrostream Media Server\n"
+  playlist += "#EXT-X-VERSION:3\n"
+  playlist += fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(targetDuration)))
+  playlist += "#EXT-X-MEDIA-SEQUENCE:1\n"
+  playlist += "#EXT-X-PLAYLIST-TYPE:VOD\n"
+  var keyId [16]byte
+  if isTsProtected(jConf) {
+    keyId, _, err = readHlsKey(jConf, dir)
+    if err != nil {
+      return
+    }
+  }
+  for i, segment := range segments {
+    if isTsProtected(jConf) {
+      iv, explicit, e := hlsSegmentIv(jConf, keyId, uint32(1 + i))
+      if e != nil {
+        err = e
+        return
+      }
+      // Only derived IVs change at each segment
+      if i == 0 || jConf.HlsEncryption.Iv == "hash" {
+        keyUri := hlsKeyUri(dir, videoId, keyId)
+        if explicit {
+          playlist += fmt.Sprintf(`#EXT-X-KEY:METHOD=AES-128,URI="%s",IV=0x%s`, keyUri, hex.EncodeToString(iv[:])) + "\n"
+        } else {
+          playlist += fmt.Sprintf(`#EXT-X-KEY:METHOD=AES-128,URI="%s"`, keyUri) + "\n"
+        }
+      }
+    }
+    playlist += fmt.Sprintf("#EXTINF:%.3f,\n", float64(segment.Duration) / float64(t.Config.Timescale))
+    playlist += fmt.Sprintf("%s-%s=%d-%d.ts\n", videoId, t.Name, t.Bandwidth, segment.Number)
+  }
+  playlist += "#EXT-X-ENDLIST\n"
+
+  return
+}
+
+func smoothCodecPrivateData(t mp4.TrackEntry) (codecPrivateData string) {
+  if t.Config.Type == "video" && t.Config.Video.HvcC != nil {
+    for _, nalArray := range t.Config.Video.HvcC.NalArray {
+      for _, nalUnit := range nalArray.NalUnits {
+        codecPrivateData += fmt.Sprintf("00000001%X", nalUnit)
+      }
+    }
+  } else if t.Config.Type == "video" {
+    v := t.Config.Video
+    var i uint32
+    for i = 0; i < uint32(v.SPSEntryCount); i++ {
+      codecPrivateData += fmt.Sprintf("00000001%X", v.SPSData[i * uint32(v.SPSSize):(i + 1) * uint32(v.SPSSize)])
+    }
+    for i = 0; i < uint32(v.PPSEntryCount); i++ {
+      codecPrivateData += fmt.Sprintf("00000001%X", v.PPSData[i * uint32(v.PPSSize):(i + 1) * uint32(v.PPSSize)])
+    }
+  } else {
+    codecPrivateData = fmt.Sprintf("%X", mp4.AacAudioSpecificConfig(*t.Config))
+  }
+
+  return
+}
+
+func createSmoothStreamIndex(tracks []mp4.TrackEntry, name string, dir string, sDuration uint32) (s string, err error) {
+  t := tracks[0]
+  segments := mp4.GetDashSegmentsWithConf(*t.Config, dir + "/" + t.File, sDuration)
+  if segments == nil {
+    err = errors.New("cannot found any segment for track " + t.Name)
+    return
+  }
+  url := fmt.Sprintf("QualityLevels({bitrate})/Fragments(%s={start time})", name)
+  if t.Config.Type == "video" {
+    var maxWidth uint16
+    var maxHeight uint16
+    for _, t := range tracks {
+      if t.Config.Video.Width > maxWidth {
+        maxWidth = t.Config.Video.Width
+      }
+      if t.Config.Video.Height > maxHeight {
+        maxHeight = t.Config.Video.Height
+      }
+    }
+    s += fmt.Sprintf(`  <StreamIndex Type="video" Name="%s" Url="%s" TimeScale="%d" Chunks="%d" QualityLevels="%d" MaxWidth="%d" MaxHeight="%d" DisplayWidth="%d" DisplayHeight="%d">`, name, url, t.Config.Timescale, len(segments), len(tracks), maxWidth, maxHeight, maxWidth, maxHeight) + "\n"
+    for i, t := range tracks {
+      fourCC := "H264"
+      if t.Config.Video.HvcC != nil {
+        fourCC = strings.ToUpper(t.Config.Video.Codec)
+      }
+      s += fmt.Sprintf(`    <QualityLevel Index="%d" Bitrate="%d" FourCC="%s" MaxWidth="%d" MaxHeight="%d" CodecPrivateData="%s" />`, i, t.Bandwidth, fourCC, t.Config.Video.Width, t.Config.Video.Height, smoothCodecPrivateData(t)) + "\n"
+    }
+  } else {
+    s += fmt.Sprintf(`  <StreamIndex Type="audio" Name="%s" Language="%s" Url="%s" TimeScale="%d" Chunks="%d" QualityLevels="%d">`, name, t.Lang, url, t.Config.Timescale, len(segments), len(tracks)) + "\n"
+    for i, t := range tracks {
+      fourCC := "AACL"
+      if t.Config.Audio.Sbr {
+        fourCC = "AACH"
+      }
+      s += fmt.Sprintf(`    <QualityLevel Index="%d" Bitrate="%d" FourCC="%s" SamplingRate="%d" Channels="%d" BitsPerSample="%d" PacketSize="4" AudioTag="255" CodecPrivateData="%s" />`, i, t.Bandwidth, fourCC, t.Config.Audio.SampleRate >> 16, mp4.AudioChannelCount(*t.Config.Audio), t.Config.Audio.SampleSize, smoothCodecPrivateData(t)) + "\n"
+    }
+  }
+  for _, segment := range segments {
+    s += fmt.Sprintf(`    <c t="%d" d="%d" />`, segment.Time, segment.Duration) + "\n"
+  }
+  s += `  </StreamIndex>` + "\n"
+
+  return
+}
+
+// Video tracks are all in the "video" stream, audio tracks are grouped by track name (one stream per language)
+func createSmoothManifest(jConf mp4.JsonConfig, dir string) (manifest string, err error) {
+  manifest = `<?xml version="1.0" encoding="utf-8"?>` + "\n"
+  manifest += `<!-- Created with Afrostream Media Server -->` + "\n"
+  manifest += fmt.Sprintf(`<SmoothStreamingMedia MajorVersion="2" MinorVersion="2" TimeScale="10000000" Duration="%d">`, uint64(presentationDuration(jConf) * 10000000)) + "\n"
+  // Smooth Streaming video is AVC or HEVC only
+  var videoTracks []mp4.TrackEntry
+  for _, t := range jConf.Tracks["video"] {
+    if t.Config.Video.Codec == "" || t.Config.Video.HvcC != nil {
+      videoTracks = append(videoTracks, t)
+    }
+  }
+  if videoTracks != nil {
+    s, err := createSmoothStreamIndex(videoTracks, "video", dir, jConf.SegmentDuration)
+    if err != nil {
+      return "", err
+    }
+    manifest += s
+  }
+  var names []string
+  audioTracks := make(map[string][]mp4.TrackEntry)
+  for _, t := range jConf.Tracks["audio"] {
+    // Smooth Streaming audio is AAC only
+    if !isAacTrack(t) {
+      continue
+    }
+    if audioTracks[t.Name] == nil {
+      names = append(names, t.Name)
+    }
+    audioTracks[t.Name] = append(audioTracks[t.Name], t)
+  }
+  for _, name := range names {
+    s, err := createSmoothStreamIndex(audioTracks[name], name, dir, jConf.SegmentDuration)
+    if err != nil {
+      return "", err
+    }
+    manifest += s
+  }
+  manifest += `</SmoothStreamingMedia>` + "\n"
+
+  return
+}
+
+// SegmentTimeline of a track, consecutive segments with the same duration are merged with S@r
+func createSegmentTimeline(segments []mp4.DashSegment, indent string) (s string) {
+  s = indent + `<SegmentTimeline>` + "\n"
+  for i := 0; i < len(segments); {
+    r := 0
+    for i + r + 1 < len(segments) && segments[i + r + 1].Duration == segments[i].Duration && segments[i + r + 1].Time == segments[i + r].Time + segments[i + r].Duration {
+      r++
+    }
+    if r > 0 {
+      s += fmt.Sprintf(`%s  <S t="%d" d="%d" r="%d" />`, indent, segments[i].Time, segments[i].Duration, r) + "\n"
+    } else {
+      s += fmt.Sprintf(`%s  <S t="%d" d="%d" />`, indent, segments[i].Time, segments[i].Duration) + "\n"
+    }
+    i += r + 1
+  }
+  s += indent + `</SegmentTimeline>` + "\n"
+
+  return
+}
+
+func createSegmentTemplate(t mp4.TrackEntry, segments []mp4.DashSegment, videoId string, indent string) (s string) {
+  s = indent + `<SegmentTemplate` + "\n"
+  s += fmt.Sprintf(`%s  timescale="%d"`, indent, t.Config.Timescale) + "\n"
+  s += fmt.Sprintf(`%s  initialization="%s-$RepresentationID$.dash"`, indent, videoId) + "\n"
+  s += fmt.Sprintf(`%s  media="%s-$RepresentationID$-t$Time$.m4s">`, indent, videoId) + "\n"
+  s += createSegmentTimeline(segments, indent + "  ")
+  s += indent + `</SegmentTemplate>` + "\n"
+
+  return
+}
+
+// SegmentTemplates with $Time$ addressing, the AdaptationSet one is shared by all representations if they have
+// the same SegmentTimeline, otherwise each Representation has its own
+func createSegmentTemplates(tracks []mp4.TrackEntry, videoId string, dir string, sDuration uint32) (sharedTemplate string, templates []string, err error) {
+  var segments [][]mp4.DashSegment
+  shared := true
+  for i, t := range tracks {
+    trackSegments := mp4.GetDashSegmentsWithConf(*t.Config, dir + "/" + t.File, sDuration)
+    if trackSegments == nil {
+      err = errors.New("cannot found any segment for track " + t.Name)
+      return
+    }
+    segments = append(segments, trackSegments)
+    if i > 0 && (t.Config.Timescale != tracks[0].Config.Timescale || createSegmentTimeline(trackSegments, "") != createSegmentTimeline(segments[0], "")) {
+      shared = false
+    }
+  }
+  if shared {
+    sharedTemplate = createSegmentTemplate(tracks[0], segments[0], videoId, "      ")
+    return
+  }
+  for i, t := range tracks {
+    templates = append(templates, createSegmentTemplate(t, segments[i], videoId, "        "))
+  }
+
+  return
+}
+
+// On-demand files are built once per track and kept in memory for the manifest and the range requests, the least
+// recently used ones are forgotten when there are more than maxOnDemandFiles
+type onDemandEntry struct {
+  key     string
+  once    sync.Once // The file is built outside of onDemandFilesMutex, once for all the requests waiting for it
+  file    *mp4.DashOnDemandFile
+  element *list.Element
+}
+
+var onDemandFiles = make(map[string]*onDemandEntry)
+var onDemandFilesLru = list.New()
+var onDemandFilesMutex sync.Mutex
+var maxOnDemandFiles = 256
+
+func getDashOnDemandFile(t mp4.TrackEntry, dir string, sDuration uint32) (file *mp4.DashOnDemandFile, err error) {
+  // Files with several tracks have one on-demand file per track
+  key := fmt.Sprintf("%s/%s#%d:%d", dir, t.File, t.TrackId, sDuration)
+  if t.Config.Encryption != nil {
+    // The same media file can be shared by clear and protected assets
+    key += fmt.Sprintf(":%x:%x", t.Config.Encryption.KeyId, t.Config.Encryption.Iv)
+  }
+  onDemandFilesMutex.Lock()
+  entry := onDemandFiles[key]
+  if entry == nil {
+    entry = &onDemandEntry{ key: key }
+    entry.element = onDemandFilesLru.PushFront(entry)
+    onDemandFiles[key] = entry
+    for onDemandFilesLru.Len() > maxOnDemandFiles {
+      oldest := onDemandFilesLru.Remove(onDemandFilesLru.Back()).(*onDemandEntry)
+      delete(onDemandFiles, oldest.key)
+    }
+  } else {
+    onDemandFilesLru.MoveToFront(entry.element)
+  }
+  onDemandFilesMutex.Unlock()
+
+  entry.once.Do(func() {
+    entry.file = mp4.CreateDashOnDemandFileWithConf(*t.Config, dir + "/" + t.File, sDuration)
+  })
+  if entry.file == nil {
+    // Failed files are built again by the next requests
+    onDemandFilesMutex.Lock()
+    if onDemandFiles[key] == entry {
+      onDemandFilesLru.Remove(entry.element)
+      delete(onDemandFiles, key)
+    }
+    onDemandFilesMutex.Unlock()
+    err = errors.New("cannot create on-demand file for track " + t.Name)
+    return
+  }
+  file = entry.file
+
+  return
+}
+
+// BaseURL and SegmentBase of each Representation of the isoff-on-demand profile
+func createSegmentBases(tracks []mp4.TrackEntry, videoId string, dir string, sDuration uint32) (segmentBases []string, err error) {
+  for _, t := range tracks {
+    file, err := getDashOnDemandFile(t, dir, sDuration)
+    if err != nil {
+      return nil, err
+    }
+    s := fmt.Sprintf(`        <BaseURL>%s-%s=%d.mp4</BaseURL>`, videoId, t.Name, t.Bandwidth) + "\n"
+    s += fmt.Sprintf(`        <SegmentBase indexRange="%d-%d">`, file.InitSize, len(file.Header) - 1) + "\n"
+    s += fmt.Sprintf(`          <Initialization range="0-%d" />`, file.InitSize - 1) + "\n"
+    s += `        </SegmentBase>` + "\n"
+    segmentBases = append(segmentBases, s)
+  }
+
+  return
+}
+
 func createExternalSubtitlesAdaptationSet(tracks []mp4.TrackEntry) (s string, err error) {
   s = ""
   for _, t := range tracks {
     s += fmt.Sprintf(`    <AdaptationSet mimeType="text/vtt" lang="%s">`, t.Lang) + "\n"
+    if t.Label != "" {
+      s += fmt.Sprintf(`      <Label>%s</Label>`, html.EscapeString(t.Label)) + "\n"
+    }
     s += fmt.Sprintf(`      <Representation id="%s" bandwidth="%d">`, t.Name, t.Bandwidth) + "\n"
-    s += fmt.Sprintf(`        <BaseURL>../../%s</BaseURL>`, t.File) + "\n"
+    s += fmt.Sprintf(`        <BaseURL>../subtitles/%s</BaseURL>`, t.File) + "\n"
     s += `      </Representation>` + "\n"
     s += `    </AdaptationSet>` + "\n"
   }
@@ -88,7 +1339,7 @@ func createExternalSubtitlesAdaptationSet(tracks []mp4.TrackEntry) (s string, er
   return
 }
 
-func createAudioAdaptationSet(tracks []mp4.TrackEntry, videoId string, sDuration uint32) (s string, err error) {
+func createAudioAdaptationSet(tracks []mp4.TrackEntry, videoId string, dir string, sDuration uint32, onDemand bool, clearKeyUrl string) (s string, err error) {
   var minBandwidth uint64
   var maxBandwidth uint64
 
@@ -109,28 +1360,49 @@ func createAudioAdaptationSet(tracks []mp4.TrackEntry, videoId string, sDuration
   s = `    <AdaptationSet` + "\n"
   s += fmt.Sprintf(`      group="%d"`, 1) + "\n"
   s += `      contentType="audio"` + "\n"
-  s += `      lang="en"` + "\n"
+  s += fmt.Sprintf(`      lang="%s"`, tracks[0].Lang) + "\n"
   s += fmt.Sprintf(`      minBandwidth="%d"`, minBandwidth) + "\n"
   s += fmt.Sprintf(`      maxBandwidth="%d"`, maxBandwidth) + "\n"
   s += `      segmentAlignment="true"` + "\n"
-  s += fmt.Sprintf(`      audioSamplingRate="%d"`, tracks[0].Config.Timescale) + "\n"
-  s += `      mimeType="audio/mp4"` + "\n"
-  s += `      codecs="mp4a.40.2">` + "\n"
-  s += `      <AudioChannelConfiguration` + "\n"
-  s += `        schemeIdUri="urn:mpeg:dash:23003:3:audio_channel_configuration:2011"` + "\n"
-  s += fmt.Sprintf(`        value="%d">`, tracks[0].Config.Audio.NumberOfChannels) + "\n"
-  s += `      </AudioChannelConfiguration>` + "\n"
-  s += `      <SegmentTemplate` + "\n"
-  s += fmt.Sprintf(`        timescale="%d"`, tracks[0].Config.Timescale) + "\n"
-  s += fmt.Sprintf(`        initialization="%s-$RepresentationID$.dash"`, videoId) + "\n"
-  s += fmt.Sprintf(`        media="%s-$RepresentationID$-$Number$.m4s"`, videoId) + "\n"
-  s += `        startNumber="1"` + "\n"
-  s += fmt.Sprintf(`        duration="%d">`, sDuration * tracks[0].Config.Timescale) + "\n"
-  s += `      </SegmentTemplate>` + "\n"
-  for _, t := range tracks {
+  s += `      mimeType="audio/mp4">` + "\n"
+  s += createContentProtection(tracks[0], clearKeyUrl)
+  s += fmt.Sprintf(`      <Label>%s</Label>`, html.EscapeString(trackLabel(tracks[0]))) + "\n"
+  if trackRole(tracks[0]) == "description" {
+    // Audio description for the visually impaired
+    s += `      <Accessibility schemeIdUri="urn:tva:metadata:cs:AudioPurposeCS:2007" value="1"/>` + "\n"
+  }
+  s += fmt.Sprintf(`      <Role schemeIdUri="urn:mpeg:dash:role:2011" value="%s"/>`, trackRole(tracks[0])) + "\n"
+  var sharedTemplate string
+  var templates []string
+  if onDemand {
+    templates, err = createSegmentBases(tracks, videoId, dir, sDuration)
+  } else {
+    sharedTemplate, templates, err = createSegmentTemplates(tracks, videoId, dir, sDuration)
+  }
+  if err != nil {
+    return
+  }
+  s += sharedTemplate
+  for i, t := range tracks {
     s += `      <Representation` + "\n"
     s += fmt.Sprintf(`        id="%s=%d"`, t.Name, t.Bandwidth) + "\n"
-    s += fmt.Sprintf(`        bandwidth="%d">`, t.Bandwidth) + "\n"
+    s += fmt.Sprintf(`        bandwidth="%d"`, t.Bandwidth) + "\n"
+    // Tracks of an adaptation set may have different codecs and channel layouts (eg: stereo AAC and 5.1 E-AC-3)
+    s += fmt.Sprintf(`        audioSamplingRate="%d"`, t.Config.Timescale) + "\n"
+    s += fmt.Sprintf(`        codecs="%s">`, trackCodecs(t)) + "\n"
+    s += `        <AudioChannelConfiguration` + "\n"
+    if configuration := mp4.DolbyChannelConfiguration(*t.Config.Audio); configuration != 0 {
+      // Dolby tracks signal their channel layout
+      s += `          schemeIdUri="tag:dolby.com,2014:dash:audio_channel_configuration:2011"` + "\n"
+      s += fmt.Sprintf(`          value="%04X">`, configuration) + "\n"
+    } else {
+      s += `          schemeIdUri="urn:mpeg:dash:23003:3:audio_channel_configuration:2011"` + "\n"
+      s += fmt.Sprintf(`          value="%d">`, mp4.AudioChannelCount(*t.Config.Audio)) + "\n"
+    }
+    s += `        </AudioChannelConfiguration>` + "\n"
+    if templates != nil {
+      s += templates[i]
+    }
     s += `      </Representation>` + "\n"
   }
   s += `    </AdaptationSet>` + "\n"
@@ -138,7 +1410,7 @@ func createAudioAdaptationSet(tracks []mp4.TrackEntry, videoId string, sDuration
   return
 }
 
-func createVideoAdaptationSet(tracks []mp4.TrackEntry, videoId string, sDuration uint32) (s string, err error) {
+func createVideoAdaptationSet(tracks []mp4.TrackEntry, videoId string, dir string, sDuration uint32, onDemand bool, clearKeyUrl string) (s string, err error) {
   var minBandwidth uint64
   var maxBandwidth uint64
   var minWidth uint16
@@ -172,88 +1444,459 @@ func createVideoAdaptationSet(tracks []mp4.TrackEntry, videoId string, sDuration
       maxHeight = t.Config.Video.Height
     }
   }
-  if minBandwidth == 0 {
-    err = errors.New("cannot found valid video tracks")
+  if minBandwidth == 0 {
+    err = errors.New("cannot found valid video tracks")
+    return
+  }
+  s = `    <AdaptationSet` + "\n"
+  s += fmt.Sprintf(`      group="%d"`, 2) + "\n"
+  s += `      contentType="video"` + "\n"
+  s += `      lang="en"` + "\n"
+  s += fmt.Sprintf(`      minBandwidth="%d"`, minBandwidth) + "\n"
+  s += fmt.Sprintf(`      maxBandwidth="%d"`, maxBandwidth) + "\n"
+  s += fmt.Sprintf(`      minWidth="%d"`, minWidth) + "\n"
+  s += fmt.Sprintf(`      maxWidth="%d"`, maxWidth) + "\n"
+  s += fmt.Sprintf(`      minHeight="%d"`, minHeight) + "\n"
+  s += fmt.Sprintf(`      maxHeight="%d"`, maxHeight) + "\n"
+  s += `      segmentAlignment="true"` + "\n"
+  s += `      mimeType="video/mp4"` + "\n"
+  s += `      startWithSAP="1">` + "\n"
+  s += createContentProtection(tracks[0], clearKeyUrl)
+  var sharedTemplate string
+  var templates []string
+  if onDemand {
+    templates, err = createSegmentBases(tracks, videoId, dir, sDuration)
+  } else {
+    sharedTemplate, templates, err = createSegmentTemplates(tracks, videoId, dir, sDuration)
+  }
+  if err != nil {
+    return
+  }
+  s += sharedTemplate
+
+  for i, t := range tracks {
+    s += `      <Representation` + "\n"
+    s += fmt.Sprintf(`        id="%s=%d"`, t.Name, t.Bandwidth) + "\n"
+    s += fmt.Sprintf(`        bandwidth="%d"`, t.Bandwidth) + "\n"
+    s += fmt.Sprintf(`        width="%d"`, t.Config.Video.Width) + "\n"
+    s += fmt.Sprintf(`        height="%d"`, t.Config.Video.Height) + "\n"
+    s += fmt.Sprintf(`        codecs="%s"`, trackCodecs(t)) + "\n"
+    s += `        scanType="progressive">` + "\n"
+    if templates != nil {
+      s += templates[i]
+    }
+    s += `      </Representation>` + "\n"
+  }
+  s += `    </AdaptationSet>` + "\n"
+
+  return
+}
+
+// The MPD is only returned when all its adaptation sets could be created (eg: their sample tables could be read)
+func createDashManifest(jConf mp4.JsonConfig, videoId string, dir string, onDemand bool, clearKeyUrl string) (dashManifest string, err error) {
+  dashManifest = ""
+  dashManifest += `<?xml version="1.0" encoding="utf-8"?>` + "\n"
+  dashManifest += `<!-- Created with Afrostream Media Server -->` + "\n"
+  dashManifest += `<MPD` + "\n"
+  dashManifest += `xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"` + "\n"
+  dashManifest += `xmlns="urn:mpeg:dash:schema:mpd:2011"` + "\n"
+  if isProtected(jConf) {
+    dashManifest += `xmlns:cenc="urn:mpeg:cenc:2013"` + "\n"
+    dashManifest += `xmlns:dashif="https://dashif.org/CPS"` + "\n"
+    dashManifest += `xmlns:mspr="urn:microsoft:playready"` + "\n"
+    dashManifest += `xmlns:clearkey="http://dashif.org/guidelines/clearKey"` + "\n"
+  }
+  dashManifest += `xsi:schemaLocation="urn:mpeg:dash:schema:mpd:2011 http://standards.iso.org/ittf/PubliclyAvailableStandards/MPEG-DASH_schema_files/DASH-MPD.xsd"` + "\n"
+  dashManifest += `type="static"` + "\n"
+  var duration uint64
+  if jConf.Tracks["video"] != nil {
+    duration = uint64(jConf.Tracks["video"][0].Config.Duration) / uint64(jConf.Tracks["video"][0].Config.Timescale)
+  } else {
+    duration = uint64(jConf.Tracks["audio"][0].Config.Duration) / uint64(jConf.Tracks["audio"][0].Config.Timescale)
+  }
+  dashManifest += fmt.Sprintf(`mediaPresentationDuration="PT%dH%dM%d.%dS"`, duration / 3600, (duration / 60) % 60, duration % 60, (duration * 1000) % 1000) + "\n"
+  dashManifest += fmt.Sprintf(`maxSegmentDuration="PT%dS"`, jConf.SegmentDuration) + "\n"
+  dashManifest += fmt.Sprintf(`minBufferTime="PT%dS"`, jConf.SegmentDuration + 1) + "\n"
+  if onDemand {
+    dashManifest += `profiles="urn:mpeg:dash:profile:isoff-on-demand:2011">` + "\n"
+  } else {
+    dashManifest += `profiles="urn:mpeg:dash:profile:isoff-live:2011">` + "\n"
+  }
+  dashManifest += `  <Period>` + "\n"
+  dashManifest += `    <BaseURL>dash/</BaseURL>` + "\n"
+
+  // One adaptation set per audio language and role
+  for _, group := range audioGroups(jConf.Tracks["audio"]) {
+    a, e := createAudioAdaptationSet(group, videoId, dir, jConf.SegmentDuration, onDemand, clearKeyUrl)
+    if e != nil {
+      return "", e
+    }
+    dashManifest += a
+  }
+  // AVC and HEVC video tracks are in separate adaptation sets
+  for _, group := range videoGroups(jConf.Tracks["video"]) {
+    a, e := createVideoAdaptationSet(group, videoId, dir, jConf.SegmentDuration, onDemand, clearKeyUrl)
+    if e != nil {
+      return "", e
+    }
+    dashManifest += a
+  }
+  a, err := createExternalSubtitlesAdaptationSet(jConf.Tracks["subtitle"])
+  if err != nil {
+    return "", err
+  }
+  dashManifest += a
+
+  dashManifest += `  </Period>` + "\n"
+  dashManifest += `</MPD>` + "\n"
+
+  return
+}
+
+// Keys of the Clear Key license server, loaded before the chroot
+var clearKeys []contentKey
+
+func findClearKey(keyId [16]byte) (key *contentKey) {
+  for i := range clearKeys {
+    if clearKeys[i].KeyId == keyId {
+      key = &clearKeys[i]
+      return
+    }
+  }
+
+  return
+}
+
+type clearKeyRequest struct {
+  Kids []string `json:"kids"`
+  Type string   `json:"type"`
+}
+
+type clearKeyJwk struct {
+  Kty string `json:"kty"`
+  Kid string `json:"kid"`
+  K   string `json:"k"`
+}
+
+type clearKeyResponse struct {
+  Keys []clearKeyJwk `json:"keys"`
+  Type string        `json:"type"`
+}
+
+// W3C Clear Key license server: key ids and keys are base64url encoded without padding
+// It answers to anyone, so it is only registered in development mode (-dev)
+func httpClearKeyServer(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Access-Control-Allow-Origin", "*")
+  w.Header().Set("Access-Control-Allow-Methods", "POST,OPTIONS")
+  w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+  log.Printf("[ LICENSE ] %+v", r.URL)
+  if r.Method == "OPTIONS" {
+    return
+  }
+  if r.Method != "POST" {
+    http.Error(w, `{ "status": "ERROR", "reason": "method not allowed" }`, http.StatusMethodNotAllowed)
+    return
+  }
+  var request clearKeyRequest
+  err := json.NewDecoder(io.LimitReader(r.Body, 65536)).Decode(&request)
+  if err != nil {
+    http.Error(w, `{ "status": "ERROR", "reason": "invalid license request" }`, http.StatusBadRequest)
+    return
+  }
+  var response clearKeyResponse
+  response.Keys = []clearKeyJwk{}
+  response.Type = request.Type
+  if response.Type == "" {
+    response.Type = "temporary"
+  }
+  for _, kid := range request.Kids {
+    b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(kid, "="))
+    if err != nil || len(b) != 16 {
+      http.Error(w, `{ "status": "ERROR", "reason": "invalid key id" }`, http.StatusBadRequest)
+      return
+    }
+    var keyId [16]byte
+    copy(keyId[:], b)
+    k := findClearKey(keyId)
+    if k != nil {
+      response.Keys = append(response.Keys, clearKeyJwk{ Kty: "oct", Kid: base64.RawURLEncoding.EncodeToString(k.KeyId[:]), K: base64.RawURLEncoding.EncodeToString(k.Key[:]) })
+    }
+  }
+  if len(response.Keys) == 0 {
+    http.Error(w, `{ "status": "ERROR", "reason": "key not found" }`, http.StatusNotFound)
+    return
+  }
+  data, err := json.Marshal(response)
+  if err != nil {
+    http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  w.Write(data)
+}
+
+// Secret of the HLS AES-128 key server, given by clients in the Authorization header (Bearer), a cookie or the token
+// query parameter (signed URL tokens of the asset are accepted too)
+var keyServerToken string
+
+// HLS AES-128 key server: /keys/<asset>.json/<key id> returns the 16 bytes key of the asset descriptor <asset>.json
+func httpKeyServer(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Access-Control-Allow-Origin", "*")
+  w.Header().Set("Access-Control-Allow-Credentials", "true")
+  w.Header().Set("Access-Control-Allow-Methods", "GET,OPTIONS")
+  w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+  w.Header().Set("Cache-Control", "no-store")
+  log.Printf("[ KEY ] %+v", r.URL)
+  if r.Method == "OPTIONS" {
+    return
+  }
+  token := r.URL.Query().Get("token")
+  if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+    token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+  } else if c, err := r.Cookie("ams_key_token"); err == nil {
+    token = c.Value
+  }
+  assetPath := strings.TrimSuffix(path.Dir(path.Clean(strings.TrimPrefix(r.URL.Path, "/keys"))), ".json")
+  // The signed URL token of the asset is accepted too
+  authorized := keyServerToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(keyServerToken)) == 1
+  if !authorized && urlSigningSecret != nil {
+    urlToken := requestUrlToken(r)
+    claims, err := checkUrlToken(urlToken, strings.TrimPrefix(r.URL.Path, "/keys"), r)
+    if err == nil {
+      err = trackStreamSession(claims, r)
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusTooManyRequests)
+        return
+      }
+      authorized = true
+    }
+  }
+  if !authorized {
+    http.Error(w, `{ "status": "ERROR", "reason": "unauthorized" }`, http.StatusUnauthorized)
+    return
+  }
+  jConfig, err := readJsonConfig(assetPath + ".json")
+  if err != nil || !isTsProtected(jConfig) {
+    http.Error(w, `{ "status": "ERROR", "reason": "key not found" }`, http.StatusNotFound)
+    return
+  }
+  keyId, key, err := readHlsKey(jConfig, path.Dir(assetPath))
+  if err != nil || hex.EncodeToString(keyId[:]) != strings.ToLower(path.Base(r.URL.Path)) {
+    http.Error(w, `{ "status": "ERROR", "reason": "key not found" }`, http.StatusNotFound)
+    return
+  }
+  w.Header().Set("Content-Type", "application/octet-stream")
+  w.Header().Set("Content-Length", strconv.Itoa(len(key)))
+  w.Write(key[:])
+}
+
+// Secret of the signed URLs, all the requests of httpRootServer need a valid token when it is set
+var urlSigningSecret []byte
+
+// Name of the query parameter and of the cookie of signed URL tokens
+const urlTokenName = "ams_token"
+
+// Signed URL token: "exp=<expiration unix time>~path=<path prefix>[~ip=<client ip>][~user=<user id>[~streams=<limit>]
+// [~sid=<session id>]]~hmac=<hexadecimal HMAC-SHA256>", the HMAC is computed with the secret on the fields before "~hmac="
+func signUrlToken(expires int64, pathPrefix string, ip string, user string, streams int, session string) string {
+  fields := "exp=" + strconv.FormatInt(expires, 10) + "~path=" + pathPrefix
+  if ip != "" {
+    fields += "~ip=" + ip
+  }
+  if user != "" {
+    fields += "~user=" + user
+    if streams > 0 {
+      fields += "~streams=" + strconv.Itoa(streams)
+    }
+    if session != "" {
+      fields += "~sid=" + session
+    }
+  }
+  mac := hmac.New(sha256.New, urlSigningSecret)
+  mac.Write([]byte(fields))
+
+  return fields + "~hmac=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Random playback session id of the sid claim of signed URL tokens
+func newSessionId() string {
+  b := make([]byte, 8)
+  _, err := rand.Read(b)
+  if err != nil {
+    panic(err)
+  }
+
+  return hex.EncodeToString(b)
+}
+
+// Signed URL token of a request, in the query or in a cookie
+func requestUrlToken(r *http.Request) string {
+  token := r.URL.Query().Get(urlTokenName)
+  if token == "" {
+    if c, err := r.Cookie(urlTokenName); err == nil {
+      token = c.Value
+    }
+  }
+
+  return token
+}
+
+// A token path prefix is valid for itself and for the paths under it (a directory or an asset .json / .ism followed
+// by its formats), not for the other paths starting with the same characters (eg: /vod/a for /vod/abc.json)
+func isTokenPath(urlPath string, prefix string) bool {
+  if prefix == "" || !strings.HasPrefix(urlPath, prefix) {
+    return false
+  }
+  if len(urlPath) == len(prefix) || strings.HasSuffix(prefix, "/") {
+    return true
+  }
+
+  return urlPath[len(prefix)] == '/' || urlPath[len(prefix)] == '.'
+}
+
+// Check that a signed URL token is valid for a path and the client of a request, its fields are returned
+func checkUrlToken(token string, urlPath string, r *http.Request) (claims map[string]string, err error) {
+  i := strings.LastIndex(token, "~hmac=")
+  if token == "" || i < 0 {
+    err = errors.New("missing or invalid token")
+    return
+  }
+  fields := token[:i]
+  mac := hmac.New(sha256.New, urlSigningSecret)
+  mac.Write([]byte(fields))
+  if !hmac.Equal([]byte(token[i + len("~hmac="):]), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+    err = errors.New("invalid token signature")
+    return
+  }
+  claims = make(map[string]string)
+  for _, field := range strings.Split(fields, "~") {
+    split1 := strings.SplitN(field, "=", 2)
+    if len(split1) != 2 {
+      err = errors.New("missing or invalid token")
+      return
+    }
+    claims[split1[0]] = split1[1]
+  }
+  expires, _ := strconv.ParseInt(claims["exp"], 10, 64)
+  if time.Now().Unix() > expires {
+    err = errors.New("token expired")
     return
   }
-  s = `    <AdaptationSet` + "\n"
-  s += fmt.Sprintf(`      group="%d"`, 2) + "\n"
-  s += `      contentType="video"` + "\n"
-  s += `      lang="en"` + "\n"
-  s += fmt.Sprintf(`      minBandwidth="%d"`, minBandwidth) + "\n"
-  s += fmt.Sprintf(`      maxBandwidth="%d"`, maxBandwidth) + "\n"
-  s += fmt.Sprintf(`      minWidth="%d"`, minWidth) + "\n"
-  s += fmt.Sprintf(`      maxWidth="%d"`, maxWidth) + "\n"
-  s += fmt.Sprintf(`      minHeight="%d"`, minHeight) + "\n"
-  s += fmt.Sprintf(`      maxHeight="%d"`, maxHeight) + "\n"
-  s += `      segmentAlignment="true"` + "\n"
-  s += `      mimeType="video/mp4"` + "\n"
-  s += `      startWithSAP="1">` + "\n"
-  s += `      <SegmentTemplate` + "\n"
-  s += fmt.Sprintf(`        timescale="%d"`, tracks[0].Config.Timescale) + "\n"
-  s += fmt.Sprintf(`        initialization="%s-$RepresentationID$.dash"`, videoId) + "\n"
-  s += fmt.Sprintf(`        media="%s-$RepresentationID$-$Number$.m4s"`, videoId) + "\n"
-  s += `        startNumber="1"` + "\n"
-  s += fmt.Sprintf(`        duration="%d">`, sDuration * tracks[0].Config.Timescale) + "\n"
-  s += `      </SegmentTemplate>` + "\n"
-
-  for _, t := range tracks {
-    s += `      <Representation` + "\n"
-    s += fmt.Sprintf(`        id="%s=%d"`, t.Name, t.Bandwidth) + "\n"
-    s += fmt.Sprintf(`        bandwidth="%d"`, t.Bandwidth) + "\n"
-    s += fmt.Sprintf(`        width="%d"`, t.Config.Video.Width) + "\n"
-    s += fmt.Sprintf(`        height="%d"`, t.Config.Video.Height) + "\n"
-    s += fmt.Sprintf(`        codecs="avc1.%.2X%.2X%.2X"`, t.Config.Video.CodecInfo[0], t.Config.Video.CodecInfo[1], t.Config.Video.CodecInfo[2]) + "\n"
-    s += `        scanType="progressive">` + "\n"
-    s += `      </Representation>` + "\n"
+  if !isTokenPath(path.Clean(urlPath), claims["path"]) {
+    err = errors.New("token is not valid for this path")
+    return
+  }
+  if claims["ip"] != "" && clientIp(r) != claims["ip"] {
+    err = errors.New("token is not valid for this client")
+    return
   }
-  s += `    </AdaptationSet>` + "\n"
 
   return
 }
 
-func createDashManifest(jConf mp4.JsonConfig, videoId string) (dashManifest string) {
-  dashManifest = ""
-  dashManifest += `<?xml version="1.0" encoding="utf-8"?>` + "\n"
-  dashManifest += `<!-- Created with Afrostream Media Server -->` + "\n"
-  dashManifest += `<MPD` + "\n"
-  dashManifest += `xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"` + "\n"
-  dashManifest += `xmlns="urn:mpeg:dash:schema:mpd:2011"` + "\n"
-  dashManifest += `xsi:schemaLocation="urn:mpeg:dash:schema:mpd:2011 http://standards.iso.org/ittf/PubliclyAvailableStandards/MPEG-DASH_schema_files/DASH-MPD.xsd"` + "\n"
-  dashManifest += `type="static"` + "\n"
-  var duration uint64
-  if jConf.Tracks["video"] != nil {
-    duration = uint64(jConf.Tracks["video"][0].Config.Duration) / uint64(jConf.Tracks["video"][0].Config.Timescale)
-  } else {
-    duration = uint64(jConf.Tracks["audio"][0].Config.Duration) / uint64(jConf.Tracks["audio"][0].Config.Timescale)
+func clientIp(r *http.Request) string {
+  host, _, err := net.SplitHostPort(r.RemoteAddr)
+  if err != nil {
+    return r.RemoteAddr
   }
-  dashManifest += fmt.Sprintf(`mediaPresentationDuration="PT%dH%dM%d.%dS"`, duration / 3600, (duration / 60) % 60, duration % 60, (duration * 1000) % 1000) + "\n"
-  dashManifest += fmt.Sprintf(`maxSegmentDuration="PT%dS"`, jConf.SegmentDuration) + "\n"
-  dashManifest += fmt.Sprintf(`minBufferTime="PT%dS"`, jConf.SegmentDuration + 1) + "\n"
-  dashManifest += `profiles="urn:mpeg:dash:profile:isoff-live:2011">` + "\n"
-  dashManifest += `  <Period>` + "\n"
-  dashManifest += `    <BaseURL>dash/</BaseURL>` + "\n"
 
-  a, err := createAudioAdaptationSet(jConf.Tracks["audio"], videoId, jConf.SegmentDuration)
-  if err != nil {
-    return
+  return host
+}
+
+// Playback sessions of the users of signed URL tokens (user claim), a session of a user is identified by the sid
+// claim of the token (the client address and user agent without sid claim) and is active while its segments are fetched
+var streamSessions = make(map[string]map[string]time.Time)
+var streamSessionsMutex sync.Mutex
+
+// Duration after which a session without requests is not counted anymore
+const streamSessionTimeout = 60 * time.Second
+
+// Default limit of simultaneous streams of the tokens without streams claim (0: no limit)
+var defaultStreamLimit int
+
+// Count the playback session of a request, a new session is refused when the user already has as many active
+// sessions as allowed by the streams claim of the token: its manifests, init and media segments and keys too
+func trackStreamSession(claims map[string]string, r *http.Request) error {
+  user := claims["user"]
+  if user == "" {
+    return nil
   }
-  dashManifest += a
-  a, err = createVideoAdaptationSet(jConf.Tracks["video"], videoId, jConf.SegmentDuration)
-  if err != nil {
-    return
+  limit := defaultStreamLimit
+  if claims["streams"] != "" {
+    limit, _ = strconv.Atoi(claims["streams"])
   }
-  dashManifest += a
-  a, err = createExternalSubtitlesAdaptationSet(jConf.Tracks["subtitle"])
-  if err != nil {
-    return
+  session := claims["sid"]
+  if session == "" {
+    // Tokens shared by several players of a user are told apart by their client
+    session = clientIp(r) + " " + r.UserAgent()
   }
-  dashManifest += a
+  now := time.Now()
 
-  dashManifest += `  </Period>` + "\n"
-  dashManifest += `</MPD>` + "\n"
+  streamSessionsMutex.Lock()
+  defer streamSessionsMutex.Unlock()
+  // Forget the inactive sessions of all the users
+  for u, sessions := range streamSessions {
+    for s, lastSeen := range sessions {
+      if now.Sub(lastSeen) > streamSessionTimeout {
+        delete(sessions, s)
+      }
+    }
+    if len(sessions) == 0 {
+      delete(streamSessions, u)
+    }
+  }
+  if _, active := streamSessions[user][session]; !active && limit > 0 && len(streamSessions[user]) >= limit {
+    return fmt.Errorf("too many simultaneous streams (limit: %d)", limit)
+  }
+  if streamSessions[user] == nil {
+    streamSessions[user] = make(map[string]time.Time)
+  }
+  streamSessions[user][session] = now
 
-  return
+  return nil
+}
+
+var manifestUrlRegexp = regexp.MustCompile(`(media|initialization|Url|URI)="([^"]*)"|<BaseURL>([^<]*)</BaseURL>`)
+
+// Add a signed URL token to the relative URLs of a MPD, a Smooth Streaming manifest or a HLS playlist, so that it is
+// carried over to the init, media and playlist requests (base URLs of directories are left as they are)
+func addUrlToken(manifest string, token string) string {
+  if token == "" {
+    return manifest
+  }
+  addToken := func(u string) string {
+    if u == "" || strings.Contains(u, "://") || strings.HasSuffix(u, "/") {
+      return u
+    }
+    separator := "?"
+    if strings.Contains(u, "?") {
+      separator = "&amp;"
+      if strings.HasPrefix(manifest, "#EXTM3U") {
+        separator = "&"
+      }
+    }
+
+    return u + separator + urlTokenName + "=" + url.QueryEscape(token)
+  }
+  manifest = manifestUrlRegexp.ReplaceAllStringFunc(manifest, func(m string) string {
+    sm := manifestUrlRegexp.FindStringSubmatch(m)
+    if sm[1] != "" {
+      return sm[1] + `="` + addToken(sm[2]) + `"`
+    }
+
+    return "<BaseURL>" + addToken(sm[3]) + "</BaseURL>"
+  })
+  if strings.HasPrefix(manifest, "#EXTM3U") {
+    lines := strings.Split(manifest, "\n")
+    for i, line := range lines {
+      if line != "" && !strings.HasPrefix(line, "#") {
+        lines[i] = addToken(line)
+      }
+    }
+    manifest = strings.Join(lines, "\n")
+  }
+
+  return manifest
 }
 
 func httpServerLoadPage(path string) (content []byte, err error) {
@@ -269,6 +1912,24 @@ func httpRootServer(w http.ResponseWriter, r *http.Request) {
   w.Header().Set("Access-Control-Allow-Headers", "DNT,X-CustomHeader,Keep-Alive,Range,User-Agent,X-Requested-With,If-Modified-Since,Cache-Control,Content-Type")
   w.Header().Set("Connection", "close")
   log.Printf("[ REQUEST ] %+v", r.URL)
+  var token string
+  if urlSigningSecret != nil {
+    // Preflight requests have no cookie and get no content
+    if r.Method == "OPTIONS" {
+      return
+    }
+    token = requestUrlToken(r)
+    claims, err := checkUrlToken(token, r.URL.Path, r)
+    if err != nil {
+      http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusForbidden)
+      return
+    }
+    err = trackStreamSession(claims, r)
+    if err != nil {
+      http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusTooManyRequests)
+      return
+    }
+  }
   pathStr := r.URL.Path[:]
   //splitDirs := strings.Split(pathStr, "/")
   var s []string
@@ -284,6 +1945,35 @@ func httpRootServer(w http.ResponseWriter, r *http.Request) {
     if len(splitDirs) > 2 && splitDirs[1] == "dash" {
       w.Header().Set("Content-Type", "video/mp4")
       switch path.Ext(pathStr) {
+        case ".mp4":
+          // Single file of the on-demand profile: <videoId>-<trackName>=<bandwidth>.mp4
+          split1 := strings.SplitN(strings.TrimSuffix(path.Base(s[1]), ".mp4"), "-", 2)
+          if len(split1) != 2 {
+            http.Error(w, `{ "status": "ERROR", "reason": "invalid file name" }`, http.StatusNotFound)
+            return
+          }
+          trackType, trackName, trackBandwidth, err := parseTrackId(split1[1])
+          if err != nil {
+            http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+            return
+          }
+          jConfig, err := readJsonConfig(videoIdPath + ".json")
+          if err != nil {
+            http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+            return
+          }
+          t := findTrack(jConfig, trackType, trackName, trackBandwidth)
+          if t == nil || t.Config == nil {
+            http.Error(w, `{ "status": "ERROR", "reason": "track not found" }`, http.StatusNotFound)
+            return
+          }
+          file, err := getDashOnDemandFile(*t, path.Dir(videoIdPath), jConfig.SegmentDuration)
+          if err != nil {
+            http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+            return
+          }
+          // http.ServeContent answers Range requests
+          http.ServeContent(w, r, path.Base(s[1]), time.Time{}, io.NewSectionReader(file, 0, file.Size))
         case ".dash":
           split1 := strings.Split(s[1], "-")
           split2 := strings.Split(split1[1], "=")
@@ -299,14 +1989,7 @@ func httpRootServer(w http.ResponseWriter, r *http.Request) {
             return
           }
           trackBandwidth = num
-          data, err := readFile(videoIdPath + ".json")
-          if err != nil {
-            http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
-            return
-          }
-
-          var jConfig mp4.JsonConfig
-          err = json.Unmarshal(data, &jConfig)
+          jConfig, err := readJsonConfig(videoIdPath + ".json")
           if err != nil {
             http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
             return
@@ -339,7 +2022,9 @@ func httpRootServer(w http.ResponseWriter, r *http.Request) {
             return
           }
           trackBandwidth = num
-          num, err = strconv.ParseUint(split3[0], 10, 32)
+          // Segments are addressed by $Number$ (eg: 3) or by $Time$ (eg: t102400)
+          timeAddressing := strings.HasPrefix(split3[0], "t")
+          num, err = strconv.ParseUint(strings.TrimPrefix(split3[0], "t"), 10, 64)
           if err != nil {
             http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
             return
@@ -347,13 +2032,7 @@ func httpRootServer(w http.ResponseWriter, r *http.Request) {
           var segmentNumber uint32
           segmentNumber = uint32(num)
 
-          data, err := readFile(videoIdPath + ".json")
-          if err != nil {
-            http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
-            return
-          }
-          var jConfig mp4.JsonConfig
-          err = json.Unmarshal(data, &jConfig)
+          jConfig, err := readJsonConfig(videoIdPath + ".json")
           if err != nil {
             http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
             return
@@ -362,7 +2041,24 @@ func httpRootServer(w http.ResponseWriter, r *http.Request) {
           for _, t := range jConfig.Tracks[trackType] {
             if t.Name == trackName && t.Bandwidth == trackBandwidth {
               t.File = path.Dir(videoIdPath) + "/" + t.File
+              if timeAddressing {
+                segmentNumber = 0
+                for _, segment := range mp4.GetDashSegmentsWithConf(*t.Config, t.File, jConfig.SegmentDuration) {
+                  if segment.Time == num {
+                    segmentNumber = segment.Number
+                    break
+                  }
+                }
+              }
+              if segmentNumber == 0 {
+                http.Error(w, `{ "status": "ERROR", "reason": "segment not found" }`, http.StatusNotFound)
+                return
+              }
               fragment := mp4.CreateDashFragmentWithConf(*t.Config, t.File, segmentNumber, jConfig.SegmentDuration)
+              if fragment == nil {
+                http.Error(w, `{ "status": "ERROR", "reason": "segment not found" }`, http.StatusNotFound)
+                return
+              }
               fb := mp4.MapToBytes(fragment)
               sizeToWrite := len(fb)
               w.Header().Set("Content-Length", strconv.Itoa(sizeToWrite))
@@ -378,22 +2074,268 @@ func httpRootServer(w http.ResponseWriter, r *http.Request) {
             }
           }
       }
+    } else if len(splitDirs) > 2 && splitDirs[1] == "hls" {
+      if path.Ext(pathStr) != ".m3u8" {
+        http.Error(w, `{ "status": "ERROR", "reason": "format is not supported" }`, http.StatusInternalServerError)
+        return
+      }
+      split1 := strings.SplitN(path.Base(s[1]), "-", 2)
+      if len(split1) != 2 {
+        http.Error(w, `{ "status": "ERROR", "reason": "invalid playlist name" }`, http.StatusNotFound)
+        return
+      }
+      trackType, trackName, trackBandwidth, err := parseTrackId(strings.TrimSuffix(split1[1], ".m3u8"))
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+        return
+      }
+      jConfig, err := readJsonConfig(videoIdPath + ".json")
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+        return
+      }
+      if isProtected(jConfig) && !isHlsProtected(jConfig) {
+        http.Error(w, `{ "status": "ERROR", "reason": "protected content is not available in this format" }`, http.StatusForbidden)
+        return
+      }
+      t := findTrack(jConfig, trackType, trackName, trackBandwidth)
+      if t == nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "track not found" }`, http.StatusNotFound)
+        return
+      }
+      playlist, err := createHlsMediaPlaylist(jConfig, *t, videoId, path.Dir(videoIdPath))
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+        return
+      }
+      w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+      w.Write([]byte(addUrlToken(playlist, token)))
+    } else if len(splitDirs) > 2 && splitDirs[1] == "subtitles" {
+      // External subtitles files are served under the asset path (and its signed URL tokens), only the ones of its
+      // subtitle tracks: <videoId>.json/subtitles/<file>
+      jConfig, err := readJsonConfig(videoIdPath + ".json")
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+        return
+      }
+      filename := strings.Join(splitDirs[2:], "/")
+      var t *mp4.TrackEntry
+      for i := range jConfig.Tracks["subtitle"] {
+        if jConfig.Tracks["subtitle"][i].File == filename {
+          t = &jConfig.Tracks["subtitle"][i]
+          break
+        }
+      }
+      if t == nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "subtitles not found" }`, http.StatusNotFound)
+        return
+      }
+      f, err := os.Open(path.Dir(videoIdPath) + "/" + t.File)
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "subtitles not found" }`, http.StatusNotFound)
+        return
+      }
+      defer f.Close()
+      w.Header().Set("Content-Type", "text/vtt")
+      http.ServeContent(w, r, path.Base(t.File), time.Time{}, f)
+    } else if len(splitDirs) > 2 && strings.HasPrefix(splitDirs[1], "QualityLevels(") && strings.HasPrefix(splitDirs[2], "Fragments(") {
+      // QualityLevels(<bitrate>)/Fragments(<stream name>=<start time>)
+      trackBandwidth, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(splitDirs[1], "QualityLevels("), ")"), 10, 64)
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+        return
+      }
+      split1 := strings.Split(strings.TrimSuffix(strings.TrimPrefix(splitDirs[2], "Fragments("), ")"), "=")
+      if len(split1) != 2 {
+        http.Error(w, `{ "status": "ERROR", "reason": "invalid fragment name" }`, http.StatusNotFound)
+        return
+      }
+      fragmentTime, err := strconv.ParseUint(split1[1], 10, 64)
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+        return
+      }
+      jConfig, err := readJsonConfig(videoIdPath + ".json")
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+        return
+      }
+      if isProtected(jConfig) {
+        http.Error(w, `{ "status": "ERROR", "reason": "protected content is not available in this format" }`, http.StatusForbidden)
+        return
+      }
+      var t *mp4.TrackEntry
+      if split1[0] == "video" {
+        for i := range jConfig.Tracks["video"] {
+          if jConfig.Tracks["video"][i].Bandwidth == trackBandwidth {
+            t = &jConfig.Tracks["video"][i]
+            break
+          }
+        }
+      } else {
+        t = findTrack(jConfig, "audio", split1[0], trackBandwidth)
+      }
+      if t == nil || t.Config == nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "track not found" }`, http.StatusNotFound)
+        return
+      }
+      fragment := mp4.CreateSmoothFragmentWithConf(*t.Config, path.Dir(videoIdPath) + "/" + t.File, fragmentTime, jConfig.SegmentDuration)
+      if fragment == nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "fragment not found" }`, http.StatusNotFound)
+        return
+      }
+      fb := mp4.MapToBytes(fragment)
+      w.Header().Set("Content-Type", "video/mp4")
+      w.Header().Set("Content-Length", strconv.Itoa(len(fb)))
+      w.Write(fb)
+    } else if len(splitDirs) > 2 && splitDirs[1] == "ts" {
+      ext := path.Ext(pathStr)
+      if ext != ".m3u8" && ext != ".ts" {
+        http.Error(w, `{ "status": "ERROR", "reason": "format is not supported" }`, http.StatusInternalServerError)
+        return
+      }
+      // <videoId>-<trackName>=<bandwidth>.m3u8 or <videoId>-<trackName>=<bandwidth>-<segmentNumber>.ts
+      split1 := strings.Split(strings.TrimSuffix(path.Base(s[1]), ext), "-")
+      if (ext == ".m3u8" && len(split1) < 2) || (ext == ".ts" && len(split1) < 3) {
+        http.Error(w, `{ "status": "ERROR", "reason": "invalid segment name" }`, http.StatusNotFound)
+        return
+      }
+      trackId := split1[len(split1) - 1]
+      if ext == ".ts" {
+        trackId = split1[len(split1) - 2]
+      }
+      trackType, trackName, trackBandwidth, err := parseTrackId(trackId)
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+        return
+      }
+      jConfig, err := readJsonConfig(videoIdPath + ".json")
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+        return
+      }
+      if isProtected(jConfig) && !isTsProtected(jConfig) {
+        http.Error(w, `{ "status": "ERROR", "reason": "protected content is not available in this format" }`, http.StatusForbidden)
+        return
+      }
+      t := findTrack(jConfig, trackType, trackName, trackBandwidth)
+      if t == nil || t.Config == nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "track not found" }`, http.StatusNotFound)
+        return
+      }
+      if ext == ".m3u8" {
+        playlist, err := createHlsTsMediaPlaylist(jConfig, *t, videoId, path.Dir(videoIdPath))
+        if err != nil {
+          http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+          return
+        }
+        w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+        w.Write([]byte(addUrlToken(playlist, token)))
+        return
+      }
+
+      num, err := strconv.ParseUint(split1[len(split1) - 1], 10, 32)
+      if err != nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+        return
+      }
+      var segment []byte
+      if t.Config.Type == "video" {
+        a := tsAudioTrack(jConfig)
+        if a != nil {
+          segment = mp4.CreateTsSegmentWithConf(clearConfig(t.Config), path.Dir(videoIdPath) + "/" + t.File, clearConfig(a.Config), path.Dir(videoIdPath) + "/" + a.File, uint32(num), jConfig.SegmentDuration)
+        } else {
+          segment = mp4.CreateTsSegmentWithConf(clearConfig(t.Config), path.Dir(videoIdPath) + "/" + t.File, nil, "", uint32(num), jConfig.SegmentDuration)
+        }
+      } else {
+        segment = mp4.CreateTsSegmentWithConf(nil, "", clearConfig(t.Config), path.Dir(videoIdPath) + "/" + t.File, uint32(num), jConfig.SegmentDuration)
+      }
+      if segment == nil {
+        http.Error(w, `{ "status": "ERROR", "reason": "segment not found" }`, http.StatusNotFound)
+        return
+      }
+      if isTsProtected(jConfig) {
+        keyId, key, err := readHlsKey(jConfig, path.Dir(videoIdPath))
+        if err != nil {
+          http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+          return
+        }
+        mediaSequence, ok := hlsMediaSequence(mp4.GetDashSegmentsWithConf(*t.Config, path.Dir(videoIdPath) + "/" + t.File, jConfig.SegmentDuration), uint32(num))
+        if !ok {
+          http.Error(w, `{ "status": "ERROR", "reason": "segment not found" }`, http.StatusNotFound)
+          return
+        }
+        iv, _, err := hlsSegmentIv(jConfig, keyId, mediaSequence)
+        if err != nil {
+          http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+          return
+        }
+        segment = mp4.EncryptTsSegmentWithAes128(segment, key, iv)
+      }
+      w.Header().Set("Content-Type", "video/mp2t")
+      w.Header().Set("Content-Length", strconv.Itoa(len(segment)))
+      w.Write(segment)
     } else {
-      if path.Ext(pathStr) == ".mpd" {
+      if path.Ext(pathStr) == ".m3u8" {
+        jConfig, err := readJsonConfig(videoIdPath + ".json")
+        if err != nil {
+          http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+          return
+        }
+        if isProtected(jConfig) && ((path.Base(pathStr) == "ts.m3u8" && !isTsProtected(jConfig)) || (path.Base(pathStr) != "ts.m3u8" && !isHlsProtected(jConfig))) {
+          http.Error(w, `{ "status": "ERROR", "reason": "protected content is not available in this format" }`, http.StatusForbidden)
+          return
+        }
+        var playlist string
+        if path.Base(pathStr) == "ts.m3u8" {
+          playlist, err = createHlsTsMasterPlaylist(jConfig, videoId)
+        } else {
+          playlist, err = createHlsMasterPlaylist(jConfig, videoId)
+        }
+        if err != nil {
+          http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+          return
+        }
+        w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+        w.Write([]byte(addUrlToken(playlist, token)))
+      } else if path.Base(pathStr) == "Manifest" {
+        jConfig, err := readJsonConfig(videoIdPath + ".json")
+        if err != nil {
+          http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+          return
+        }
+        if isProtected(jConfig) {
+          http.Error(w, `{ "status": "ERROR", "reason": "protected content is not available in this format" }`, http.StatusForbidden)
+          return
+        }
+        manifest, err := createSmoothManifest(jConfig, path.Dir(videoIdPath))
+        if err != nil {
+          http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
+          return
+        }
+        w.Header().Set("Content-Type", "text/xml")
+        w.Write([]byte(addUrlToken(manifest, token)))
+      } else if path.Ext(pathStr) == ".mpd" {
         w.Header().Set("Content-Type", "application/dash+xml")
-        data, err := readFile(videoIdPath + ".json")
+        jConfig, err := readJsonConfig(videoIdPath + ".json")
         if err != nil {
           http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
           return
         }
-        var jConfig mp4.JsonConfig
-        err = json.Unmarshal(data, &jConfig)
+        clearKeyUrl := ""
+        if clearKeys != nil {
+          scheme := "http"
+          if r.TLS != nil {
+            scheme = "https"
+          }
+          clearKeyUrl = scheme + "://" + r.Host + "/clearkey"
+        }
+        mpdContent, err := createDashManifest(jConfig, videoId, path.Dir(videoIdPath), path.Base(pathStr) == "ondemand.mpd", clearKeyUrl)
         if err != nil {
           http.Error(w, `{ "status": "ERROR", "reason": "` + err.Error() + `" }`, http.StatusInternalServerError)
           return
         }
-        mpdContent := createDashManifest(jConfig, videoId)
-        w.Write([]byte(mpdContent))
+        w.Write([]byte(addUrlToken(mpdContent, token)))
       } else {
         http.Error(w, `{ "status": "ERROR", "reason": "format is not supported" }`, http.StatusInternalServerError)
         return
@@ -417,8 +2359,35 @@ func httpRootServer(w http.ResponseWriter, r *http.Request) {
 func main() {
   documentRoot := flag.String("d", "", "Document Root (default: none)")
   portNumber := flag.String("p", "80", "Port number used by AMS for listening connections")
+  keyToken := flag.String("a", "", "Secret token of the HLS AES-128 key server (default: none, key server disabled)")
+  keyStore := flag.String("k", "", "Key file of the Clear Key license server with \"<key id>:<key>\" hexadecimal lines, needs -dev (default: none)")
+  devMode := flag.Bool("dev", false, "Development mode: enables the Clear Key license server, which gives the keys to anyone, never use it in production")
+  signingSecret := flag.String("s", "", "Secret of the signed URLs, required by all requests when set (default: none)")
+  signPath := flag.String("sign", "", "Print a signed URL token for this path prefix and exit (needs -s)")
+  signTtl := flag.Int64("ttl", 3600, "Validity in seconds of the token printed with -sign")
+  signIp := flag.String("ip", "", "Client IP address of the token printed with -sign (default: any)")
+  signUser := flag.String("user", "", "User id of the token printed with -sign, for the simultaneous streams limits (default: none)")
+  signStreams := flag.Int("streams", 0, "Simultaneous streams allowed to the user of the token printed with -sign (default: -maxstreams)")
+  onDemandCache := flag.Int("odcache", maxOnDemandFiles, "Number of on-demand profile files kept in memory")
+  maxStreams := flag.Int("maxstreams", 0, "Simultaneous streams allowed to the users of tokens without streams limit (default: 0, no limit)")
   flag.Parse()
 
+  if *signingSecret != "" {
+    urlSigningSecret = []byte(*signingSecret)
+  }
+  if *signPath != "" {
+    if urlSigningSecret == nil {
+      fmt.Printf("Please specify the secret of the signed URLs with -s <secret>")
+      return
+    }
+    fmt.Println(signUrlToken(time.Now().Unix() + *signTtl, *signPath, *signIp, *signUser, *signStreams, newSessionId()))
+    return
+  }
+  defaultStreamLimit = *maxStreams
+  if *onDemandCache > 0 {
+    maxOnDemandFiles = *onDemandCache
+  }
+
   if *documentRoot == "" {
     fmt.Printf("Please specify the document root for the web server with -d <document root>")
     return
@@ -426,7 +2395,21 @@ func main() {
 
   mp4.Debug(false)
 
-  err := syscall.Chroot(*documentRoot)
+  var err error
+  if *keyStore != "" {
+    if !*devMode {
+      fmt.Printf("The Clear Key license server gives the keys to anyone, it is only enabled in development mode with -dev")
+      return
+    }
+    clearKeys, err = readKeyFile(*keyStore)
+    if err != nil {
+      fmt.Printf("Cannot read the key file '%s': %v", *keyStore, err)
+      return
+    }
+    log.Printf(" [*] DEVELOPMENT MODE: unauthenticated Clear Key license server enabled with %d keys", len(clearKeys))
+  }
+
+  err = syscall.Chroot(*documentRoot)
   if err != nil {
     fmt.Printf("Please run Afrostream Media Server as root, cannot chroot the document root directory for security: %v", err)
     return
@@ -435,6 +2418,13 @@ func main() {
   listenPort := ":" + *portNumber
   log.Printf(" [*] Running Afrostream Media Server on %s, To exit press CTRL+C", listenPort)
   http.HandleFunc("/", httpRootServer)
+  if clearKeys != nil {
+    http.HandleFunc("/clearkey", httpClearKeyServer)
+  }
+  if *keyToken != "" || urlSigningSecret != nil {
+    keyServerToken = *keyToken
+    http.HandleFunc("/keys/", httpKeyServer)
+  }
   http.ListenAndServe(listenPort, nil)
 
   return
@@ -0,0 +1,429 @@
+// Tests of the AMS HTTP server, run with: go test ams.go ams_test.go
+package main
+
+import (
+  "bytes"
+  "encoding/binary"
+  "encoding/hex"
+  "io/ioutil"
+  "mp4"
+  "net/http/httptest"
+  "net/url"
+  "os"
+  "path"
+  "regexp"
+  "strconv"
+  "strings"
+  "testing"
+  "time"
+)
+
+// Key URI of the EXT-X-KEY tag of a signed TS playlist, with the token added by addUrlToken
+func signedKeyUri(t *testing.T, dir string, videoId string, keyId [16]byte, token string) string {
+  playlist := "#EXTM3U\n" + `#EXT-X-KEY:METHOD=AES-128,URI="` + hlsKeyUri(dir, videoId, keyId) + `"` + "\n"
+  playlist += "#EXTINF:4.000,\n" + videoId + "-video_eng=81709-1.ts\n"
+  m := regexp.MustCompile(`URI="([^"]*)"`).FindStringSubmatch(addUrlToken(playlist, token))
+  if m == nil {
+    t.Fatalf("no key URI in the playlist")
+  }
+
+  return m[1]
+}
+
+func TestSignedTsPlaylistKey(t *testing.T) {
+  dir, err := ioutil.TempDir("", "ams")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  config := `{ "SegmentDuration": 4, "HlsEncryption": { "KeyId": "20000000200020002000200000000002", "Key": "00112233445566778899aabbccddeeff" } }`
+  err = ioutil.WriteFile(path.Join(dir, "video.json"), []byte(config), 0644)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var keyId [16]byte
+  hex.Decode(keyId[:], []byte("20000000200020002000200000000002"))
+  key, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
+
+  urlSigningSecret = []byte("secret")
+  defer func() { urlSigningSecret = nil }()
+  expires := time.Now().Unix() + 60
+
+  tests := []struct {
+    name      string
+    tokenPath string
+    status    int
+  }{
+    { "token of the asset", path.Join(dir, "video.json"), 200 },
+    { "token of the directory", dir, 200 },
+    { "token of another asset", path.Join(dir, "video2.json"), 401 },
+    { "token of an asset with the same prefix", path.Join(dir, "vid"), 401 },
+  }
+  for _, test := range tests {
+    uri := signedKeyUri(t, dir, "video", keyId, signUrlToken(expires, test.tokenPath, "", "", 0, ""))
+    w := httptest.NewRecorder()
+    httpKeyServer(w, httptest.NewRequest("GET", uri, nil))
+    if w.Code != test.status {
+      t.Errorf("%s: status %d, want %d (%s)", test.name, w.Code, test.status, w.Body.String())
+      continue
+    }
+    if test.status == 200 && !bytes.Equal(w.Body.Bytes(), key) {
+      t.Errorf("%s: key %x, want %x", test.name, w.Body.Bytes(), key)
+    }
+  }
+}
+
+func TestSignedExternalSubtitles(t *testing.T) {
+  urlSigningSecret = []byte("secret")
+  defer func() { urlSigningSecret = nil }()
+  dir, err := ioutil.TempDir("", "ams")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  config := `{ "SegmentDuration": 4, "Tracks": { "subtitle": [ { "Name": "caption_eng", "Bandwidth": 256, "File": "video.en.vtt", "Lang": "eng" } ] } }`
+  err = ioutil.WriteFile(path.Join(dir, "video.json"), []byte(config), 0644)
+  if err != nil {
+    t.Fatal(err)
+  }
+  vtt := "WEBVTT\n\n00:00.000 --> 00:01.000\nHello\n"
+  err = ioutil.WriteFile(path.Join(dir, "video.en.vtt"), []byte(vtt), 0644)
+  if err != nil {
+    t.Fatal(err)
+  }
+  jConfig, err := readJsonConfig(path.Join(dir, "video.json"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  adaptationSet, _ := createExternalSubtitlesAdaptationSet(jConfig.Tracks["subtitle"])
+  m := regexp.MustCompile(`<BaseURL>([^<]*)</BaseURL>`).FindStringSubmatch(adaptationSet)
+  if m == nil || path.Join(dir, "video.json/dash", m[1]) != path.Join(dir, "video.json/subtitles/video.en.vtt") {
+    t.Fatalf("subtitles adaptation set %s", adaptationSet)
+  }
+
+  expires := time.Now().Unix() + 60
+  tests := []struct {
+    name      string
+    tokenPath string
+    file      string
+    status    int
+  }{
+    { "token of the asset", path.Join(dir, "video"), "video.en.vtt", 200 },
+    { "token of another asset", path.Join(dir, "video2"), "video.en.vtt", 403 },
+    { "file of no subtitle track", path.Join(dir, "video"), "video.json", 404 },
+    { "file out of the asset directory", path.Join(dir, "video"), "../video.en.vtt", 404 },
+  }
+  for _, test := range tests {
+    token := signUrlToken(expires, test.tokenPath, "", "", 0, "")
+    w := httptest.NewRecorder()
+    httpRootServer(w, httptest.NewRequest("GET", path.Join(dir, "video.json") + "/subtitles/" + test.file + "?" + urlTokenName + "=" + url.QueryEscape(token), nil))
+    if w.Code != test.status {
+      t.Errorf("%s: status %d, want %d (%s)", test.name, w.Code, test.status, w.Body.String())
+      continue
+    }
+    if test.status == 200 && w.Body.String() != vtt {
+      t.Errorf("%s: subtitles %q, want %q", test.name, w.Body.String(), vtt)
+    }
+  }
+}
+
+func TestTokenPath(t *testing.T) {
+  tests := []struct {
+    urlPath string
+    prefix  string
+    valid   bool
+  }{
+    { "/vod/a.json/.mpd", "/vod/a", true },
+    { "/vod/a.json/.mpd", "/vod/a.json", true },
+    { "/vod/a.json", "/vod/a.json", true },
+    { "/vod/a/b.json/.mpd", "/vod/a", true },
+    { "/vod/a/b.json/.mpd", "/vod/", true },
+    { "/vod/abc.json/.mpd", "/vod/a", false },
+    { "/vod/a-secret/b.json/.mpd", "/vod/a", false },
+    { "/vod/a.json2/.mpd", "/vod/a.json", false },
+    { "/vod/a.json/.mpd", "", false },
+  }
+  for _, test := range tests {
+    if isTokenPath(test.urlPath, test.prefix) != test.valid {
+      t.Errorf("isTokenPath(%q, %q) != %v", test.urlPath, test.prefix, test.valid)
+    }
+  }
+}
+
+func TestStreamSessionLimit(t *testing.T) {
+  urlSigningSecret = []byte("secret")
+  defer func() { urlSigningSecret = nil }()
+  streamSessions = make(map[string]map[string]time.Time)
+  expires := time.Now().Unix() + 60
+  first := signUrlToken(expires, "/vod", "", "1234", 1, newSessionId())
+  second := signUrlToken(expires, "/vod", "", "1234", 1, newSessionId())
+  // Token without playback session id
+  shared := signUrlToken(expires, "/vod", "", "5678", 1, "")
+
+  tests := []struct {
+    name    string
+    token   string
+    url     string
+    client  string // Client address and user agent
+    expire  bool   // Sessions made inactive before the request
+    refused bool
+  }{
+    { "manifest of the first session", first, "/vod/video.json/.mpd", "192.0.2.1 A", false, false },
+    { "segment of the first session", first, "/vod/video.json/dash/video-video_eng=81709-1.m4s", "192.0.2.1 A", false, false },
+    { "manifest of the second session", second, "/vod/video.json/.mpd", "192.0.2.1 A", false, true },
+    { "init segment of the second session", second, "/vod/video.json/dash/video-video_eng=81709.dash", "192.0.2.1 A", false, true },
+    { "segment of the second session", second, "/vod/video.json/dash/video-video_eng=81709-1.m4s", "192.0.2.1 A", false, true },
+    { "key of the second session", second, "/vod/video.json/20000000200020002000200000000002", "192.0.2.1 A", false, true },
+    { "first session from another address", first, "/vod/video.json/ts/video-video_eng=81709-2.ts", "192.0.2.2 A", false, false },
+    { "segment of the second session after the first one", second, "/vod/video.json/dash/video-video_eng=81709-2.m4s", "192.0.2.1 A", true, false },
+    { "manifest without session id", shared, "/vod/video.json/.mpd", "192.0.2.3 A", false, false },
+    { "segment without session id", shared, "/vod/video.json/dash/video-video_eng=81709-1.m4s", "192.0.2.3 A", false, false },
+    { "without session id from another address", shared, "/vod/video.json/.mpd", "192.0.2.4 A", false, true },
+    { "without session id from another user agent", shared, "/vod/video.json/dash/video-video_eng=81709-1.m4s", "192.0.2.3 B", false, true },
+  }
+  for _, test := range tests {
+    if test.expire {
+      for _, sessions := range streamSessions {
+        for s := range sessions {
+          sessions[s] = time.Now().Add(-2 * streamSessionTimeout)
+        }
+      }
+    }
+    r := httptest.NewRequest("GET", test.url, nil)
+    client := strings.SplitN(test.client, " ", 2)
+    r.RemoteAddr = client[0] + ":1234"
+    r.Header.Set("User-Agent", client[1])
+    claims, err := checkUrlToken(test.token, test.url, r)
+    if err != nil {
+      t.Fatalf("%s: %v", test.name, err)
+    }
+    err = trackStreamSession(claims, r)
+    if (err != nil) != test.refused {
+      t.Errorf("%s: error %v, refused %v", test.name, err, test.refused)
+    }
+  }
+}
+
+func TestReadKeyPeriods(t *testing.T) {
+  keys := []mp4.DrmKey{
+    { KeyId: "30000000300030003000300000000003", Key: "112233445566778899aabbccddeeff00", PeriodId: "p1" },
+    { KeyId: "40000000400040004000400000000004", Key: "000102030405060708090a0b0c0d0e0f", PeriodId: "p2" },
+  }
+  tests := []struct {
+    name    string
+    periods []mp4.DrmKeyPeriod
+    bounds  []uint64 // Start and end of each period in milliseconds, nil for an error
+  }{
+    { "indexes", []mp4.DrmKeyPeriod{ { Id: "p2", Index: 2 }, { Id: "p1", Index: 1 } }, []uint64{ 0, 8000, 8000, 16000 } },
+    { "start and end", []mp4.DrmKeyPeriod{
+      { Id: "p1", Index: 1, Start: "2026-01-01T00:00:00Z", End: "2026-01-01T00:00:30Z" },
+      { Id: "p2", Index: 2, Start: "2026-01-01T00:00:30Z", End: "2026-01-01T00:01:00.500Z" },
+    }, []uint64{ 0, 30000, 30000, 60500 } },
+    { "start and end without time zone", []mp4.DrmKeyPeriod{
+      { Id: "p1", Index: 1, Start: "2026-01-01T00:00:00", End: "2026-01-01T00:00:10" },
+      { Id: "p2", Index: 2, Start: "2026-01-01T00:00:20", End: "2026-01-01T00:00:30" },
+    }, []uint64{ 0, 10000, 20000, 30000 } },
+    { "missing end", []mp4.DrmKeyPeriod{
+      { Id: "p1", Index: 1, Start: "2026-01-01T00:00:00Z", End: "2026-01-01T00:00:30Z" },
+      { Id: "p2", Index: 2, Start: "2026-01-01T00:00:30Z" },
+    }, []uint64{ 0, 8000, 8000, 16000 } },
+    { "end before start", []mp4.DrmKeyPeriod{
+      { Id: "p1", Index: 1, Start: "2026-01-01T00:00:00Z", End: "2026-01-01T00:00:30Z" },
+      { Id: "p2", Index: 2, Start: "2026-01-01T00:00:30Z", End: "2026-01-01T00:00:30Z" },
+    }, nil },
+    { "invalid date", []mp4.DrmKeyPeriod{
+      { Id: "p1", Index: 1, Start: "tomorrow", End: "2026-01-01T00:00:30Z" },
+      { Id: "p2", Index: 2, Start: "2026-01-01T00:00:30Z", End: "2026-01-01T00:01:00Z" },
+    }, nil },
+    { "period without key", []mp4.DrmKeyPeriod{ { Id: "p1", Index: 1 }, { Id: "p3", Index: 3 } }, nil },
+  }
+  for _, test := range tests {
+    drm := mp4.DrmConfig{ Keys: keys, KeyPeriods: test.periods }
+    periods, err := readKeyPeriods(drm, "", "video", 8)
+    if test.bounds == nil {
+      if err == nil {
+        t.Errorf("%s: key periods %+v, want an error", test.name, periods)
+      }
+      continue
+    }
+    if err != nil || len(periods) != 2 {
+      t.Errorf("%s: key periods %+v, error %v", test.name, periods, err)
+      continue
+    }
+    for i, p := range periods {
+      if p.KeyId[0] != byte(0x30 + 0x10 * i) || p.Start != test.bounds[2 * i] || p.End != test.bounds[2 * i + 1] {
+        t.Errorf("%s: key period %d: key id %x from %d to %d ms", test.name, i, p.KeyId, p.Start, p.End)
+      }
+    }
+  }
+}
+
+// Keys of a CPIX document selected by track type and key period
+func TestCpixKeySelection(t *testing.T) {
+  cpix := `<CPIX xmlns="urn:dashif:org:cpix" xmlns:pskc="urn:ietf:params:xml:ns:keyprov:pskc">
+  <ContentKeyList>
+    <ContentKey kid="30000000-3000-3000-3000-300000000003"><Data><pskc:Secret><pskc:PlainValue>ESIzRFVmd4iZqrvM3e7/AA==</pskc:PlainValue></pskc:Secret></Data></ContentKey>
+    <ContentKey kid="40000000-4000-4000-4000-400000000004"><Data><pskc:Secret><pskc:PlainValue>AAECAwQFBgcICQoLDA0ODw==</pskc:PlainValue></pskc:Secret></Data></ContentKey>
+    <ContentKey kid="50000000-5000-5000-5000-500000000005"><Data><pskc:Secret><pskc:PlainValue>K34VFiiu0qar9xWICc9PPA==</pskc:PlainValue></pskc:Secret></Data></ContentKey>
+    <ContentKey kid="70000000-7000-7000-7000-700000000007"><Data><pskc:Secret><pskc:PlainValue>Dw4NDAsKCQgHBgUEAwIBAA==</pskc:PlainValue></pskc:Secret></Data></ContentKey>
+  </ContentKeyList>
+  <ContentKeyPeriodList>
+    <ContentKeyPeriod id="p2" index="2" start="2026-01-01T00:00:30Z" end="2026-01-01T00:01:00Z"/>
+    <ContentKeyPeriod id="p1" index="1" start="2026-01-01T00:00:00Z" end="2026-01-01T00:00:30Z"/>
+  </ContentKeyPeriodList>
+  <ContentKeyUsageRuleList>
+    <ContentKeyUsageRule kid="30000000-3000-3000-3000-300000000003" intendedTrackType="VIDEO"><KeyPeriodFilter periodId="p1"/></ContentKeyUsageRule>
+    <ContentKeyUsageRule kid="40000000-4000-4000-4000-400000000004"><KeyPeriodFilter periodId="p1"/><AudioFilter/></ContentKeyUsageRule>
+    <ContentKeyUsageRule kid="50000000-5000-5000-5000-500000000005"><KeyPeriodFilter periodId="p2"/><VideoFilter/></ContentKeyUsageRule>
+    <ContentKeyUsageRule kid="70000000-7000-7000-7000-700000000007"><KeyPeriodFilter periodId="p1"/><KeyPeriodFilter periodId="p2"/></ContentKeyUsageRule>
+  </ContentKeyUsageRuleList>
+</CPIX>`
+  dir, err := ioutil.TempDir("", "ams")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  err = ioutil.WriteFile(path.Join(dir, "keys.xml"), []byte(cpix), 0644)
+  if err != nil {
+    t.Fatal(err)
+  }
+  drm, err := mp4.ReadCpixFile(path.Join(dir, "keys.xml"))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  tests := []struct {
+    trackType string
+    key       byte   // First byte of the key id of the first key period
+    periods   string // First bytes of the key ids of the key periods with their bounds, empty for an error
+  }{
+    { "video", 0x30, "30:0-30000 50:30000-60000" },
+    // The key of all the tracks is used in the periods without audio key
+    { "audio", 0x40, "40:0-30000 70:30000-60000" },
+    { "text", 0x70, "70:0-30000 70:30000-60000" },
+  }
+  for _, test := range tests {
+    keyId, _, err := readDrmKey(*drm, dir, test.trackType)
+    if err != nil || keyId[0] != test.key {
+      t.Errorf("%s: key id %x (%v), want %02x...", test.trackType, keyId, err, test.key)
+    }
+    periods, err := readKeyPeriods(*drm, dir, test.trackType, 0)
+    if err != nil {
+      t.Errorf("%s: %v", test.trackType, err)
+      continue
+    }
+    var s []string
+    for _, p := range periods {
+      s = append(s, hex.EncodeToString(p.KeyId[:1]) + ":" + strconv.FormatUint(p.Start, 10) + "-" + strconv.FormatUint(p.End, 10))
+    }
+    if strings.Join(s, " ") != test.periods {
+      t.Errorf("%s: key periods %s, want %s", test.trackType, strings.Join(s, " "), test.periods)
+    }
+  }
+
+  // The key of the first period is not found anymore without usage rule for the track type nor for all the tracks
+  drm.Keys = drm.Keys[:len(drm.Keys) - 2]
+  if _, _, err := readDrmKey(*drm, dir, "text"); err == nil {
+    t.Errorf("key found for text tracks without usage rule")
+  }
+  if _, err := readKeyPeriods(*drm, dir, "audio", 0); err == nil {
+    t.Errorf("key periods found for audio tracks without audio key in the second period")
+  }
+}
+
+// Codec configuration box (eg: hvcC) parsed from its payload, in a sample entry (eg: hvc1) of a file
+func parseCodecConfigBox(t *testing.T, entry string, name string, payload []byte) interface{} {
+  box := func(name string, payloads ...[]byte) []byte {
+    data := make([]byte, 8)
+    copy(data[4:8], name)
+    for _, payload := range payloads {
+      data = append(data, payload...)
+    }
+    binary.BigEndian.PutUint32(data[0:4], uint32(len(data)))
+    return data
+  }
+  stsd := box("stsd", []byte{ 0, 0, 0, 0, 0, 0, 0, 1 }, box(entry, make([]byte, 78), box(name, payload)))
+  dir, err := ioutil.TempDir("", "ams")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  filename := path.Join(dir, "video.mp4")
+  err = ioutil.WriteFile(filename, box("moov", box("trak", box("mdia", box("minf", box("stbl", stsd))))), 0644)
+  if err != nil {
+    t.Fatal(err)
+  }
+  boxes := mp4.ParseFile(filename, "und").Boxes["moov.trak.mdia.minf.stbl.stsd." + entry + "." + name]
+  if len(boxes) != 1 {
+    t.Fatalf("%s Box not found in %s sample entry", name, entry)
+  }
+
+  return boxes[0]
+}
+
+func TestHevcCodecs(t *testing.T) {
+  tests := []struct {
+    entry              string
+    hvcC               string
+    lengthSizeMinusOne byte
+    codecs             string
+  }{
+    // Version, profile space, tier and profile, compatibility flags, constraint flags, level, 4:2:0, 8 or 10 bits
+    // and lengthSizeMinusOne in the low bits of constantFrameRate, without NAL unit arrays
+    { "hvc1", "01" + "01" + "60000000" + "b00000000000" + "5d" + "f000fcfdf8f80000" + "0f" + "00", 3, "hvc1.1.6.L93.B0" },
+    { "hev1", "01" + "02" + "20000000" + "b00000000000" + "78" + "f000fcfdfafa0000" + "0f" + "00", 3, "hev1.2.4.L120.B0" },
+    { "hvc1", "01" + "61" + "60000000" + "900000000000" + "96" + "f000fcfdf8f80000" + "0d" + "00", 1, "hvc1.A1.6.H150.90" },
+    { "hvc1", "01" + "04" + "08000000" + "000000000000" + "5a" + "f000fcfef8f80000" + "0f" + "00", 3, "hvc1.4.10.L90" },
+  }
+  for _, test := range tests {
+    payload, _ := hex.DecodeString(test.hvcC)
+    hvcC := parseCodecConfigBox(t, test.entry, "hvcC", payload).(mp4.HvcCBox)
+    if codecs := hevcCodecs(test.entry, hvcC); codecs != test.codecs {
+      t.Errorf("hvcC %s: codecs %q, want %q", test.hvcC, codecs, test.codecs)
+    }
+    if hvcC.LengthSizeMinusOne() != test.lengthSizeMinusOne {
+      t.Errorf("hvcC %s: lengthSizeMinusOne %d, want %d", test.hvcC, hvcC.LengthSizeMinusOne(), test.lengthSizeMinusOne)
+    }
+  }
+}
+
+func TestVp9Codecs(t *testing.T) {
+  tests := []struct {
+    vpcC   string
+    codecs string
+  }{
+    // Version and flags, profile, level, bit depth, chroma subsampling and full range flag, colour primaries,
+    // transfer characteristics, matrix coefficients and codec initialization data size
+    { "01000000" + "00" + "0a" + "82" + "010101" + "0000", "vp09.00.10.08" },
+    { "01000000" + "00" + "1f" + "80" + "010101" + "0000", "vp09.00.31.08.00.01.01.01.00" },
+    { "01000000" + "02" + "29" + "a2" + "091009" + "0000", "vp09.02.41.10.01.09.16.09.00" },
+    { "01000000" + "01" + "32" + "87" + "010d00" + "0000", "vp09.01.50.08.03.01.13.00.01" },
+  }
+  for _, test := range tests {
+    payload, _ := hex.DecodeString(test.vpcC)
+    vpcC := parseCodecConfigBox(t, "vp09", "vpcC", payload).(mp4.VpcCBox)
+    if codecs := vp9Codecs(vpcC); codecs != test.codecs {
+      t.Errorf("vpcC %s: codecs %q, want %q", test.vpcC, codecs, test.codecs)
+    }
+  }
+}
+
+func TestAv1Codecs(t *testing.T) {
+  tests := []struct {
+    av1C   string
+    codecs string
+  }{
+    // Marker and version, profile and level, tier, bit depth and chroma subsampling, without configuration OBUs
+    { "81" + "04" + "0c" + "00", "av01.0.04M.08" },
+    { "81" + "08" + "4c" + "00", "av01.0.08M.10" },
+    { "81" + "2d" + "c0" + "00", "av01.1.13H.10" },
+    { "81" + "53" + "ec" + "00", "av01.2.19H.12" },
+  }
+  for _, test := range tests {
+    payload, _ := hex.DecodeString(test.av1C)
+    av1C := parseCodecConfigBox(t, "av01", "av1C", payload).(mp4.Av1CBox)
+    if codecs := av1Codecs(av1C); codecs != test.codecs {
+      t.Errorf("av1C %s: codecs %q, want %q", test.av1C, codecs, test.codecs)
+    }
+  }
+}
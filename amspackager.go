@@ -38,20 +38,28 @@ import (
 	"mp4"
         "path"
 	"encoding/json"
+	"encoding/base64"
 	"errors"
+	"strings"
 )
 
 type fileSlice []string
 type languageSlice []string
+type roleSlice []string
+type labelSlice []string
 
 type inputFile struct {
   Filename string
   Language string
+  Role string
+  Label string
 }
 
 // Global vars for Flags
 var inputFilenames fileSlice
 var languageCodes languageSlice
+var roles roleSlice
+var labels labelSlice
 
 func (s *fileSlice) String() string {
   return fmt.Sprintf("%+v", *s)
@@ -82,18 +90,217 @@ func (s *languageSlice) Set(value string) error {
   return nil
 }
 
+func (s *roleSlice) String() string {
+  return fmt.Sprintf("%+v", *s)
+}
+
+func (s *roleSlice) Set(value string) error {
+  if inputFilenames == nil {
+    return errors.New("no input filenames specified before -r option")
+  }
+  switch value {
+    case "main", "alternate", "commentary", "description":
+    default:
+      return errors.New("role must be main, alternate, commentary or description")
+  }
+  for len(*s) < len(inputFilenames) - 1 {
+    *s = append(*s, "")
+  }
+  *s = append(*s, value)
+
+  return nil
+}
+
+func (s *labelSlice) String() string {
+  return fmt.Sprintf("%+v", *s)
+}
+
+func (s *labelSlice) Set(value string) error {
+  if inputFilenames == nil {
+    return errors.New("no input filenames specified before -label option")
+  }
+  for len(*s) < len(inputFilenames) - 1 {
+    *s = append(*s, "")
+  }
+  *s = append(*s, value)
+
+  return nil
+}
+
+// ISO-639-2 language code of the MDHD Box of a track, empty if it is not set
+func mdhdLanguage(mp4File mp4.Mp4) string {
+  mdhd := mp4File.Boxes["moov.trak.mdia.mdhd"][0].(mp4.MdhdBox)
+  if mdhd.Language == 0 {
+    return ""
+  }
+
+  return string([]byte{ byte((0x7c00 & mdhd.Language) >> 10) + 0x60, byte((0x03e0 & mdhd.Language) >> 5) + 0x60, byte(0x1f & mdhd.Language) + 0x60 })
+}
+
+// Size of the samples of a track, the one of the mdat Box for files with a single track
+func trackDataSize(mp4File mp4.Mp4) (size uint64) {
+  if len(mp4File.Tracks) <= 1 {
+    return mp4File.Boxes["mdat"][0].(mp4.MdatBox).Size
+  }
+  stsz := mp4File.Boxes["moov.trak.mdia.minf.stbl.stsz"][0].(mp4.StszBox)
+  if stsz.SampleSize != 0 {
+    return uint64(stsz.SampleSize) * uint64(stsz.SampleCount)
+  }
+  for _, entrySize := range stsz.EntrySize {
+    size += uint64(entrySize)
+  }
+
+  return
+}
+
+// Number of tracks of a type (video or audio) packaged from an input file
+func fileTrackCount(mp4Files []mp4.Mp4, filename string) (count int) {
+  for _, mp4File := range mp4Files {
+    if mp4File.Filename == filename {
+      count++
+    }
+  }
+
+  return
+}
+
+// Tracks of files with several tracks get their track ID in their name, so that the ones with the same language and
+// codec have distinct representations (eg: audio_eng_track3)
+func trackIdSuffix(mp4File mp4.Mp4) string {
+  if len(mp4File.Tracks) <= 1 {
+    return ""
+  }
+
+  return fmt.Sprintf("_track%d", mp4File.TrackId)
+}
+
+// Every track of the input files is packaged, the ones of files with several tracks get the language of their MDHD Box
 func parseMp4Files(files []inputFile) (mp4Files map[string][]mp4.Mp4) {
   mp4Files = make(map[string][]mp4.Mp4)
   for _, in := range files {
     fmt.Printf("-- Parsing file='%s' language='%s'\n", in.Filename, in.Language)
-    mp4File := mp4.ParseFile(in.Filename, in.Language)
-    if mp4File.IsVideo == true {
-      mp4Files["video"] = append(mp4Files["video"], mp4File)
+    var tracks []mp4.Mp4
+    if path.Ext(in.Filename) == ".mp3" {
+      mp3File, err := mp4.ParseMp3File(in.Filename, in.Language)
+      if err != nil {
+        fmt.Printf("Cannot parse MPEG audio file '%s': %v\n", in.Filename, err)
+        continue
+      }
+      tracks = append(tracks, mp3File)
+    } else {
+      mp4File := mp4.ParseFile(in.Filename, in.Language)
+      for _, trackId := range mp4File.TrackIds() {
+        track := mp4File.Track(trackId)
+        if len(mp4File.Tracks) > 1 {
+          if language := mdhdLanguage(track); language != "" && language != "und" {
+            track.Language = language
+          }
+          fmt.Printf("   track %d language='%s'\n", trackId, track.Language)
+        }
+        tracks = append(tracks, track)
+      }
+    }
+    for _, mp4File := range tracks {
+      if mp4File.IsVideo == true {
+        mp4Files["video"] = append(mp4Files["video"], mp4File)
+      }
+      if mp4File.IsAudio == true {
+        mp4Files["audio"] = append(mp4Files["audio"], mp4File)
+      }
     }
-    if mp4File.IsAudio == true {
-      mp4Files["audio"] = append(mp4Files["audio"], mp4File)
+  }
+
+  return
+}
+
+// Location of the samples of a track from its STSC and STCO Boxes, or its CO64 Box for files over 4 GB
+func setChunkTables(mp4File mp4.Mp4, config *mp4.DashConfig) {
+  if mp4File.Boxes["moov.trak.mdia.minf.stbl.stsc"] == nil {
+    return
+  }
+  stsc := mp4File.Boxes["moov.trak.mdia.minf.stbl.stsc"][0].(mp4.StscBox)
+  if mp4File.Boxes["moov.trak.mdia.minf.stbl.co64"] != nil {
+    co64 := mp4File.Boxes["moov.trak.mdia.minf.stbl.co64"][0].(mp4.Co64Box)
+    config.StscBoxOffset = stsc.Offset
+    config.StscBoxSize = stsc.Size
+    config.Co64BoxOffset = co64.Offset
+    config.Co64BoxSize = co64.Size
+    return
+  }
+  if mp4File.Boxes["moov.trak.mdia.minf.stbl.stco"] == nil {
+    return
+  }
+  stco := mp4File.Boxes["moov.trak.mdia.minf.stbl.stco"][0].(mp4.StcoBox)
+  config.StscBoxOffset = stsc.Offset
+  config.StscBoxSize = stsc.Size
+  config.StcoBoxOffset = stco.Offset
+  config.StcoBoxSize = stco.Size
+}
+
+// Protection of a pre-encrypted track from its encv/enca sample entry, its PSSH Boxes and its sample auxiliary
+// information boxes, the samples are packaged as they are
+func readProtection(mp4File mp4.Mp4, entryPath string, dataFormat string) (protection *mp4.DashProtection, err error) {
+  if mp4File.Boxes[entryPath + ".sinf.frma"] == nil || mp4File.Boxes[entryPath + ".sinf.schm"] == nil || mp4File.Boxes[entryPath + ".sinf.schi.tenc"] == nil {
+    err = errors.New("missing frma, schm or tenc box")
+    return
+  }
+  frma := mp4File.Boxes[entryPath + ".sinf.frma"][0].(mp4.FrmaBox)
+  if string(frma.DataFormat[:]) != dataFormat {
+    err = errors.New("unsupported original format '" + string(frma.DataFormat[:]) + "'")
+    return
+  }
+  schm := mp4File.Boxes[entryPath + ".sinf.schm"][0].(mp4.SchmBox)
+  tenc := mp4File.Boxes[entryPath + ".sinf.schi.tenc"][0].(mp4.TencBox)
+  protection = new(mp4.DashProtection)
+  protection.Scheme = string(schm.SchemeType[:])
+  protection.SchemeVersion = schm.SchemeVersion
+  protection.TencVersion = tenc.Version
+  protection.CryptByteBlock = tenc.DefaultCryptByteBlock
+  protection.SkipByteBlock = tenc.DefaultSkipByteBlock
+  protection.IsProtected = tenc.DefaultIsProtected
+  protection.PerSampleIvSize = tenc.DefaultPerSampleIvSize
+  protection.KeyId = tenc.DefaultKid
+  protection.ConstantIv = tenc.DefaultConstantIv
+  for _, box := range mp4File.Boxes["moov.pssh"] {
+    pssh := box.(mp4.PsshBox)
+    protection.Pssh = append(protection.Pssh, base64.StdEncoding.EncodeToString(pssh.Bytes()))
+  }
+
+  // The sample auxiliary information is found with SAIZ and SAIO Boxes, or in a SENC Box
+  stblPath := "moov.trak.mdia.minf.stbl"
+  if mp4File.Boxes[stblPath + ".saiz"] != nil {
+    saiz := mp4File.Boxes[stblPath + ".saiz"][0].(mp4.SaizBox)
+    protection.SaizBoxOffset = saiz.Offset
+    protection.SaizBoxSize = saiz.Size
+    if saiz.DefaultSampleInfoSize != 0 {
+      protection.AuxInfoSize = uint32(saiz.DefaultSampleInfoSize) * saiz.SampleCount
+    } else {
+      for _, size := range saiz.SampleInfoSize {
+        protection.AuxInfoSize += uint32(size)
+      }
     }
   }
+  switch {
+    case mp4File.Boxes[stblPath + ".saio"] != nil:
+      saio := mp4File.Boxes[stblPath + ".saio"][0].(mp4.SaioBox)
+      if saio.EntryCount != 1 || protection.SaizBoxOffset == 0 {
+        err = errors.New("sample auxiliary information must be in one chunk described by saiz and saio boxes")
+        return
+      }
+      protection.AuxInfoOffset = int64(saio.Offsets[0])
+    case mp4File.Boxes[stblPath + ".senc"] != nil:
+      senc := mp4File.Boxes[stblPath + ".senc"][0].(mp4.SencBox)
+      protection.AuxInfoOffset = senc.Offset + 8
+      protection.AuxInfoSize = senc.Size - 8
+      protection.Subsamples = senc.Flags[2] & 0x02 != 0
+    default:
+      if protection.PerSampleIvSize != 0 {
+        err = errors.New("missing sample auxiliary information (senc or saiz and saio boxes)")
+        return
+      }
+      protection.SaizBoxOffset = 0
+      protection.AuxInfoSize = 0
+  }
 
   return
 }
@@ -101,30 +308,38 @@ func parseMp4Files(files []inputFile) (mp4Files map[string][]mp4.Mp4) {
 func main() {
   if len(os.Args) < 2 {
     fmt.Printf("Afrostream Media Server version 0.1     Sebastien Petit <spebsd@gmail.com>\n")
-    fmt.Printf("Usage: amspackager -o [json output filename] < -d [segment duration] > { -i [mp4 or vtt input file] < -l [language] > ... }\n")
+    fmt.Printf("Usage: amspackager -o [json output filename] < -d [segment duration] > { -i [mp4, mp3 or vtt input file] < -l [language] > < -r [role] > < -label [label] > ... }\n")
     fmt.Printf("  < ... > options are optional\n")
-    fmt.Printf("  [mp4 or vtt input file]     must be audio mp4a / video avc1 / vtt subtitles files\n")
-    fmt.Printf("                              only one stream per mp4 file is supported\n")
+    fmt.Printf("  [mp4, mp3 or vtt input file] must be audio mp4a, ac-3, ec-3, Opus or fLaC / video avc1, hvc1, hev1, vp09, av01 or mp4v / raw mp3 / vtt subtitles files\n")
+    fmt.Printf("                              pre-encrypted enca / encv files are packaged as they are\n")
+    fmt.Printf("                              every track of an mp4 file is packaged, with the language of its mdhd box if the file has several tracks\n")
     fmt.Printf("  -d [segment duration]       duration of each segments in seconds\n")
     fmt.Printf("                              default value: 10\n")
     fmt.Printf("  -l [language]               ISO-639-2 language code for the input file preceeding this argument\n")
+    fmt.Printf("  -r [role]                   role of the audio input file preceeding this argument\n")
+    fmt.Printf("                              main (default), alternate, commentary or description (audio description)\n")
+    fmt.Printf("  -label [label]              name shown by the players for the input file preceeding this argument\n")
+    fmt.Printf("  -cpix [cpix document]       DASH-IF CPIX document with the content keys, DRM systems signalling and key periods\n")
     fmt.Printf("\n")
-    fmt.Printf("Example: amspackager -o video.json -d 8 -i video-384k.mp4 -i video-1500k.mp4 -i video-2950k.mp4 -i audio-128k.mp4 -i sub_fr.vtt -l fra -i sub_en.vtt -l eng\n")
+    fmt.Printf("Example: amspackager -o video.json -d 8 -i video-384k.mp4 -i video-1500k.mp4 -i video-2950k.mp4 -i audio-128k.mp4 -i audio-ad-96k.mp4 -r description -i sub_fr.vtt -l fra -i sub_en.vtt -l eng\n")
 
     return
   }
 
   jsonFilename := flag.String("o", "video.json", "JSON output filename (default: video.json)")
   segmentDuration := flag.Uint("d", 10, "segment duration (default: 10)")
-  flag.Var(&inputFilenames, "i", "MP4 or VTT input filename")
+  flag.Var(&inputFilenames, "i", "MP4, MP3 or VTT input filename, all the tracks of an MP4 file are packaged")
   flag.Var(&languageCodes, "l", "ISO-639-2 language code")
+  flag.Var(&roles, "r", "audio role (main, alternate, commentary or description)")
+  flag.Var(&labels, "label", "label shown by the players")
+  cpixFilename := flag.String("cpix", "", "CPIX document filename")
   flag.Parse()
 
   var mp4FileSlice []inputFile
   var vttFileSlice []inputFile
   for i, inputFilename := range inputFilenames {
     switch path.Ext(inputFilename) {
-      case ".mp4":
+      case ".mp4", ".mp3":
         var in inputFile
         in.Filename = inputFilename
         if i < len(languageCodes) && languageCodes[i] != "" {
@@ -132,6 +347,12 @@ func main() {
         } else {
           in.Language = "eng"
         }
+        if i < len(roles) {
+          in.Role = roles[i]
+        }
+        if i < len(labels) {
+          in.Label = labels[i]
+        }
         mp4FileSlice = append(mp4FileSlice, in)
       case ".vtt":
         var in inputFile
@@ -141,9 +362,12 @@ func main() {
         } else {
           in.Language = "eng"
         }
+        if i < len(labels) {
+          in.Label = labels[i]
+        }
         vttFileSlice = append(vttFileSlice, in)
       default:
-        fmt.Printf("Sorry, but the file %s is unkwown and can't be packaged. Please use .mp4 or .vtt extensions for your files\n", inputFilename)
+        fmt.Printf("Sorry, but the file %s is unkwown and can't be packaged. Please use .mp4, .mp3 or .vtt extensions for your files\n", inputFilename)
     }
   }
 
@@ -169,19 +393,57 @@ func main() {
     }
     stsz := mp4File.Boxes["moov.trak.mdia.minf.stbl.stsz"][0].(mp4.StszBox)
     stss := mp4File.Boxes["moov.trak.mdia.minf.stbl.stss"][0].(mp4.StssBox)
-    avc1 := mp4File.Boxes["moov.trak.mdia.minf.stbl.stsd.avc1"][0].(mp4.Avc1Box)
-    avcC := mp4File.Boxes["moov.trak.mdia.minf.stbl.stsd.avc1.avcC"][0].(mp4.AvcCBox)
+    // Pre-encrypted files have an encv sample entry, their original format is in the frma box
+    var entryPath string
+    for _, entry := range []string{ "avc1", "hvc1", "hev1", "vp09", "av01", "mp4v", "encv" } {
+      if mp4File.Boxes["moov.trak.mdia.minf.stbl.stsd." + entry] != nil {
+        entryPath = "moov.trak.mdia.minf.stbl.stsd." + entry
+        break
+      }
+    }
+    if entryPath == "" {
+      fmt.Printf("Cannot package file '%s': unsupported sample entry, the video track is skipped\n", mp4File.Filename)
+      continue
+    }
+    codec := path.Ext(entryPath)[1:]
+    if codec == "encv" && mp4File.Boxes[entryPath + ".sinf.frma"] != nil {
+      frma := mp4File.Boxes[entryPath + ".sinf.frma"][0].(mp4.FrmaBox)
+      codec = string(frma.DataFormat[:])
+    }
+    var avc1 mp4.Avc1Box
+    switch entry := mp4File.Boxes[entryPath][0].(type) {
+      case mp4.Avc1Box:
+        avc1 = entry
+      case mp4.Hvc1Box:
+        avc1 = mp4.Avc1Box(entry)
+      case mp4.Mp4vBox:
+        avc1.Width = entry.Width
+        avc1.Height = entry.Height
+        avc1.HorizontalResolution = entry.Horizresol
+        avc1.VerticalResolution = entry.Vertiresol
+        avc1.EntryDataSize = entry.Reserved2
+        avc1.FramesPerSample = entry.Predefined1
+        avc1.BitDepth = entry.Depth
+        avc1.ColorTableIndex = int16(entry.Predefined2)
+    }
     elst := mp4File.Boxes["moov.trak.edts.elst"][0].(mp4.ElstBox)
     var t mp4.TrackEntry
-    t.Bandwidth = uint64(float64(mdat.Size) / (float64(mdhd.Duration) / float64(mdhd.Timescale)) * 8)
+    t.Bandwidth = uint64(float64(trackDataSize(mp4File)) / (float64(mdhd.Duration) / float64(mdhd.Timescale)) * 8)
     t.Name = "video_" + mp4File.Language
+    if codec != "avc1" {
+      // HEVC, VP9, AV1 and MPEG-4 Part 2 tracks are streams of their own next to the AVC ones
+      t.Name += "_" + codec
+    }
+    t.Name += trackIdSuffix(mp4File)
     t.File = mp4File.Filename
     t.Lang = mp4File.Language
+    t.TrackId = mp4File.TrackId
     t.Config = new(mp4.DashConfig)
     t.Config.StszBoxOffset = stsz.Offset
-    t.Config.StszBoxSize = stsz.Size
+    t.Config.StszBoxSize = uint64(stsz.Size)
     t.Config.MdatBoxOffset = mdat.Offset
-    t.Config.MdatBoxSize = mdat.Size
+    t.Config.MdatBoxSize = trackDataSize(mp4File)
+    setChunkTables(mp4File, t.Config)
     t.Config.Type = "video"
     t.Config.Rate = 0x00010000
     t.Config.Volume = 0x0100
@@ -202,20 +464,57 @@ func main() {
     t.Config.Video.FramesPerSample = avc1.FramesPerSample
     t.Config.Video.BitDepth = avc1.BitDepth
     t.Config.Video.ColorTableIndex = avc1.ColorTableIndex
-    t.Config.Video.CodecInfo = [3]byte{ avcC.AVCProfileIndication, avcC.ProfileCompatibility, avcC.AVCLevelIndication }
-    t.Config.Video.NalUnitSize = avcC.NalUnitSize & 0x03
-    t.Config.Video.SPSEntryCount = avcC.SPSEntryCount
-    t.Config.Video.SPSSize = avcC.SPSSize
-    t.Config.Video.SPSData = avcC.SPSData
-    t.Config.Video.PPSEntryCount = avcC.PPSEntryCount
-    t.Config.Video.PPSSize = avcC.PPSSize
-    t.Config.Video.PPSData = avcC.PPSData
+    switch {
+      case codec == "avc1" && mp4File.Boxes[entryPath + ".avcC"] != nil:
+        avcC := mp4File.Boxes[entryPath + ".avcC"][0].(mp4.AvcCBox)
+        t.Config.Video.CodecInfo = [3]byte{ avcC.AVCProfileIndication, avcC.ProfileCompatibility, avcC.AVCLevelIndication }
+        t.Config.Video.NalUnitSize = avcC.NalUnitSize & 0x03
+        t.Config.Video.SPSEntryCount = avcC.SPSEntryCount
+        t.Config.Video.SPSSize = avcC.SPSSize
+        t.Config.Video.SPSData = avcC.SPSData
+        t.Config.Video.PPSEntryCount = avcC.PPSEntryCount
+        t.Config.Video.PPSSize = avcC.PPSSize
+        t.Config.Video.PPSData = avcC.PPSData
+      case (codec == "hvc1" || codec == "hev1") && mp4File.Boxes[entryPath + ".hvcC"] != nil:
+        // HEVC parameter sets are kept in their HVCC Box, NAL unit length size is in lengthSizeMinusOne
+        hvcC := mp4File.Boxes[entryPath + ".hvcC"][0].(mp4.HvcCBox)
+        t.Config.Video.Codec = codec
+        t.Config.Video.HvcC = &hvcC
+        t.Config.Video.NalUnitSize = hvcC.LengthSizeMinusOne()
+      case codec == "vp09" && mp4File.Boxes[entryPath + ".vpcC"] != nil:
+        vpcC := mp4File.Boxes[entryPath + ".vpcC"][0].(mp4.VpcCBox)
+        t.Config.Video.Codec = codec
+        t.Config.Video.VpcC = &vpcC
+      case codec == "av01" && mp4File.Boxes[entryPath + ".av1C"] != nil:
+        av1C := mp4File.Boxes[entryPath + ".av1C"][0].(mp4.Av1CBox)
+        t.Config.Video.Codec = codec
+        t.Config.Video.Av1C = &av1C
+      case codec == "mp4v" && mp4File.Boxes[entryPath + ".esds"] != nil:
+        esds := mp4File.Boxes[entryPath + ".esds"][0].(mp4.EsdsBox)
+        t.Config.Video.Codec = codec
+        err := mp4.ReadEsdsVideoConfig(esds, t.Config.Video)
+        if err != nil {
+          fmt.Printf("Cannot read the video configuration of file '%s': %v\n", mp4File.Filename, err)
+          return
+        }
+      default:
+        fmt.Printf("Cannot package file '%s': unsupported video format '%s'\n", mp4File.Filename, codec)
+        return
+    }
     t.Config.Video.StssBoxOffset = stss.Offset
     t.Config.Video.StssBoxSize = stss.Size
     if cttsBoxPresent == true {
       t.Config.Video.CttsBoxOffset = ctts.Offset
       t.Config.Video.CttsBoxSize = ctts.Size
     }
+    if path.Ext(entryPath) == ".encv" {
+      protection, err := readProtection(mp4File, entryPath, codec)
+      if err != nil {
+        fmt.Printf("Cannot package pre-encrypted file '%s': %v\n", mp4File.Filename, err)
+        return
+      }
+      t.Config.Protection = protection
+    }
     jConf.Tracks["video"] = append(jConf.Tracks["video"], t)
   }
 
@@ -225,18 +524,53 @@ func main() {
     hdlr := mp4File.Boxes["moov.trak.mdia.hdlr"][0].(mp4.HdlrBox)
     stts := mp4File.Boxes["moov.trak.mdia.minf.stbl.stts"][0].(mp4.SttsBox)
     stsz := mp4File.Boxes["moov.trak.mdia.minf.stbl.stsz"][0].(mp4.StszBox)
-    mp4a := mp4File.Boxes["moov.trak.mdia.minf.stbl.stsd.mp4a"][0].(mp4.Mp4aBox)
+    // Pre-encrypted files have an enca sample entry, their original format is in the frma box
+    var entryPath string
+    for _, entry := range []string{ "mp4a", "ac-3", "ec-3", "Opus", "fLaC", "enca" } {
+      if mp4File.Boxes["moov.trak.mdia.minf.stbl.stsd." + entry] != nil {
+        entryPath = "moov.trak.mdia.minf.stbl.stsd." + entry
+        break
+      }
+    }
+    if entryPath == "" {
+      fmt.Printf("Cannot package file '%s': unsupported sample entry, the audio track is skipped\n", mp4File.Filename)
+      continue
+    }
+    codec := path.Ext(entryPath)[1:]
+    if codec == "enca" && mp4File.Boxes[entryPath + ".sinf.frma"] != nil {
+      frma := mp4File.Boxes[entryPath + ".sinf.frma"][0].(mp4.FrmaBox)
+      codec = string(frma.DataFormat[:])
+    }
+    mp4a := mp4File.Boxes[entryPath][0].(mp4.Mp4aBox)
     elst := mp4File.Boxes["moov.trak.edts.elst"][0].(mp4.ElstBox)
     var t mp4.TrackEntry
-    t.Bandwidth = uint64(float64(mdat.Size) / (float64(mdhd.Duration) / float64(mdhd.Timescale)) * 8)
+    t.Bandwidth = uint64(float64(trackDataSize(mp4File)) / (float64(mdhd.Duration) / float64(mdhd.Timescale)) * 8)
     t.Name = "audio_" + mp4File.Language
     t.File = mp4File.Filename
     t.Lang = mp4File.Language
+    t.TrackId = mp4File.TrackId
+    for _, in := range mp4FileSlice {
+      // The role and label of an input file are the ones of its audio track, not of each track of a file with several
+      if in.Filename == mp4File.Filename && fileTrackCount(mp4Files["audio"], in.Filename) == 1 {
+        // Tracks of the same language with another role are separate streams
+        if in.Role != "" && in.Role != "main" {
+          t.Name += "_" + in.Role
+          t.Role = in.Role
+        }
+        t.Label = in.Label
+      }
+    }
+    if codec != "mp4a" {
+      // Dolby, Opus and FLAC tracks are streams of their own next to the AAC ones of the same language
+      t.Name += "_" + strings.ToLower(strings.Replace(codec, "-", "", 1))
+    }
+    t.Name += trackIdSuffix(mp4File)
     t.Config = new(mp4.DashConfig)
     t.Config.StszBoxOffset = stsz.Offset
-    t.Config.StszBoxSize = stsz.Size
+    t.Config.StszBoxSize = uint64(stsz.Size)
     t.Config.MdatBoxOffset = mdat.Offset
-    t.Config.MdatBoxSize = mdat.Size
+    t.Config.MdatBoxSize = trackDataSize(mp4File)
+    setChunkTables(mp4File, t.Config)
     t.Config.Type = "audio"
     t.Config.Rate = 0x00010000
     t.Config.Volume = 0x0100
@@ -253,15 +587,70 @@ func main() {
     t.Config.Audio.SampleSize = mp4a.SampleSize
     t.Config.Audio.CompressionId = mp4a.CompressionId
     t.Config.Audio.SampleRate = mp4a.SampleRate
+    switch {
+      case codec == "mp4a":
+        if mp4File.Boxes[entryPath + ".esds"] != nil {
+          esds := mp4File.Boxes[entryPath + ".esds"][0].(mp4.EsdsBox)
+          err := mp4.ReadEsdsAudioConfig(esds, t.Config.Audio)
+          if err != nil {
+            fmt.Printf("Cannot read the audio configuration of file '%s': %v\n", mp4File.Filename, err)
+            return
+          }
+        }
+        if oti := t.Config.Audio.ObjectTypeIndication; oti == 0x6B || oti == 0x69 {
+          // MP3 tracks are streams of their own next to the AAC ones of the same language
+          t.Name += "_mp3"
+        }
+        t.Config.RawMp3 = path.Ext(mp4File.Filename) == ".mp3"
+      case codec == "ac-3" && mp4File.Boxes[entryPath + ".dac3"] != nil:
+        dac3 := mp4File.Boxes[entryPath + ".dac3"][0].(mp4.Dac3Box)
+        t.Config.Audio.Codec = codec
+        t.Config.Audio.Dac3 = &dac3
+      case codec == "ec-3" && mp4File.Boxes[entryPath + ".dec3"] != nil:
+        dec3 := mp4File.Boxes[entryPath + ".dec3"][0].(mp4.Dec3Box)
+        t.Config.Audio.Codec = codec
+        t.Config.Audio.Dec3 = &dec3
+      case codec == "Opus" && mp4File.Boxes[entryPath + ".dOps"] != nil:
+        dOps := mp4File.Boxes[entryPath + ".dOps"][0].(mp4.DopsBox)
+        t.Config.Audio.Codec = codec
+        t.Config.Audio.Dops = &dOps
+      case codec == "fLaC" && mp4File.Boxes[entryPath + ".dfLa"] != nil:
+        dfLa := mp4File.Boxes[entryPath + ".dfLa"][0].(mp4.DflaBox)
+        t.Config.Audio.Codec = codec
+        t.Config.Audio.Dfla = &dfLa
+      default:
+        fmt.Printf("Cannot package file '%s': unsupported audio format '%s'\n", mp4File.Filename, codec)
+        return
+    }
+    if path.Ext(entryPath) == ".enca" {
+      protection, err := readProtection(mp4File, entryPath, codec)
+      if err != nil {
+        fmt.Printf("Cannot package pre-encrypted file '%s': %v\n", mp4File.Filename, err)
+        return
+      }
+      t.Config.Protection = protection
+    }
     jConf.Tracks["audio"] = append(jConf.Tracks["audio"], t)
   }
 
+  if *cpixFilename != "" {
+    fmt.Printf("\n-- Importing CPIX document '%s'\n", *cpixFilename)
+    drm, err := mp4.ReadCpixFile(*cpixFilename)
+    if err != nil {
+      fmt.Printf("Cannot import CPIX document '%s': %v\n", *cpixFilename, err)
+      return
+    }
+    fmt.Printf("   %d content keys, %d DRM systems, %d key periods\n", len(drm.Keys), len(drm.Systems), len(drm.KeyPeriods))
+    jConf.Drm = drm
+  }
+
   for _, vttFile := range vttFileSlice {
     var t mp4.TrackEntry
     t.Bandwidth = 256
     t.Name = "caption_" + vttFile.Language
     t.File = vttFile.Filename
     t.Lang = vttFile.Language
+    t.Label = vttFile.Label
     jConf.Tracks["subtitle"] = append(jConf.Tracks["subtitle"], t)
   }
 
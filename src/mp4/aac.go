@@ -0,0 +1,331 @@
+// Copyright (c) 2015
+//      Sebastien Petit & Afrostream - www.afrostream.tv - spebsd@gmail.com.
+//      All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+// LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+// OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+// SUCH DAMAGE.
+
+package mp4
+
+import (
+	"errors"
+)
+
+// MPEG-4 descriptor tags of the ESDS Box (ISO/IEC 14496-1)
+const (
+	esDescrTag            = 0x03
+	decoderConfigDescrTag = 0x04
+	decSpecificInfoTag    = 0x05
+)
+
+// MPEG-4 audio object types (ISO/IEC 14496-3)
+const (
+	mpeg4AudioObjectTypeIndication = 0x40
+	aacLcObjectType                = 2
+	sbrObjectType                  = 5  // HE-AAC
+	psObjectType                   = 29 // HE-AACv2
+)
+
+// Number of channels of the AAC channel configurations 1 to 7
+var aacChannelCounts = []uint16{0, 1, 2, 3, 4, 5, 6, 8}
+
+// AAC AudioSpecificConfig fields, objectType is the one of the core codec when SBR is explicitly signalled
+type aacAudioSpecificConfig struct {
+	objectType             byte
+	samplingFrequencyIndex byte
+	channelConfiguration   byte
+	sbr                    bool
+	ps                     bool
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (b *bitReader) remaining() int {
+	return len(b.data)*8 - b.pos
+}
+
+func (b *bitReader) read(n int) (v uint32, err error) {
+	if n > b.remaining() {
+		err = errors.New("unexpected end of data")
+		return
+	}
+	for i := 0; i < n; i++ {
+		v = (v << 1) | uint32((b.data[b.pos/8]>>(7-uint(b.pos%8)))&0x01)
+		b.pos++
+	}
+
+	return
+}
+
+// ***
+// *** Public functions
+// ***
+
+// Read the audio configuration of an ESDS Box: object type indication, AAC AudioSpecificConfig and
+// the object type, SBR / PS and channel configuration fields needed by the codecs string and the manifests
+func ReadEsdsAudioConfig(esds EsdsBox, audio *DashAudioEntry) (err error) {
+	objectTypeIndication, decoderSpecificInfo, err := readEsdsDecoderConfig(esds)
+	if err != nil {
+		return
+	}
+	audio.ObjectTypeIndication = objectTypeIndication
+	if objectTypeIndication != mpeg4AudioObjectTypeIndication {
+		return
+	}
+	asc, err := parseAacAudioSpecificConfig(decoderSpecificInfo)
+	if err != nil {
+		return
+	}
+	audio.AudioSpecificConfig = decoderSpecificInfo
+	audio.ObjectType = asc.objectType
+	audio.Sbr = asc.sbr
+	audio.Ps = asc.ps
+	audio.ChannelConfiguration = asc.channelConfiguration
+
+	return
+}
+
+// Number of channels output by the decoder of an audio track (eg: 6 for 5.1, 2 for HE-AACv2 mono + PS)
+func AudioChannelCount(audio DashAudioEntry) uint16 {
+	if configuration := DolbyChannelConfiguration(audio); configuration != 0 {
+		return dolbyChannelCount(configuration)
+	}
+	if audio.Dops != nil {
+		return uint16(audio.Dops.OutputChannelCount)
+	}
+	if audio.Dfla != nil && audio.Dfla.Channels() > 0 {
+		return audio.Dfla.Channels()
+	}
+	if audio.Ps {
+		return 2
+	}
+	if audio.ChannelConfiguration > 0 && int(audio.ChannelConfiguration) < len(aacChannelCounts) {
+		return aacChannelCounts[audio.ChannelConfiguration]
+	}
+
+	return audio.NumberOfChannels
+}
+
+// ***
+// *** Private functions
+// ***
+
+// Read the tag and the payload of an MPEG-4 descriptor, its size is coded on 1 to 4 bytes of 7 bits
+func readDescriptor(data []byte) (tag byte, payload []byte, err error) {
+	if len(data) < 2 {
+		err = errors.New("truncated descriptor")
+		return
+	}
+	tag = data[0]
+	size := 0
+	i := 1
+	for ; i < len(data) && i <= 4; i++ {
+		size = (size << 7) | int(data[i]&0x7F)
+		if data[i]&0x80 == 0 {
+			break
+		}
+	}
+	i++
+	if i+size > len(data) {
+		err = errors.New("truncated descriptor")
+		return
+	}
+	payload = data[i : i+size]
+
+	return
+}
+
+// Read the object type indication and the decoder specific info of the ES_Descriptor of an ESDS Box
+func readEsdsDecoderConfig(esds EsdsBox) (objectTypeIndication byte, decoderSpecificInfo []byte, err error) {
+	tag, es, err := readDescriptor(esds.Data)
+	if err != nil {
+		return
+	}
+	if tag != esDescrTag || len(es) < 3 {
+		err = errors.New("no ES_Descriptor in esds box")
+		return
+	}
+	// ES_ID, then optional dependsOn_ES_ID, URL and OCR_ES_Id fields
+	flags := es[2]
+	pos := 3
+	if flags&0x80 != 0 {
+		pos += 2
+	}
+	if flags&0x40 != 0 && pos < len(es) {
+		pos += 1 + int(es[pos])
+	}
+	if flags&0x20 != 0 {
+		pos += 2
+	}
+	if pos >= len(es) {
+		err = errors.New("truncated ES_Descriptor in esds box")
+		return
+	}
+	tag, dc, err := readDescriptor(es[pos:])
+	if err != nil {
+		return
+	}
+	if tag != decoderConfigDescrTag || len(dc) < 13 {
+		err = errors.New("no DecoderConfigDescriptor in esds box")
+		return
+	}
+	objectTypeIndication = dc[0]
+	if len(dc) > 13 {
+		tag, dsi, e := readDescriptor(dc[13:])
+		if e == nil && tag == decSpecificInfoTag {
+			decoderSpecificInfo = dsi
+		}
+	}
+
+	return
+}
+
+func readAudioObjectType(b *bitReader) (objectType byte, err error) {
+	v, err := b.read(5)
+	if err != nil {
+		return
+	}
+	if v == 31 {
+		var ext uint32
+		ext, err = b.read(6)
+		v = 32 + ext
+	}
+	objectType = byte(v)
+
+	return
+}
+
+func readSamplingFrequencyIndex(b *bitReader) (samplingFrequencyIndex byte, err error) {
+	v, err := b.read(4)
+	if err != nil {
+		return
+	}
+	if v == 0x0F {
+		// Explicit sampling frequency
+		_, err = b.read(24)
+	}
+	samplingFrequencyIndex = byte(v)
+
+	return
+}
+
+// Parse an AAC AudioSpecificConfig (ISO/IEC 14496-3 1.6.2.1), SBR and PS are signalled explicitly by
+// the HE-AAC / HE-AACv2 object types or implicitly by the sync extensions following the GASpecificConfig
+func parseAacAudioSpecificConfig(config []byte) (asc aacAudioSpecificConfig, err error) {
+	b := &bitReader{data: config}
+	if asc.objectType, err = readAudioObjectType(b); err != nil {
+		return
+	}
+	if asc.samplingFrequencyIndex, err = readSamplingFrequencyIndex(b); err != nil {
+		return
+	}
+	var v uint32
+	if v, err = b.read(4); err != nil {
+		return
+	}
+	asc.channelConfiguration = byte(v)
+	if asc.objectType == sbrObjectType || asc.objectType == psObjectType {
+		asc.sbr = true
+		asc.ps = asc.objectType == psObjectType
+		if _, err = readSamplingFrequencyIndex(b); err != nil {
+			return
+		}
+		asc.objectType, err = readAudioObjectType(b)
+		return
+	}
+
+	// GASpecificConfig of the AAC object types, a program config element cannot be skipped easily
+	if asc.objectType < 1 || asc.objectType > 7 || asc.channelConfiguration == 0 {
+		return
+	}
+	b.read(1) // frameLengthFlag
+	dependsOnCoreCoder, _ := b.read(1)
+	if dependsOnCoreCoder == 1 {
+		b.read(14)
+	}
+	b.read(1) // extensionFlag
+	if asc.objectType == 6 {
+		b.read(3) // layerNr
+	}
+	if b.remaining() >= 16 {
+		syncExtensionType, _ := b.read(11)
+		if syncExtensionType != 0x2B7 {
+			return
+		}
+		extensionObjectType, e := readAudioObjectType(b)
+		if e != nil || extensionObjectType != sbrObjectType {
+			return
+		}
+		sbrPresent, _ := b.read(1)
+		if sbrPresent != 1 {
+			return
+		}
+		asc.sbr = true
+		readSamplingFrequencyIndex(b)
+		if b.remaining() >= 12 {
+			syncExtensionType, _ = b.read(11)
+			if syncExtensionType == 0x548 {
+				psPresent, _ := b.read(1)
+				asc.ps = psPresent == 1
+			}
+		}
+	}
+
+	return
+}
+
+// Create the ESDS Box of an audio track from its AudioSpecificConfig, AAC tracks packaged without it get
+// the one of a 48 kHz stereo AAC LC stream and MPEG-1/2 audio (MP3) tracks have no DecoderSpecificInfo
+func createEsdsBox(audio *DashAudioEntry) (esds EsdsBox) {
+	if audio == nil || (len(audio.AudioSpecificConfig) == 0 && (audio.ObjectTypeIndication == 0 || audio.ObjectTypeIndication == mpeg4AudioObjectTypeIndication)) {
+		esds.Data = []byte{0x03, 0x19, 0x00, 0x01, 0x00, 0x04, 0x11, 0x40, 0x15, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xF3, 0xC2, 0x05, 0x02, 0x11, 0x90, 0x06, 0x01, 0x02}
+		esds.Size = 31
+		return
+	}
+	objectTypeIndication := audio.ObjectTypeIndication
+	if objectTypeIndication == 0 {
+		objectTypeIndication = mpeg4AudioObjectTypeIndication
+	}
+	dsi := audio.AudioSpecificConfig
+	// DecoderConfigDescriptor: object type, stream type (audio), buffer size, max and average bitrates
+	dc := []byte{decoderConfigDescrTag, 13, objectTypeIndication, 0x15, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xF3, 0xC2}
+	if len(dsi) > 0 {
+		dc[1] += byte(2 + len(dsi))
+		dc = append(dc, decSpecificInfoTag, byte(len(dsi)))
+		dc = append(dc, dsi...)
+	}
+	// ES_Descriptor with ES_ID 1, followed by the SLConfigDescriptor
+	esds.Data = []byte{esDescrTag, byte(3 + len(dc) + 3), 0x00, 0x01, 0x00}
+	esds.Data = append(esds.Data, dc...)
+	esds.Data = append(esds.Data, 0x06, 0x01, 0x02)
+	esds.Size = uint32(4 + len(esds.Data))
+
+	return
+}
@@ -0,0 +1,545 @@
+// Copyright (c) 2015
+//      Sebastien Petit & Afrostream - www.afrostream.tv - spebsd@gmail.com.
+//      All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+// LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+// OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+// SUCH DAMAGE.
+
+package mp4
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type DashEncryption struct {
+	Scheme     string    // Protection scheme, "cenc" (AES-CTR) or "cbcs" (AES-CBC pattern encryption)
+	KeyId      [16]byte  // Default KID of the track
+	Key        [16]byte  // Content key
+	Iv         uint64    // cenc: initialization vector of the first sample, the sample index is added to it for the following ones
+	ConstantIv [16]byte  // cbcs: initialization vector of all samples
+	Pssh       []PsshBox // PSSH Boxes of DRM systems added to init segments
+	// Key rotation: each key period is used for the fragments starting within its bounds, KeyId and Key are the ones of
+	// the first period
+	KeyPeriods []DashKeyPeriod
+}
+
+type DashKeyPeriod struct {
+	KeyId [16]byte
+	Key   [16]byte
+	Pssh  []PsshBox // PSSH Boxes added to the fragments of the period
+	Start uint64    // Media time of the beginning of the period in milliseconds
+	End   uint64    // Media time of the end of the period in milliseconds (excluded)
+}
+
+// Key period of a fragment starting at time (in timescale unit), rotation is false without key rotation and
+// the period is then the one of the track key. An error is returned when no key period covers the fragment.
+func (encryption DashEncryption) KeyPeriodAt(time uint64, timescale uint32) (period DashKeyPeriod, rotation bool, err error) {
+	if len(encryption.KeyPeriods) == 0 {
+		period.KeyId = encryption.KeyId
+		period.Key = encryption.Key
+		period.Pssh = encryption.Pssh
+		return
+	}
+	if timescale == 0 {
+		err = errors.New("no timescale to find the key period")
+		return
+	}
+	ms := time * 1000 / uint64(timescale)
+	for _, p := range encryption.KeyPeriods {
+		if ms >= p.Start && ms < p.End {
+			period = p
+			rotation = true
+			return
+		}
+	}
+	err = errors.New("no key period at " + strconv.FormatUint(ms, 10) + " ms")
+
+	return
+}
+
+// Default encryption parameters of a track, with the key id of its first key period
+func createTencBox(dConf DashConfig) (tenc TencBox) {
+	tenc.Version = 0
+	tenc.DefaultIsProtected = 1
+	tenc.DefaultPerSampleIvSize = 8
+	tenc.DefaultKid = dConf.Encryption.KeyId
+	tenc.Size = 24
+	if dConf.Encryption.Scheme == "cbcs" {
+		tenc.Version = 1
+		if dConf.Type == "video" {
+			tenc.DefaultCryptByteBlock = cbcsCryptByteBlock
+			tenc.DefaultSkipByteBlock = cbcsSkipByteBlock
+		}
+		tenc.DefaultPerSampleIvSize = 0
+		tenc.DefaultConstantIvSize = 16
+		tenc.DefaultConstantIv = dConf.Encryption.ConstantIv[:]
+		tenc.Size += 1 + 16
+	}
+
+	return
+}
+
+// Number of encrypted and clear 16 bytes blocks of the cbcs pattern, video samples only (audio samples are fully encrypted)
+const (
+	cbcsCryptByteBlock = 1
+	cbcsSkipByteBlock  = 9
+)
+
+// Bytes of AVC slices left in clear before the encrypted part, covering the NAL unit header and slice header
+const (
+	cencClearLeaderSize = 1
+	cbcsClearLeaderSize = 32
+)
+
+// Add a size to boxes which contain an added box
+func growBoxes(mp4 map[string][]interface{}, boxPaths []string, size uint32) {
+	for _, boxPath := range boxPaths {
+		if mp4[boxPath] == nil {
+			continue
+		}
+		switch box := mp4[boxPath][0].(type) {
+		case ParentBox:
+			box.Size += size
+			replaceBox(mp4, boxPath, box)
+		case StsdBox:
+			box.Size += size
+			replaceBox(mp4, boxPath, box)
+		case Avc1Box:
+			box.Size += size
+			replaceBox(mp4, boxPath, box)
+		case Hvc1Box:
+			box.Size += size
+			replaceBox(mp4, boxPath, box)
+		case Mp4aBox:
+			box.Size += size
+			replaceBox(mp4, boxPath, box)
+		}
+	}
+}
+
+// Rename the sample entry of an init segment (avc1 / hvc1 / hev1 -> encv, mp4a / ac-3 / ec-3 / Opus / fLaC -> enca) and add the SINF Box describing the encryption
+func encryptDashInit(dConf DashConfig, mp4Init map[string][]interface{}) {
+	addDashInitProtection(dConf, mp4Init, dConf.Encryption.Scheme, 0x00010000, createTencBox(dConf), dConf.Encryption.Pssh)
+}
+
+// Rename the sample entry of an init segment and add the SINF Box of a protection scheme and the PSSH Boxes
+func addDashInitProtection(dConf DashConfig, mp4Init map[string][]interface{}, scheme string, schemeVersion uint32, tenc TencBox, psshBoxes []PsshBox) {
+	var entry, encryptedEntry string
+	if dConf.Type == "video" {
+		entry = videoSampleEntry(*dConf.Video)
+		encryptedEntry = "encv"
+	} else {
+		entry = audioSampleEntry(*dConf.Audio)
+		encryptedEntry = "enca"
+	}
+	stsdPath := "moov.trak.mdia.minf.stbl.stsd"
+	entryPath := stsdPath + "." + entry
+	encryptedPath := stsdPath + "." + encryptedEntry
+	for boxPath, boxes := range mp4Init {
+		if boxPath == entryPath || strings.HasPrefix(boxPath, entryPath+".") {
+			delete(mp4Init, boxPath)
+			mp4Init[encryptedPath+strings.TrimPrefix(boxPath, entryPath)] = boxes
+		}
+	}
+
+	// FRMA
+	var frma FrmaBox
+	copy(frma.DataFormat[:], []byte(entry))
+	frma.Size = 4
+	replaceBox(mp4Init, encryptedPath+".sinf.frma", frma)
+
+	// SCHM
+	var schm SchmBox
+	schm.Version = 0
+	schm.Flags = [3]byte{0, 0, 0}
+	copy(schm.SchemeType[:], []byte(scheme))
+	schm.SchemeVersion = schemeVersion
+	schm.Size = 12
+	replaceBox(mp4Init, encryptedPath+".sinf.schm", schm)
+
+	// SCHI/TENC
+	replaceBox(mp4Init, encryptedPath+".sinf.schi.tenc", tenc)
+	var schi ParentBox
+	schi.Name = [4]byte{'s', 'c', 'h', 'i'}
+	schi.Size = tenc.Size + 8
+	replaceBox(mp4Init, encryptedPath+".sinf.schi", schi)
+
+	// SINF
+	var sinf ParentBox
+	sinf.Name = [4]byte{'s', 'i', 'n', 'f'}
+	sinf.Size = frma.Size + 8 + schm.Size + 8 + schi.Size + 8
+	replaceBox(mp4Init, encryptedPath+".sinf", sinf)
+
+	growBoxes(mp4Init, []string{encryptedPath, stsdPath, "moov.trak.mdia.minf.stbl", "moov.trak.mdia.minf", "moov.trak.mdia", "moov.trak", "moov"}, sinf.Size+8)
+
+	// PSSH
+	for _, pssh := range psshBoxes {
+		addBox(mp4Init, "moov.pssh", pssh)
+		growBoxes(mp4Init, []string{"moov"}, pssh.Size+8)
+	}
+}
+
+// Subsamples of an AVC or HEVC sample: NAL unit lengths and the first clearLeaderSize bytes of slices are left
+// in clear, as non VCL NAL units, the protected part of each slice is a multiple of the AES block size
+func videoSubsamples(sample []byte, nalLengthSize int, hevc bool, clearLeaderSize int) (subsamples []SencSubsample) {
+	var clear int
+	addClear := func() {
+		for clear > 0xFFFF {
+			subsamples = append(subsamples, SencSubsample{BytesOfClearData: 0xFFFF, BytesOfProtectedData: 0})
+			clear -= 0xFFFF
+		}
+	}
+	offset := 0
+	for offset+nalLengthSize < len(sample) {
+		var nalSize int
+		for i := 0; i < nalLengthSize; i++ {
+			nalSize = (nalSize << 8) | int(sample[offset+i])
+		}
+		nalEnd := offset + nalLengthSize + nalSize
+		if nalEnd > len(sample) {
+			break
+		}
+		nalHeader := sample[offset+nalLengthSize]
+		slice := nalHeader&0x1F >= 1 && nalHeader&0x1F <= 5
+		if hevc {
+			// VCL NAL unit types 0 to 31
+			slice = (nalHeader>>1)&0x3F < 32
+		}
+		protected := 0
+		if slice && nalSize > clearLeaderSize {
+			protected = (nalSize - clearLeaderSize) &^ (aes.BlockSize - 1)
+		}
+		clear += nalEnd - offset - protected
+		if protected > 0 {
+			addClear()
+			subsamples = append(subsamples, SencSubsample{BytesOfClearData: uint16(clear), BytesOfProtectedData: uint32(protected)})
+			clear = 0
+		}
+		offset = nalEnd
+	}
+	clear += len(sample) - offset
+	if clear > 0 {
+		addClear()
+		subsamples = append(subsamples, SencSubsample{BytesOfClearData: uint16(clear), BytesOfProtectedData: 0})
+	}
+
+	return
+}
+
+// AES-CBC encryption of the cbcs scheme restarting with the constant IV for each subsample: each pattern encrypts
+// cryptByteBlock blocks and leaves skipByteBlock blocks in clear (every block if both are 0), a partial last block
+// is left in clear
+func encryptCbcs(block cipher.Block, iv []byte, data []byte, cryptByteBlock int, skipByteBlock int) {
+	mode := cipher.NewCBCEncrypter(block, iv)
+	if cryptByteBlock == 0 && skipByteBlock == 0 {
+		size := len(data) &^ (aes.BlockSize - 1)
+		mode.CryptBlocks(data[:size], data[:size])
+		return
+	}
+	for offset := 0; offset+aes.BlockSize <= len(data); offset += (cryptByteBlock + skipByteBlock) * aes.BlockSize {
+		size := cryptByteBlock * aes.BlockSize
+		if offset+size > len(data) {
+			size = (len(data) - offset) &^ (aes.BlockSize - 1)
+		}
+		mode.CryptBlocks(data[offset:offset+size], data[offset:offset+size])
+	}
+}
+
+// Bytes of the NAL unit lengths of the samples of a video track
+func videoNalLengthSize(dConf DashConfig) int {
+	return int(dConf.Video.NalUnitSize&0x03) + 1
+}
+
+// Bytes of video slices left in clear by the encryption scheme of a track
+func videoClearLeaderSize(dConf DashConfig) (clearLeaderSize int) {
+	clearLeaderSize = cencClearLeaderSize
+	if dConf.Encryption.Scheme == "cbcs" {
+		clearLeaderSize = cbcsClearLeaderSize
+	}
+	// HEVC NAL unit headers are 2 bytes long
+	if dConf.Video.HvcC != nil && clearLeaderSize < 2 {
+		clearLeaderSize = 2
+	}
+
+	return
+}
+
+// Encrypt the samples of a fragment and add SENC, SAIZ and SAIO Boxes to its TRAF Box, with key rotation the SBGP
+// and SGPD Boxes of the key period and its PSSH Boxes are added too
+// sampleStart is the index of the first sample of the fragment in the track
+func encryptDashFragment(dConf DashConfig, fmp4 map[string][]interface{}, sampleStart uint32) (err error) {
+	tfdt := fmp4["moof.traf.tfdt"][0].(TfdtBox)
+	period, rotation, err := dConf.Encryption.KeyPeriodAt(tfdt.BaseMediaDecodeTime, dConf.Timescale)
+	if err != nil {
+		return
+	}
+	block, err := aes.NewCipher(period.Key[:])
+	if err != nil {
+		return
+	}
+	trun := fmp4["moof.traf.trun"][0].(TrunBox)
+	mdat := fmp4["mdat"][0].(MdatBox)
+	data := mdat.Bytes()[8:]
+
+	var senc SencBox
+	senc.Version = 0
+	if dConf.Type == "video" {
+		senc.Flags = [3]byte{0x00, 0x00, 0x02}
+	}
+	senc.SampleCount = trun.SampleCount
+	senc.Samples = make([]SencSample, trun.SampleCount)
+	offset := 0
+	for i, s := range trun.Samples {
+		sample := data[offset : offset+int(s.Size)]
+		offset += int(s.Size)
+		// Ranges of the sample to encrypt, the whole sample without subsamples
+		var subsamples []SencSubsample
+		if dConf.Type == "video" {
+			subsamples = videoSubsamples(sample, videoNalLengthSize(dConf), dConf.Video.HvcC != nil, videoClearLeaderSize(dConf))
+			senc.Samples[i].Subsamples = subsamples
+		} else {
+			subsamples = []SencSubsample{{BytesOfClearData: 0, BytesOfProtectedData: uint32(len(sample))}}
+		}
+		if dConf.Encryption.Scheme == "cbcs" {
+			position := 0
+			for _, subsample := range subsamples {
+				position += int(subsample.BytesOfClearData)
+				protected := sample[position : position+int(subsample.BytesOfProtectedData)]
+				if dConf.Type == "video" {
+					encryptCbcs(block, dConf.Encryption.ConstantIv[:], protected, cbcsCryptByteBlock, cbcsSkipByteBlock)
+				} else {
+					encryptCbcs(block, dConf.Encryption.ConstantIv[:], protected, 0, 0)
+				}
+				position += int(subsample.BytesOfProtectedData)
+			}
+		} else {
+			senc.Samples[i].Iv = make([]byte, 8)
+			binary.BigEndian.PutUint64(senc.Samples[i].Iv, dConf.Encryption.Iv+uint64(sampleStart)+uint64(i))
+			counter := make([]byte, aes.BlockSize)
+			copy(counter, senc.Samples[i].Iv)
+			stream := cipher.NewCTR(block, counter)
+			position := 0
+			for _, subsample := range subsamples {
+				position += int(subsample.BytesOfClearData)
+				protected := sample[position : position+int(subsample.BytesOfProtectedData)]
+				stream.XORKeyStream(protected, protected)
+				position += int(subsample.BytesOfProtectedData)
+			}
+		}
+	}
+	mdat.Data = data
+	replaceBox(fmp4, "mdat", mdat)
+
+	addEncryptionBoxes(dConf, fmp4, senc, period, rotation)
+
+	return
+}
+
+// Add the boxes of a fragment encrypted by encryptDashFragment without reading nor encrypting its samples, so that
+// its size is known (eg: to index the fragments of an on-demand file): the IVs are left to zero and only the NAL unit
+// lengths and headers of video samples are read from f to know their subsamples
+// sampleStart is the index of the first sample of the fragment in the track
+func layoutEncryptedDashFragment(dConf DashConfig, f *os.File, fmp4 map[string][]interface{}, sampleStart uint32) (err error) {
+	tfdt := fmp4["moof.traf.tfdt"][0].(TfdtBox)
+	period, rotation, err := dConf.Encryption.KeyPeriodAt(tfdt.BaseMediaDecodeTime, dConf.Timescale)
+	if err != nil {
+		return
+	}
+	trun := fmp4["moof.traf.trun"][0].(TrunBox)
+	mdat := fmp4["mdat"][0].(MdatBox)
+
+	var senc SencBox
+	senc.Version = 0
+	if dConf.Type == "video" {
+		senc.Flags = [3]byte{0x00, 0x00, 0x02}
+	}
+	senc.SampleCount = trun.SampleCount
+	senc.Samples = make([]SencSample, trun.SampleCount)
+	// Runs of contiguous samples in the file
+	runs := mdat.Chunks
+	if runs == nil {
+		runs = []MdatChunk{{Offset: mdat.Offset, Size: uint32(mdat.Size)}}
+	}
+	run := 0
+	var runOffset int64
+	var sample []byte
+	for i, s := range trun.Samples {
+		if dConf.Encryption.Scheme != "cbcs" {
+			senc.Samples[i].Iv = make([]byte, 8)
+		}
+		if dConf.Type == "video" {
+			for run < len(runs) && runOffset >= int64(runs[run].Size) {
+				run++
+				runOffset = 0
+			}
+			if run == len(runs) {
+				return errors.New("samples out of the fragment")
+			}
+			// Sample with its NAL unit lengths and headers only
+			if cap(sample) < int(s.Size) {
+				sample = make([]byte, s.Size)
+			}
+			sample = sample[:s.Size]
+			nalLengthSize := videoNalLengthSize(dConf)
+			for position := 0; position+nalLengthSize < len(sample); {
+				header := sample[position : position+nalLengthSize+1]
+				_, err = f.ReadAt(header, runs[run].Offset+runOffset+int64(position))
+				if err != nil {
+					return
+				}
+				var nalSize int
+				for _, b := range header[:nalLengthSize] {
+					nalSize = (nalSize << 8) | int(b)
+				}
+				position += nalLengthSize + nalSize
+			}
+			senc.Samples[i].Subsamples = videoSubsamples(sample, nalLengthSize, dConf.Video.HvcC != nil, videoClearLeaderSize(dConf))
+			runOffset += int64(s.Size)
+		}
+	}
+
+	addEncryptionBoxes(dConf, fmp4, senc, period, rotation)
+
+	return
+}
+
+// Add the SENC, SAIZ and SAIO Boxes of the encrypted samples of a fragment to its TRAF Box, with key rotation the
+// SBGP and SGPD Boxes of the key period and its PSSH Boxes too
+func addEncryptionBoxes(dConf DashConfig, fmp4 map[string][]interface{}, senc SencBox, period DashKeyPeriod, rotation bool) {
+	trafSize := addSencBox(fmp4, senc)
+	var moofSize uint32
+
+	// Key rotation: the key of the samples is given by a sample group and the PSSH Boxes of the period are in MOOF Box
+	if rotation {
+		tenc := createTencBox(dConf)
+		var seig SeigEntry
+		seig.CryptByteBlock = tenc.DefaultCryptByteBlock
+		seig.SkipByteBlock = tenc.DefaultSkipByteBlock
+		seig.IsProtected = tenc.DefaultIsProtected
+		seig.PerSampleIvSize = tenc.DefaultPerSampleIvSize
+		seig.Kid = period.KeyId
+		seig.ConstantIvSize = tenc.DefaultConstantIvSize
+		seig.ConstantIv = tenc.DefaultConstantIv
+		entry := seig.Bytes()
+
+		var sbgp SbgpBox
+		sbgp.Version = 0
+		sbgp.GroupingType = [4]byte{'s', 'e', 'i', 'g'}
+		sbgp.EntryCount = 1
+		sbgp.Entries = []SbgpEntry{{SampleCount: senc.SampleCount, GroupDescriptionIndex: 0x10001}}
+		sbgp.Size = 12 + 8*sbgp.EntryCount
+		replaceBox(fmp4, "moof.traf.sbgp", sbgp)
+
+		var sgpd SgpdBox
+		sgpd.Version = 1
+		sgpd.GroupingType = [4]byte{'s', 'e', 'i', 'g'}
+		sgpd.DefaultLength = uint32(len(entry))
+		sgpd.EntryCount = 1
+		sgpd.Entries = [][]byte{entry}
+		sgpd.Size = 16 + uint32(len(entry))
+		replaceBox(fmp4, "moof.traf.sgpd", sgpd)
+
+		trafSize += sbgp.Size + 8 + sgpd.Size + 8
+		for _, pssh := range period.Pssh {
+			addBox(fmp4, "moof.pssh", pssh)
+			moofSize += pssh.Size + 8
+		}
+	}
+
+	growDashFragment(fmp4, trafSize, moofSize)
+}
+
+// Add a SENC Box with its SAIZ and SAIO Boxes to the TRAF Box of a fragment, nothing is added without sample
+// auxiliary information (constant IV without subsamples)
+// The size of the added boxes is returned, the sizes of TRAF and MOOF Boxes are not updated (see growDashFragment)
+func addSencBox(fmp4 map[string][]interface{}, senc SencBox) (size uint32) {
+	var saiz SaizBox
+	saiz.SampleCount = senc.SampleCount
+	saiz.SampleInfoSize = make([]byte, senc.SampleCount)
+	sameInfoSize := true
+	senc.Size = 8
+	for i, sample := range senc.Samples {
+		infoSize := len(sample.Iv)
+		if senc.Flags[2]&0x02 != 0 {
+			infoSize += 2 + 6*len(sample.Subsamples)
+		}
+		senc.Size += uint32(infoSize)
+		saiz.SampleInfoSize[i] = byte(infoSize)
+		if saiz.SampleInfoSize[i] != saiz.SampleInfoSize[0] {
+			sameInfoSize = false
+		}
+	}
+	if senc.Size == 8 {
+		return
+	}
+	replaceBox(fmp4, "moof.traf.senc", senc)
+
+	// SAIZ
+	if sameInfoSize && senc.SampleCount > 0 {
+		saiz.DefaultSampleInfoSize = saiz.SampleInfoSize[0]
+		saiz.SampleInfoSize = nil
+		saiz.Size = 9
+	} else {
+		saiz.Size = 9 + saiz.SampleCount
+	}
+	replaceBox(fmp4, "moof.traf.saiz", saiz)
+
+	// SAIO, offset of the first sample auxiliary information in SENC Box from the start of MOOF Box
+	mfhd := fmp4["moof.mfhd"][0].(MfhdBox)
+	tfhd := fmp4["moof.traf.tfhd"][0].(TfhdBox)
+	tfdt := fmp4["moof.traf.tfdt"][0].(TfdtBox)
+	trun := fmp4["moof.traf.trun"][0].(TrunBox)
+	var saio SaioBox
+	saio.Version = 0
+	saio.EntryCount = 1
+	saio.Offsets = []uint64{uint64(8 + mfhd.Size + 8 + 8 + tfhd.Size + 8 + tfdt.Size + 8 + trun.Size + 8 + 16)}
+	saio.Size = 12
+	replaceBox(fmp4, "moof.traf.saio", saio)
+
+	size = senc.Size + 8 + saiz.Size + 8 + saio.Size + 8
+
+	return
+}
+
+// Grow the TRAF and MOOF Boxes of a fragment by the size of the boxes added to them, the samples are moved as much
+func growDashFragment(fmp4 map[string][]interface{}, trafSize uint32, moofSize uint32) {
+	traf := fmp4["moof.traf"][0].(ParentBox)
+	traf.Size += trafSize
+	replaceBox(fmp4, "moof.traf", traf)
+	moof := fmp4["moof"][0].(ParentBox)
+	moof.Size += trafSize + moofSize
+	replaceBox(fmp4, "moof", moof)
+	trun := fmp4["moof.traf.trun"][0].(TrunBox)
+	trun.DataOffset += int32(trafSize + moofSize)
+	replaceBox(fmp4, "moof.traf.trun", trun)
+}
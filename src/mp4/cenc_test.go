@@ -0,0 +1,660 @@
+package mp4
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+// NIST SP 800-38A AES-128 key
+var testKey = [16]byte{0x2b, 0x7e, 0x15, 0x16, 0x28, 0xae, 0xd2, 0xa6, 0xab, 0xf7, 0x15, 0x88, 0x09, 0xcf, 0x4f, 0x3c}
+
+func fromHex(t *testing.T, s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// Bytes from start to start+size-1
+func byteRange(start int, size int) (data []byte) {
+	data = make([]byte, size)
+	for i := range data {
+		data[i] = byte(start + i)
+	}
+
+	return
+}
+
+// NAL unit of size bytes starting with header
+func testNal(header []byte, size int) (nal []byte) {
+	nal = byteRange(0x80, size)
+	copy(nal, header)
+
+	return
+}
+
+// Sample of NAL units prefixed with their length on nalLengthSize bytes
+func testNalSample(nalLengthSize int, nals ...[]byte) (sample []byte) {
+	for _, nal := range nals {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(nal)))
+		sample = append(sample, length[4-nalLengthSize:]...)
+		sample = append(sample, nal...)
+	}
+
+	return
+}
+
+// Fragment of a track with samples, as created by CreateDashFragment without STYP and FREE Boxes
+func testFragment(samples [][]byte) (fmp4 map[string][]interface{}) {
+	fmp4 = make(map[string][]interface{})
+
+	var mfhd MfhdBox
+	mfhd.SequenceNumber = 1
+	mfhd.Size = 8
+	replaceBox(fmp4, "moof.mfhd", mfhd)
+
+	var tfhd TfhdBox
+	tfhd.Flags = [3]byte{0x02, 0x00, 0x08}
+	tfhd.TrackID = 1
+	tfhd.DefaultSampleDuration = 1024
+	tfhd.Size = 12
+	replaceBox(fmp4, "moof.traf.tfhd", tfhd)
+
+	var tfdt TfdtBox
+	tfdt.Version = 1
+	tfdt.BaseMediaDecodeTime = 5 * 1024
+	tfdt.Size = 12
+	replaceBox(fmp4, "moof.traf.tfdt", tfdt)
+
+	var trun TrunBox
+	trun.Flags = [3]byte{0x00, 0x02, 0x01}
+	trun.SampleCount = uint32(len(samples))
+	trun.Size = 12
+	var mdat MdatBox
+	for _, sample := range samples {
+		trun.Samples = append(trun.Samples, TrunBoxSample{Size: uint32(len(sample))})
+		trun.Size += 4
+		mdat.Data = append(mdat.Data, sample...)
+	}
+	mdat.Size = uint64(len(mdat.Data))
+
+	traf := ParentBox{Name: [4]byte{'t', 'r', 'a', 'f'}}
+	traf.Size = tfhd.Size + 8 + tfdt.Size + 8 + trun.Size + 8
+	moof := ParentBox{Name: [4]byte{'m', 'o', 'o', 'f'}}
+	moof.Size = mfhd.Size + 8 + traf.Size + 8
+	trun.DataOffset = int32(moof.Size + 8 + 8)
+	replaceBox(fmp4, "moof.traf.trun", trun)
+	replaceBox(fmp4, "moof.traf", traf)
+	replaceBox(fmp4, "moof", moof)
+	replaceBox(fmp4, "mdat", mdat)
+
+	return
+}
+
+// Check that the boxes of an encrypted fragment point at their data: the SAIO offset at the IV or the subsamples of
+// the first sample in SENC Box and the TRUN data offset at the samples in MDAT Box
+func checkFragmentOffsets(t *testing.T, name string, fmp4 map[string][]interface{}, samples []byte) {
+	data := MapToBytes(fmp4)
+	moof := fmp4["moof"][0].(ParentBox)
+	if len(data) != int(moof.Size+8)+8+len(samples) {
+		t.Errorf("%s: fragment of %d bytes, MOOF Box of %d bytes and %d bytes of samples", name, len(data), moof.Size+8, len(samples))
+		return
+	}
+	trun := fmp4["moof.traf.trun"][0].(TrunBox)
+	if !bytes.Equal(data[trun.DataOffset:], samples) {
+		t.Errorf("%s: TRUN data offset %d does not point at the samples", name, trun.DataOffset)
+	}
+	senc := fmp4["moof.traf.senc"][0].(SencBox)
+	info := senc.Bytes()[16:]
+	saio := fmp4["moof.traf.saio"][0].(SaioBox)
+	if len(saio.Offsets) != 1 || saio.Offsets[0]+uint64(len(info)) > uint64(len(data)) {
+		t.Errorf("%s: SAIO offsets %v out of the fragment", name, saio.Offsets)
+		return
+	}
+	if !bytes.Equal(data[saio.Offsets[0]:saio.Offsets[0]+uint64(len(info))], info) {
+		t.Errorf("%s: SAIO offset %d does not point at the SENC sample auxiliary information", name, saio.Offsets[0])
+	}
+}
+
+func TestVideoSubsamples(t *testing.T) {
+	sps := testNal([]byte{0x67}, 10)
+	idr := testNal([]byte{0x65}, 100)
+	slice := testNal([]byte{0x41}, 33)
+	vps := testNal([]byte{0x40, 0x01}, 20)
+	trail := testNal([]byte{0x02, 0x01}, 50)
+	tests := []struct {
+		name            string
+		sample          []byte
+		nalLengthSize   int
+		hevc            bool
+		clearLeaderSize int
+		subsamples      []SencSubsample
+	}{
+		{"cenc AVC", testNalSample(4, sps, idr), 4, false, cencClearLeaderSize, []SencSubsample{{22, 96}}},
+		{"cbcs AVC", testNalSample(4, sps, idr), 4, false, cbcsClearLeaderSize, []SencSubsample{{54, 64}}},
+		{"two slices", testNalSample(4, idr, slice), 4, false, cencClearLeaderSize, []SencSubsample{{8, 96}, {5, 32}}},
+		{"2 bytes NAL unit lengths", testNalSample(2, sps, slice), 2, false, cencClearLeaderSize, []SencSubsample{{15, 32}}},
+		{"slice shorter than a block", testNalSample(4, testNal([]byte{0x41}, 10)), 4, false, cencClearLeaderSize, []SencSubsample{{14, 0}}},
+		{"no slice", testNalSample(4, sps, sps), 4, false, cencClearLeaderSize, []SencSubsample{{28, 0}}},
+		{"HEVC", testNalSample(4, vps, trail), 4, true, 2, []SencSubsample{{30, 48}}},
+		{"HEVC non VCL only", testNalSample(4, vps), 4, true, 2, []SencSubsample{{24, 0}}},
+		{"clear data over 0xFFFF bytes", testNalSample(4, testNal([]byte{0x06}, 70000), slice), 4, false, cencClearLeaderSize, []SencSubsample{{0xFFFF, 0}, {70009 - 0xFFFF, 32}}},
+		{"truncated NAL unit", append(testNalSample(4, idr), 0x00, 0x00, 0x01, 0x00, 0x65), 4, false, cencClearLeaderSize, []SencSubsample{{8, 96}, {5, 0}}},
+	}
+	for _, test := range tests {
+		subsamples := videoSubsamples(test.sample, test.nalLengthSize, test.hevc, test.clearLeaderSize)
+		size := 0
+		for _, subsample := range subsamples {
+			if subsample.BytesOfProtectedData%aes.BlockSize != 0 {
+				t.Errorf("%s: %d protected bytes, not a multiple of the AES block size", test.name, subsample.BytesOfProtectedData)
+			}
+			size += int(subsample.BytesOfClearData) + int(subsample.BytesOfProtectedData)
+		}
+		if size != len(test.sample) {
+			t.Errorf("%s: subsamples of %d bytes, sample of %d bytes", test.name, size, len(test.sample))
+		}
+		if len(subsamples) != len(test.subsamples) {
+			t.Errorf("%s: subsamples %v, want %v", test.name, subsamples, test.subsamples)
+			continue
+		}
+		for i := range subsamples {
+			if subsamples[i] != test.subsamples[i] {
+				t.Errorf("%s: subsamples %v, want %v", test.name, subsamples, test.subsamples)
+				break
+			}
+		}
+	}
+}
+
+func TestEncryptDashFragmentCenc(t *testing.T) {
+	samples := [][]byte{byteRange(0x00, 20), byteRange(0x20, 37)}
+	var dConf DashConfig
+	dConf.Type = "audio"
+	dConf.Timescale = 48000
+	dConf.Encryption = &DashEncryption{Scheme: "cenc", Key: testKey, Iv: 0x0102030405060708}
+	fmp4 := testFragment(samples)
+	err := encryptDashFragment(dConf, fmp4, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// openssl enc -aes-128-ctr -K 2b7e151628aed2a6abf7158809cf4f3c -iv 010203040506070d0000000000000000
+	tests := []struct {
+		iv         string
+		ciphertext string
+	}{
+		{"010203040506070d", "3a7b8161e5ba78420f7405d5ad2c17bf4281a0a6"},
+		{"010203040506070e", "9dc007d30805fe5636771faac639068dfe679c87f5b6efe9c2fed18e65b0ea83a6487d0c9a"},
+	}
+	senc := fmp4["moof.traf.senc"][0].(SencBox)
+	mdat := fmp4["mdat"][0].(MdatBox)
+	var ciphertext []byte
+	for i, test := range tests {
+		if !bytes.Equal(senc.Samples[i].Iv, fromHex(t, test.iv)) {
+			t.Errorf("sample %d: IV %x, want %s", i, senc.Samples[i].Iv, test.iv)
+		}
+		if senc.Samples[i].Subsamples != nil {
+			t.Errorf("sample %d: subsamples %v of an audio sample", i, senc.Samples[i].Subsamples)
+		}
+		ciphertext = append(ciphertext, fromHex(t, test.ciphertext)...)
+	}
+	if !bytes.Equal(mdat.Data, ciphertext) {
+		t.Errorf("samples %x, want %x", mdat.Data, ciphertext)
+	}
+	if senc.Flags != [3]byte{0, 0, 0} || senc.SampleCount != 2 || senc.Size != 8+8+8 {
+		t.Errorf("SENC Box %+v", senc)
+	}
+	saiz := fmp4["moof.traf.saiz"][0].(SaizBox)
+	if saiz.DefaultSampleInfoSize != 8 || saiz.SampleCount != 2 || saiz.SampleInfoSize != nil {
+		t.Errorf("SAIZ Box %+v", saiz)
+	}
+	checkFragmentOffsets(t, "cenc audio", fmp4, ciphertext)
+}
+
+func TestEncryptDashFragmentCencVideo(t *testing.T) {
+	sps := testNal([]byte{0x67}, 10)
+	samples := [][]byte{
+		testNalSample(4, sps, testNal([]byte{0x65}, 100)),
+		testNalSample(4, testNal([]byte{0x41}, 33), testNal([]byte{0x41}, 50)),
+		testNalSample(4, testNal([]byte{0x41}, 10)),
+	}
+	var clear []byte
+	for _, sample := range samples {
+		clear = append(clear, sample...)
+	}
+	var dConf DashConfig
+	dConf.Type = "video"
+	dConf.Timescale = 90000
+	dConf.Video = &DashVideoEntry{NalUnitSize: 0xFF}
+	dConf.Encryption = &DashEncryption{Scheme: "cenc", Key: testKey, Iv: 0x0102030405060708}
+	fmp4 := testFragment(samples)
+	err := encryptDashFragment(dConf, fmp4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := aes.NewCipher(testKey[:])
+	senc := fmp4["moof.traf.senc"][0].(SencBox)
+	mdat := fmp4["mdat"][0].(MdatBox)
+	offset := 0
+	for i, sample := range samples {
+		subsamples := senc.Samples[i].Subsamples
+		want := videoSubsamples(sample, 4, false, cencClearLeaderSize)
+		if len(subsamples) != len(want) {
+			t.Errorf("sample %d: subsamples %v, want %v", i, subsamples, want)
+			continue
+		}
+		// The protected bytes of the subsamples are encrypted with one key stream, the clear ones are unchanged
+		counter := make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(counter, 0x0102030405060708+uint64(i))
+		stream := cipher.NewCTR(block, counter)
+		position := offset
+		for j, subsample := range subsamples {
+			if subsample != want[j] {
+				t.Errorf("sample %d: subsamples %v, want %v", i, subsamples, want)
+				break
+			}
+			end := position + int(subsample.BytesOfClearData)
+			if !bytes.Equal(mdat.Data[position:end], clear[position:end]) {
+				t.Errorf("sample %d: clear bytes %d to %d changed", i, position, end)
+			}
+			position = end
+			end += int(subsample.BytesOfProtectedData)
+			protected := make([]byte, end-position)
+			stream.XORKeyStream(protected, clear[position:end])
+			if !bytes.Equal(mdat.Data[position:end], protected) {
+				t.Errorf("sample %d: protected bytes %d to %d not encrypted with the sample IV", i, position, end)
+			}
+			position = end
+		}
+		offset += len(sample)
+	}
+
+	// Samples with subsamples: 8 bytes IV, 2 bytes subsample count and 6 bytes per subsample
+	saiz := fmp4["moof.traf.saiz"][0].(SaizBox)
+	if saiz.DefaultSampleInfoSize != 0 || !bytes.Equal(saiz.SampleInfoSize, []byte{16, 22, 16}) {
+		t.Errorf("SAIZ Box %+v", saiz)
+	}
+	if senc.Flags[2] != 0x02 || senc.Size != 8+16+22+16 {
+		t.Errorf("SENC Box %+v", senc)
+	}
+	checkFragmentOffsets(t, "cenc video", fmp4, mdat.Data)
+}
+
+func TestKeyPeriodAt(t *testing.T) {
+	encryption := DashEncryption{KeyId: [16]byte{1}, Key: testKey}
+	periods := []DashKeyPeriod{
+		{KeyId: [16]byte{2}, Start: 0, End: 8000},
+		{KeyId: [16]byte{3}, Start: 8000, End: 16000},
+		// Gap between the second and third periods
+		{KeyId: [16]byte{4}, Start: 20000, End: 30000},
+	}
+	tests := []struct {
+		name      string
+		periods   []DashKeyPeriod
+		time      uint64
+		timescale uint32
+		keyId     byte // 0 when no period covers the time
+		rotation  bool
+	}{
+		{"without key rotation", nil, 1000000, 90000, 1, false},
+		{"first period", periods, 0, 90000, 2, true},
+		{"end of the first period", periods, 8*90000 - 1, 90000, 2, true},
+		{"second period", periods, 8 * 90000, 90000, 3, true},
+		{"gap", periods, 17 * 48000, 48000, 0, true},
+		{"third period", periods, 20 * 48000, 48000, 4, true},
+		{"after the last period", periods, 30 * 90000, 90000, 0, true},
+		{"no timescale", periods, 0, 0, 0, true},
+	}
+	for _, test := range tests {
+		encryption.KeyPeriods = test.periods
+		period, rotation, err := encryption.KeyPeriodAt(test.time, test.timescale)
+		if test.keyId == 0 {
+			if err == nil {
+				t.Errorf("%s: key period %v, want an error", test.name, period.KeyId)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if period.KeyId != [16]byte{test.keyId} || rotation != test.rotation {
+			t.Errorf("%s: key period %v with rotation %v, want key id %d with rotation %v", test.name, period.KeyId, rotation, test.keyId, test.rotation)
+		}
+	}
+}
+
+func TestAddSencBox(t *testing.T) {
+	tests := []struct {
+		name string
+		senc SencBox
+		size uint32
+	}{
+		{"no sample auxiliary information", SencBox{SampleCount: 2, Samples: make([]SencSample, 2)}, 0},
+		{"IVs", SencBox{SampleCount: 2, Samples: []SencSample{{Iv: byteRange(1, 8)}, {Iv: byteRange(2, 8)}}}, (8 + 16 + 8) + (9 + 8) + (12 + 8)},
+		{"subsamples without IV", SencBox{Flags: [3]byte{0, 0, 2}, SampleCount: 2, Samples: []SencSample{
+			{Subsamples: []SencSubsample{{10, 32}}},
+			{Subsamples: []SencSubsample{{10, 32}, {5, 0}}},
+		}}, (8 + 8 + 14 + 8) + (9 + 2 + 8) + (12 + 8)},
+	}
+	for _, test := range tests {
+		samples := make([][]byte, test.senc.SampleCount)
+		for i := range samples {
+			samples[i] = byteRange(i, 42)
+		}
+		fmp4 := testFragment(samples)
+		mdat := fmp4["mdat"][0].(MdatBox)
+		size := addSencBox(fmp4, test.senc)
+		if size != test.size {
+			t.Errorf("%s: added %d bytes, want %d", test.name, size, test.size)
+			continue
+		}
+		if size == 0 {
+			if fmp4["moof.traf.senc"] != nil || fmp4["moof.traf.saiz"] != nil || fmp4["moof.traf.saio"] != nil {
+				t.Errorf("%s: boxes added without sample auxiliary information", test.name)
+			}
+			continue
+		}
+		growDashFragment(fmp4, size, 0)
+		checkFragmentOffsets(t, test.name, fmp4, mdat.Data)
+	}
+}
+
+// NIST SP 800-38A F.2.1 CBC-AES128.Encrypt blocks
+const (
+	testCbcIv = "000102030405060708090a0b0c0d0e0f"
+	testCbcP1 = "6bc1bee22e409f96e93d7e117393172a"
+	testCbcP2 = "ae2d8a571e03ac9c9eb76fac45af8e51"
+	testCbcP3 = "30c81c46a35ce411e5fbc1191a0a52ef"
+	testCbcP4 = "f69f2445df4f9b17ad2b417be66c3710"
+	testCbcC1 = "7649abac8119b246cee98e9b12e9197d"
+	testCbcC2 = "5086cb9b507219ee95db113a917678b2"
+	testCbcC3 = "73bed6b8e3c1743b7116e69e22229516"
+	testCbcC4 = "3ff1caa1681fac09120eca307586e1a7"
+)
+
+func TestEncryptCbcs(t *testing.T) {
+	// 9 blocks left in clear by the 1:9 pattern
+	skipped := hex.EncodeToString(byteRange(0x40, 9*aes.BlockSize))
+	tests := []struct {
+		name           string
+		cryptByteBlock int
+		skipByteBlock  int
+		plaintext      string
+		ciphertext     string
+	}{
+		{"full", 0, 0, testCbcP1 + testCbcP2 + testCbcP3 + testCbcP4, testCbcC1 + testCbcC2 + testCbcC3 + testCbcC4},
+		{"full with a partial block", 0, 0, testCbcP1 + testCbcP2 + "0102030405", testCbcC1 + testCbcC2 + "0102030405"},
+		{"full shorter than a block", 0, 0, "0102030405", "0102030405"},
+		{"1:9 pattern", 1, 9, testCbcP1 + skipped + testCbcP2, testCbcC1 + skipped + testCbcC2},
+		{"1:9 pattern with a partial block", 1, 9, testCbcP1 + skipped + testCbcP2 + "01020304", testCbcC1 + skipped + testCbcC2 + "01020304"},
+		{"1:9 pattern ending in skipped blocks", 1, 9, testCbcP1 + skipped[:64], testCbcC1 + skipped[:64]},
+		{"1:9 pattern shorter than a block", 1, 9, "0102030405", "0102030405"},
+	}
+	block, _ := aes.NewCipher(testKey[:])
+	for _, test := range tests {
+		data := fromHex(t, test.plaintext)
+		encryptCbcs(block, fromHex(t, testCbcIv), data, test.cryptByteBlock, test.skipByteBlock)
+		if hex.EncodeToString(data) != test.ciphertext {
+			t.Errorf("%s: %x, want %s", test.name, data, test.ciphertext)
+		}
+	}
+}
+
+func TestEncryptDashFragmentCbcs(t *testing.T) {
+	skipped := hex.EncodeToString(byteRange(0x40, 9*aes.BlockSize))
+	tests := []struct {
+		name       string
+		dConf      DashConfig
+		samples    []string
+		ciphertext []string
+		subsamples [][]SencSubsample // No SENC Box if nil
+	}{
+		{
+			"audio, full encryption restarting with the constant IV",
+			DashConfig{Type: "audio", Timescale: 48000},
+			[]string{testCbcP1 + testCbcP2 + testCbcP3 + testCbcP4 + "010203", testCbcP1 + testCbcP2},
+			[]string{testCbcC1 + testCbcC2 + testCbcC3 + testCbcC4 + "010203", testCbcC1 + testCbcC2},
+			nil,
+		},
+		{
+			"video, 1:9 pattern of slices after their clear leader",
+			DashConfig{Type: "video", Timescale: 90000, Video: &DashVideoEntry{NalUnitSize: 0xFF}},
+			[]string{
+				// 4 bytes NAL unit length, slice NAL unit header and 31 bytes of slice header left in clear
+				"000000d0" + "41" + hex.EncodeToString(byteRange(1, 31)) + testCbcP1 + skipped + testCbcP2,
+				"0000000a" + "06" + hex.EncodeToString(byteRange(1, 9)) + "000000d0" + "65" + hex.EncodeToString(byteRange(1, 31)) + testCbcP1 + skipped + testCbcP2 + "0102",
+			},
+			[]string{
+				"000000d0" + "41" + hex.EncodeToString(byteRange(1, 31)) + testCbcC1 + skipped + testCbcC2,
+				"0000000a" + "06" + hex.EncodeToString(byteRange(1, 9)) + "000000d0" + "65" + hex.EncodeToString(byteRange(1, 31)) + testCbcC1 + skipped + testCbcC2 + "0102",
+			},
+			[][]SencSubsample{{{36, 176}}, {{50, 176}, {2, 0}}},
+		},
+	}
+	for _, test := range tests {
+		var samples [][]byte
+		for _, sample := range test.samples {
+			samples = append(samples, fromHex(t, sample))
+		}
+		dConf := test.dConf
+		dConf.Encryption = &DashEncryption{Scheme: "cbcs", Key: testKey}
+		copy(dConf.Encryption.ConstantIv[:], fromHex(t, testCbcIv))
+		fmp4 := testFragment(samples)
+		err := encryptDashFragment(dConf, fmp4, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mdat := fmp4["mdat"][0].(MdatBox)
+		var ciphertext []byte
+		for _, sample := range test.ciphertext {
+			ciphertext = append(ciphertext, fromHex(t, sample)...)
+		}
+		if !bytes.Equal(mdat.Data, ciphertext) {
+			t.Errorf("%s: samples %x, want %x", test.name, mdat.Data, ciphertext)
+		}
+		if test.subsamples == nil {
+			if fmp4["moof.traf.senc"] != nil || fmp4["moof.traf.saiz"] != nil || fmp4["moof.traf.saio"] != nil {
+				t.Errorf("%s: sample auxiliary information boxes without IV nor subsamples", test.name)
+			}
+			continue
+		}
+		senc := fmp4["moof.traf.senc"][0].(SencBox)
+		for i, sample := range senc.Samples {
+			if sample.Iv != nil {
+				t.Errorf("%s: sample %d: IV %x with a constant IV", test.name, i, sample.Iv)
+			}
+			subsamples := test.subsamples[i]
+			if len(sample.Subsamples) != len(subsamples) {
+				t.Errorf("%s: sample %d: subsamples %v, want %v", test.name, i, sample.Subsamples, subsamples)
+				continue
+			}
+			for j := range subsamples {
+				if sample.Subsamples[j] != subsamples[j] {
+					t.Errorf("%s: sample %d: subsamples %v, want %v", test.name, i, sample.Subsamples, subsamples)
+					break
+				}
+			}
+		}
+		checkFragmentOffsets(t, test.name, fmp4, ciphertext)
+	}
+}
+
+func TestLayoutEncryptedDashFragment(t *testing.T) {
+	sps := testNal([]byte{0x67}, 10)
+	video := [][]byte{
+		testNalSample(4, sps, testNal([]byte{0x65}, 300)),
+		testNalSample(4, testNal([]byte{0x41}, 33), testNal([]byte{0x06}, 70000), testNal([]byte{0x41}, 50)),
+		testNalSample(4, testNal([]byte{0x41}, 10)),
+	}
+	audio := [][]byte{byteRange(0, 20), byteRange(0x20, 37), byteRange(0x40, 64)}
+	rotation := &DashEncryption{Scheme: "cenc", Key: testKey, KeyPeriods: []DashKeyPeriod{
+		{KeyId: [16]byte{1}, Key: testKey, Pssh: []PsshBox{{Size: 32, Version: 0, SystemId: [16]byte{2}, Data: make([]byte, 4)}}, Start: 0, End: 1000},
+	}}
+	tests := []struct {
+		name       string
+		dConf      DashConfig
+		encryption *DashEncryption
+		samples    [][]byte
+	}{
+		{"cenc audio", DashConfig{Type: "audio", Timescale: 48000}, &DashEncryption{Scheme: "cenc", Key: testKey}, audio},
+		{"cbcs audio", DashConfig{Type: "audio", Timescale: 48000}, &DashEncryption{Scheme: "cbcs", Key: testKey}, audio},
+		{"cenc video", DashConfig{Type: "video", Timescale: 90000, Video: &DashVideoEntry{NalUnitSize: 0xFF}}, &DashEncryption{Scheme: "cenc", Key: testKey}, video},
+		{"cbcs video", DashConfig{Type: "video", Timescale: 90000, Video: &DashVideoEntry{NalUnitSize: 0xFF}}, &DashEncryption{Scheme: "cbcs", Key: testKey}, video},
+		{"cenc video with key rotation", DashConfig{Type: "video", Timescale: 90000, Video: &DashVideoEntry{NalUnitSize: 0xFF}}, rotation, video},
+	}
+	for _, test := range tests {
+		// Samples in 2 runs of the file: the first one alone and the others after a gap
+		data := make([]byte, 10)
+		data = append(data, test.samples[0]...)
+		data = append(data, make([]byte, 5)...)
+		for _, sample := range test.samples[1:] {
+			data = append(data, sample...)
+		}
+		filename := writeTestFile(t, data)
+		defer os.RemoveAll(path.Dir(filename))
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		fileFragment := func() map[string][]interface{} {
+			fmp4 := testFragment(test.samples)
+			mdat := fmp4["mdat"][0].(MdatBox)
+			mdat.Data = nil
+			mdat.Filename = filename
+			mdat.Chunks = []MdatChunk{{Offset: 10, Size: uint32(len(test.samples[0]))}, {Offset: int64(10 + len(test.samples[0]) + 5), Size: uint32(int(mdat.Size) - len(test.samples[0]))}}
+			replaceBox(fmp4, "mdat", mdat)
+			return fmp4
+		}
+
+		dConf := test.dConf
+		dConf.Encryption = test.encryption
+		encrypted := fileFragment()
+		err = encryptDashFragment(dConf, encrypted, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layout := fileFragment()
+		err = layoutEncryptedDashFragment(dConf, f, layout, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		// Same boxes, the IVs are not set by the layout
+		for _, boxPath := range []string{"moof", "moof.traf", "moof.traf.trun", "moof.traf.saiz", "moof.traf.saio", "moof.traf.sbgp", "moof.traf.sgpd", "moof.pssh"} {
+			if fmt.Sprint(encrypted[boxPath]) != fmt.Sprint(layout[boxPath]) {
+				t.Errorf("%s: %s Box %v, want %v", test.name, boxPath, layout[boxPath], encrypted[boxPath])
+			}
+		}
+		if (encrypted["moof.traf.senc"] == nil) != (layout["moof.traf.senc"] == nil) {
+			t.Errorf("%s: SENC Box %v, want %v", test.name, layout["moof.traf.senc"], encrypted["moof.traf.senc"])
+			continue
+		}
+		if encrypted["moof.traf.senc"] == nil {
+			continue
+		}
+		encryptedSenc := encrypted["moof.traf.senc"][0].(SencBox)
+		layoutSenc := layout["moof.traf.senc"][0].(SencBox)
+		if layoutSenc.Size != encryptedSenc.Size || layoutSenc.Flags != encryptedSenc.Flags {
+			t.Errorf("%s: SENC Box of %d bytes, want %d", test.name, layoutSenc.Size, encryptedSenc.Size)
+		}
+		for i := range encryptedSenc.Samples {
+			if fmt.Sprint(layoutSenc.Samples[i].Subsamples) != fmt.Sprint(encryptedSenc.Samples[i].Subsamples) || len(layoutSenc.Samples[i].Iv) != len(encryptedSenc.Samples[i].Iv) {
+				t.Errorf("%s: sample %d: %v, want %v", test.name, i, layoutSenc.Samples[i], encryptedSenc.Samples[i])
+			}
+		}
+	}
+}
+
+func TestReadSampleAuxInfoWithConf(t *testing.T) {
+	// 4 samples with an 8 bytes IV, sample i has i+1 subsamples
+	var auxInfo []byte
+	var sizes []byte
+	for i := 0; i < 4; i++ {
+		info := bytes.Repeat([]byte{byte(i + 1)}, 8)
+		info = append(info, 0, byte(i+1))
+		for j := 0; j <= i; j++ {
+			info = append(info, 0, byte(j), 0, 0, 1, byte(i))
+		}
+		sizes = append(sizes, byte(len(info)))
+		auxInfo = append(auxInfo, info...)
+	}
+	var ivs []byte
+	for i := 0; i < 4; i++ {
+		ivs = append(ivs, bytes.Repeat([]byte{byte(i + 1)}, 8)...)
+	}
+	saizPayload := func(flags byte, defaultSize byte, sizes []byte) []byte {
+		payload := []byte{0, 0, 0, flags}
+		if flags&0x01 != 0 {
+			payload = append(payload, 'c', 'e', 'n', 'c', 0, 0, 0, 0)
+		}
+		payload = append(payload, defaultSize, 0, 0, 0, 4)
+
+		return append(payload, sizes...)
+	}
+	tests := []struct {
+		name       string
+		saiz       []byte // nil with only a SENC Box
+		subsamples bool
+		auxInfo    []byte
+		end        int // Size of the auxiliary information of the first 2 samples, the file ends there
+	}{
+		{"SAIZ Box", saizPayload(0, 0, sizes), true, auxInfo, 10 + 6 + 10 + 12},
+		{"SAIZ Box with aux_info_type", saizPayload(1, 0, sizes), true, auxInfo, 10 + 6 + 10 + 12},
+		{"SAIZ Box with a default size", saizPayload(0, 8, nil), false, ivs, 16},
+		{"SENC Box without subsamples", nil, false, ivs, 16},
+		{"SENC Box with subsamples", nil, true, auxInfo, len(auxInfo)},
+	}
+	for _, test := range tests {
+		// The sample auxiliary information of the following samples is not read
+		data := append([]byte{0, 0, 0, 0}, test.saiz...)
+		data = append(data, test.auxInfo[:test.end]...)
+		filename := writeTestFile(t, data)
+		defer os.RemoveAll(path.Dir(filename))
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dConf DashConfig
+		dConf.Protection = &DashProtection{PerSampleIvSize: 8, Subsamples: test.subsamples, AuxInfoOffset: int64(4 + len(test.saiz)), AuxInfoSize: uint32(len(test.auxInfo))}
+		if test.saiz != nil {
+			dConf.Protection.SaizBoxOffset = 4
+			dConf.Protection.SaizBoxSize = uint32(len(test.saiz))
+		}
+		senc, err := readSampleAuxInfoWithConf(f, dConf, 1)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if senc.SampleCount != 2 || len(senc.Samples) != 2 || (senc.Flags[2] == 0x02) != test.subsamples {
+			t.Errorf("%s: %d samples, flags %v", test.name, senc.SampleCount, senc.Flags)
+			continue
+		}
+		for i, sample := range senc.Samples {
+			if !bytes.Equal(sample.Iv, bytes.Repeat([]byte{byte(i + 1)}, 8)) {
+				t.Errorf("%s: sample %d: IV %x", test.name, i, sample.Iv)
+			}
+			subsamples := 0
+			if test.subsamples {
+				subsamples = i + 1
+			}
+			if len(sample.Subsamples) != subsamples || (subsamples > 0 && sample.Subsamples[i] != (SencSubsample{uint16(i), 256 + uint32(i)})) {
+				t.Errorf("%s: sample %d: subsamples %v", test.name, i, sample.Subsamples)
+			}
+		}
+	}
+}
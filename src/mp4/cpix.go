@@ -0,0 +1,175 @@
+// Copyright (c) 2015
+//      Sebastien Petit & Afrostream - www.afrostream.tv - spebsd@gmail.com.
+//      All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+// LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+// OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+// SUCH DAMAGE.
+
+package mp4
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+// DASH-IF Content Protection Information Exchange Format (CPIX) document, only clear content keys are supported
+type cpixDocument struct {
+	ContentKeys []struct {
+		KeyId                  string `xml:"kid,attr"`
+		CommonEncryptionScheme string `xml:"commonEncryptionScheme,attr"`
+		PlainValue             string `xml:"Data>Secret>PlainValue"`
+		EncryptedValue         string `xml:"Data>Secret>EncryptedValue>CipherData>CipherValue"`
+	} `xml:"ContentKeyList>ContentKey"`
+	DrmSystems []struct {
+		KeyId    string `xml:"kid,attr"`
+		SystemId string `xml:"systemId,attr"`
+		Pssh     string `xml:"PSSH"`
+	} `xml:"DRMSystemList>DRMSystem"`
+	ContentKeyPeriods []struct {
+		Id    string `xml:"id,attr"`
+		Index uint32 `xml:"index,attr"`
+		Start string `xml:"start,attr"`
+		End   string `xml:"end,attr"`
+	} `xml:"ContentKeyPeriodList>ContentKeyPeriod"`
+	ContentKeyUsageRules []struct {
+		KeyId             string `xml:"kid,attr"`
+		IntendedTrackType string `xml:"intendedTrackType,attr"`
+		KeyPeriodFilters  []struct {
+			PeriodId string `xml:"periodId,attr"`
+		} `xml:"KeyPeriodFilter"`
+		VideoFilters []struct{} `xml:"VideoFilter"`
+		AudioFilters []struct{} `xml:"AudioFilter"`
+	} `xml:"ContentKeyUsageRuleList>ContentKeyUsageRule"`
+}
+
+// Key identifier in UUID format to hexadecimal
+func cpixKeyId(uuid string) (keyId string, err error) {
+	keyId = strings.ToLower(strings.Replace(uuid, "-", "", -1))
+	b, err := hex.DecodeString(keyId)
+	if err != nil || len(b) != 16 {
+		err = errors.New("invalid key id '" + uuid + "' in CPIX document")
+	}
+
+	return
+}
+
+// Read the content keys, the DRM systems signalling and the key periods of a CPIX document
+func ReadCpixFile(filename string) (drm *DrmConfig, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	var cpix cpixDocument
+	err = xml.Unmarshal(data, &cpix)
+	if err != nil {
+		return
+	}
+	if len(cpix.ContentKeys) == 0 {
+		err = errors.New("no content key in CPIX document")
+		return
+	}
+
+	drm = new(DrmConfig)
+	for _, p := range cpix.ContentKeyPeriods {
+		drm.KeyPeriods = append(drm.KeyPeriods, DrmKeyPeriod{Id: p.Id, Index: p.Index, Start: p.Start, End: p.End})
+	}
+	for _, c := range cpix.ContentKeys {
+		var k DrmKey
+		k.KeyId, err = cpixKeyId(c.KeyId)
+		if err != nil {
+			return
+		}
+		if c.PlainValue == "" {
+			if c.EncryptedValue != "" {
+				err = errors.New("encrypted content keys of CPIX documents are not supported")
+			} else {
+				err = errors.New("no value for content key '" + c.KeyId + "' in CPIX document")
+			}
+			return
+		}
+		key, e := base64.StdEncoding.DecodeString(strings.TrimSpace(c.PlainValue))
+		if e != nil || len(key) != 16 {
+			err = errors.New("invalid value for content key '" + c.KeyId + "' in CPIX document")
+			return
+		}
+		k.Key = hex.EncodeToString(key)
+		if c.CommonEncryptionScheme != "" {
+			if drm.Scheme != "" && drm.Scheme != c.CommonEncryptionScheme {
+				err = errors.New("content keys with different encryption schemes in CPIX document")
+				return
+			}
+			drm.Scheme = c.CommonEncryptionScheme
+		}
+
+		// Usage rules give the track type and the key periods of the key
+		var periodIds []string
+		for _, r := range cpix.ContentKeyUsageRules {
+			ruleKeyId, _ := cpixKeyId(r.KeyId)
+			if ruleKeyId != k.KeyId {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(strings.ToUpper(r.IntendedTrackType), "VIDEO") || len(r.VideoFilters) > 0:
+				k.TrackType = "video"
+			case strings.HasPrefix(strings.ToUpper(r.IntendedTrackType), "AUDIO") || len(r.AudioFilters) > 0:
+				k.TrackType = "audio"
+			}
+			for _, f := range r.KeyPeriodFilters {
+				periodIds = append(periodIds, f.PeriodId)
+			}
+		}
+		if len(periodIds) == 0 {
+			drm.Keys = append(drm.Keys, k)
+		}
+		for _, periodId := range periodIds {
+			k.PeriodId = periodId
+			drm.Keys = append(drm.Keys, k)
+		}
+	}
+	for _, s := range cpix.DrmSystems {
+		var system DrmSystem
+		system.SystemId = strings.ToLower(s.SystemId)
+		system.KeyId, err = cpixKeyId(s.KeyId)
+		if err != nil {
+			return
+		}
+		system.Pssh = strings.TrimSpace(s.Pssh)
+		if system.Pssh != "" {
+			_, err = ParsePsshBox(system.Pssh)
+			if err != nil {
+				return
+			}
+		}
+		drm.Systems = append(drm.Systems, system)
+	}
+
+	return
+}
@@ -0,0 +1,133 @@
+package mp4
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// CPIX document with a video and an audio key in each of its 2 key periods, and a key of all the tracks of both periods
+const testCpixDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<CPIX xmlns="urn:dashif:org:cpix" xmlns:pskc="urn:ietf:params:xml:ns:keyprov:pskc">
+  <ContentKeyList>
+    <ContentKey kid="30000000-3000-3000-3000-300000000003" commonEncryptionScheme="cbcs">
+      <Data><pskc:Secret><pskc:PlainValue>ESIzRFVmd4iZqrvM3e7/AA==</pskc:PlainValue></pskc:Secret></Data>
+    </ContentKey>
+    <ContentKey kid="40000000-4000-4000-4000-400000000004" commonEncryptionScheme="cbcs">
+      <Data><pskc:Secret><pskc:PlainValue>AAECAwQFBgcICQoLDA0ODw==</pskc:PlainValue></pskc:Secret></Data>
+    </ContentKey>
+    <ContentKey kid="50000000-5000-5000-5000-500000000005">
+      <Data><pskc:Secret><pskc:PlainValue>K34VFiiu0qar9xWICc9PPA==</pskc:PlainValue></pskc:Secret></Data>
+    </ContentKey>
+    <ContentKey kid="60000000-6000-6000-6000-600000000006">
+      <Data><pskc:Secret><pskc:PlainValue>ABEiM0RVZneImaq7zN3u/w==</pskc:PlainValue></pskc:Secret></Data>
+    </ContentKey>
+    <ContentKey kid="70000000-7000-7000-7000-700000000007">
+      <Data><pskc:Secret><pskc:PlainValue>Dw4NDAsKCQgHBgUEAwIBAA==</pskc:PlainValue></pskc:Secret></Data>
+    </ContentKey>
+  </ContentKeyList>
+  <DRMSystemList>
+    <DRMSystem kid="30000000-3000-3000-3000-300000000003" systemId="EDEF8BA9-79D6-4ACE-A3C8-27DCD51D21ED">
+      <PSSH>AAAASnBzc2gAAAAA7e+LqXnWSs6jyCfc1R0h7QAAACoSEBAAAAAQABAAEAAQAAAAAAEaDXdpZGV2aW5lX3Rlc3QiASpI49yVmwY=</PSSH>
+    </DRMSystem>
+    <DRMSystem kid="40000000-4000-4000-4000-400000000004" systemId="9a04f079-9840-4286-ab92-e65be0885f95"/>
+  </DRMSystemList>
+  <ContentKeyPeriodList>
+    <ContentKeyPeriod id="keyPeriod_2" index="2" start="2026-01-01T00:00:30Z" end="2026-01-01T00:01:00Z"/>
+    <ContentKeyPeriod id="keyPeriod_1" index="1" start="2026-01-01T00:00:00Z" end="2026-01-01T00:00:30Z"/>
+  </ContentKeyPeriodList>
+  <ContentKeyUsageRuleList>
+    <ContentKeyUsageRule kid="30000000-3000-3000-3000-300000000003" intendedTrackType="VIDEO">
+      <KeyPeriodFilter periodId="keyPeriod_1"/>
+    </ContentKeyUsageRule>
+    <ContentKeyUsageRule kid="40000000-4000-4000-4000-400000000004">
+      <KeyPeriodFilter periodId="keyPeriod_1"/>
+      <AudioFilter/>
+    </ContentKeyUsageRule>
+    <ContentKeyUsageRule kid="50000000-5000-5000-5000-500000000005">
+      <KeyPeriodFilter periodId="keyPeriod_2"/>
+      <VideoFilter minPixels="0"/>
+    </ContentKeyUsageRule>
+    <ContentKeyUsageRule kid="60000000-6000-6000-6000-600000000006" intendedTrackType="AUDIO">
+      <KeyPeriodFilter periodId="keyPeriod_2"/>
+    </ContentKeyUsageRule>
+    <ContentKeyUsageRule kid="70000000-7000-7000-7000-700000000007">
+      <KeyPeriodFilter periodId="keyPeriod_1"/>
+      <KeyPeriodFilter periodId="keyPeriod_2"/>
+    </ContentKeyUsageRule>
+  </ContentKeyUsageRuleList>
+</CPIX>
+`
+
+func TestReadCpixFile(t *testing.T) {
+	filename := writeTestFile(t, []byte(testCpixDocument))
+	defer os.RemoveAll(path.Dir(filename))
+	drm, err := ReadCpixFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drm.Scheme != "cbcs" {
+		t.Errorf("scheme %q, want cbcs", drm.Scheme)
+	}
+	keys := []DrmKey{
+		{"30000000300030003000300000000003", "112233445566778899aabbccddeeff00", "video", "keyPeriod_1"},
+		{"40000000400040004000400000000004", "000102030405060708090a0b0c0d0e0f", "audio", "keyPeriod_1"},
+		{"50000000500050005000500000000005", "2b7e151628aed2a6abf7158809cf4f3c", "video", "keyPeriod_2"},
+		{"60000000600060006000600000000006", "00112233445566778899aabbccddeeff", "audio", "keyPeriod_2"},
+		// Key of all the tracks, once for each period
+		{"70000000700070007000700000000007", "0f0e0d0c0b0a09080706050403020100", "", "keyPeriod_1"},
+		{"70000000700070007000700000000007", "0f0e0d0c0b0a09080706050403020100", "", "keyPeriod_2"},
+	}
+	if fmt.Sprint(drm.Keys) != fmt.Sprint(keys) {
+		t.Errorf("keys %v, want %v", drm.Keys, keys)
+	}
+	// Key periods in the order of the document, they are sorted by index when they are used
+	periods := []DrmKeyPeriod{
+		{"keyPeriod_2", 2, "2026-01-01T00:00:30Z", "2026-01-01T00:01:00Z"},
+		{"keyPeriod_1", 1, "2026-01-01T00:00:00Z", "2026-01-01T00:00:30Z"},
+	}
+	if fmt.Sprint(drm.KeyPeriods) != fmt.Sprint(periods) {
+		t.Errorf("key periods %v, want %v", drm.KeyPeriods, periods)
+	}
+	if len(drm.Systems) != 2 {
+		t.Fatalf("DRM systems %v", drm.Systems)
+	}
+	if drm.Systems[0].SystemId != "edef8ba9-79d6-4ace-a3c8-27dcd51d21ed" || drm.Systems[0].KeyId != "30000000300030003000300000000003" ||
+		!strings.HasPrefix(drm.Systems[0].Pssh, "AAAASnBzc2g") {
+		t.Errorf("DRM system %+v", drm.Systems[0])
+	}
+	if drm.Systems[1].SystemId != "9a04f079-9840-4286-ab92-e65be0885f95" || drm.Systems[1].Pssh != "" {
+		t.Errorf("DRM system %+v", drm.Systems[1])
+	}
+}
+
+func TestReadCpixFileErrors(t *testing.T) {
+	replace := func(old string, new string) string {
+		return strings.Replace(testCpixDocument, old, new, 1)
+	}
+	tests := []struct {
+		name     string
+		document string
+		err      string
+	}{
+		{"encrypted key", replace(`<pskc:PlainValue>ESIzRFVmd4iZqrvM3e7/AA==</pskc:PlainValue>`,
+			`<pskc:EncryptedValue><enc:CipherData xmlns:enc="http://www.w3.org/2001/04/xmlenc#"><enc:CipherValue>AAAA</enc:CipherValue></enc:CipherData></pskc:EncryptedValue>`),
+			"encrypted content keys"},
+		{"key without value", replace(`<pskc:PlainValue>ESIzRFVmd4iZqrvM3e7/AA==</pskc:PlainValue>`, ""), "no value"},
+		{"key of 8 bytes", replace(`ESIzRFVmd4iZqrvM3e7/AA==`, `ESIzRFVmd4g=`), "invalid value"},
+		{"invalid key id", replace(`kid="30000000-3000-3000-3000-300000000003" commonEncryptionScheme`, `kid="3000" commonEncryptionScheme`), "invalid key id"},
+		{"different schemes", replace(`commonEncryptionScheme="cbcs"`, `commonEncryptionScheme="cenc"`), "different encryption schemes"},
+		{"invalid PSSH", replace(`<PSSH>AAAASn`, `<PSSH>AAAASm`), "invalid PSSH"},
+		{"no content key", `<CPIX xmlns="urn:dashif:org:cpix"><ContentKeyList/></CPIX>`, "no content key"},
+	}
+	for _, test := range tests {
+		filename := writeTestFile(t, []byte(test.document))
+		defer os.RemoveAll(path.Dir(filename))
+		_, err := ReadCpixFile(filename)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2015
+//      Sebastien Petit & Afrostream - www.afrostream.tv - spebsd@gmail.com.
+//      All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+// LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+// OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+// SUCH DAMAGE.
+
+package mp4
+
+// Dolby audio channel configuration bits (ETSI TS 102 366 Table I.1.1.1), pair bits stand for two channels
+const (
+	dolbyL      = 0x8000
+	dolbyC      = 0x4000
+	dolbyR      = 0x2000
+	dolbyLs     = 0x1000
+	dolbyRs     = 0x0800
+	dolbyLcRc   = 0x0400
+	dolbyLrsRrs = 0x0200
+	dolbyCs     = 0x0100
+	dolbyTs     = 0x0080
+	dolbyLsdRsd = 0x0040
+	dolbyLwRw   = 0x0020
+	dolbyVhlVhr = 0x0010
+	dolbyVhc    = 0x0008
+	dolbyLtsRts = 0x0004
+	dolbyLfe2   = 0x0002
+	dolbyLfe    = 0x0001
+	dolbyPairs  = dolbyLcRc | dolbyLrsRrs | dolbyLsdRsd | dolbyLwRw | dolbyVhlVhr | dolbyLtsRts
+)
+
+// Channels of the AC-3 audio coding modes (acmod 0 to 7), dual mono is signalled as L / R
+var ac3AcmodChannels = []uint16{
+	dolbyL | dolbyR,
+	dolbyC,
+	dolbyL | dolbyR,
+	dolbyL | dolbyC | dolbyR,
+	dolbyL | dolbyR | dolbyCs,
+	dolbyL | dolbyC | dolbyR | dolbyCs,
+	dolbyL | dolbyR | dolbyLs | dolbyRs,
+	dolbyL | dolbyC | dolbyR | dolbyLs | dolbyRs,
+}
+
+// Channels of the chan_loc bits of the DEC3 Box, from the most significant one
+var ec3ChanLocChannels = []uint16{dolbyLcRc, dolbyLrsRrs, dolbyCs, dolbyTs, dolbyLsdRsd, dolbyLwRw, dolbyVhlVhr, dolbyVhc, dolbyLfe2}
+
+// Dolby audio channel configuration of an ac-3 or ec-3 track (eg: 0xF801 for 5.1), 0 for other tracks
+func DolbyChannelConfiguration(audio DashAudioEntry) (configuration uint16) {
+	switch {
+	case audio.Dac3 != nil:
+		configuration = ac3AcmodChannels[audio.Dac3.Acmod&0x07]
+		if audio.Dac3.LfeOn {
+			configuration |= dolbyLfe
+		}
+	case audio.Dec3 != nil && len(audio.Dec3.Substreams) > 0:
+		// The first independent substream carries the main program, its dependent substreams extend it
+		substream := audio.Dec3.Substreams[0]
+		configuration = ac3AcmodChannels[substream.Acmod&0x07]
+		if substream.LfeOn {
+			configuration |= dolbyLfe
+		}
+		for i, channels := range ec3ChanLocChannels {
+			if substream.ChanLoc&(0x100>>uint(i)) != 0 {
+				configuration |= channels
+			}
+		}
+	}
+
+	return
+}
+
+// ***
+// *** Private functions
+// ***
+
+// Number of channels of a Dolby audio channel configuration
+func dolbyChannelCount(configuration uint16) (count uint16) {
+	for bit := uint16(0x8000); bit != 0; bit >>= 1 {
+		if configuration&bit == 0 {
+			continue
+		}
+		count++
+		if dolbyPairs&bit != 0 {
+			count++
+		}
+	}
+
+	return
+}
@@ -0,0 +1,326 @@
+// Copyright (c) 2015
+//      Sebastien Petit & Afrostream - www.afrostream.tv - spebsd@gmail.com.
+//      All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+// LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+// OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+// SUCH DAMAGE.
+
+package mp4
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+)
+
+// DecoderConfigDescriptor object type indications of MPEG-1 and MPEG-2 (lower sampling frequencies) audio
+const (
+	mpeg1AudioObjectTypeIndication = 0x6B
+	mpeg2AudioObjectTypeIndication = 0x69
+)
+
+// Bitrates in kbit/s of the MPEG audio frame headers by bitrate index, for MPEG-1 layers I, II and III
+// then MPEG-2 / 2.5 layer I and layers II and III
+var mp3Bitrates = [5][15]uint32{
+	{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// Sampling frequencies of MPEG-1 audio, those of MPEG-2 are halved and those of MPEG-2.5 quartered
+var mp3SampleRates = [3]uint32{44100, 48000, 32000}
+
+// MPEG audio frame header fields (ISO/IEC 11172-3 and 13818-3)
+type mp3FrameHeader struct {
+	mpeg1      bool   // MPEG-1, MPEG-2 or 2.5 (lower sampling frequencies) otherwise
+	layer      int    // 1, 2 or 3
+	sampleRate uint32 // eg: 44100
+	channels   uint16 // 1 for mono, 2 otherwise
+	samples    uint32 // Samples per frame (eg: 1152)
+	size       uint32 // Frame size in bytes, header included
+}
+
+// Parse the 4 bytes header of an MPEG audio frame, free format frames are not supported
+func parseMp3FrameHeader(data []byte) (h mp3FrameHeader, err error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+		err = errors.New("no MPEG audio frame sync")
+		return
+	}
+	version := (data[1] >> 3) & 0x03
+	layerIndex := (data[1] >> 1) & 0x03
+	bitrateIndex := data[2] >> 4
+	sampleRateIndex := (data[2] >> 2) & 0x03
+	if version == 1 || layerIndex == 0 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		err = errors.New("invalid or free format MPEG audio frame header")
+		return
+	}
+	h.mpeg1 = version == 3
+	h.layer = int(4 - layerIndex)
+	h.sampleRate = mp3SampleRates[sampleRateIndex]
+	switch version {
+	case 2:
+		h.sampleRate /= 2
+	case 0:
+		h.sampleRate /= 4
+	}
+	h.channels = 2
+	if data[3]>>6 == 3 {
+		h.channels = 1
+	}
+
+	var bitrate uint32
+	switch {
+	case h.mpeg1:
+		bitrate = mp3Bitrates[h.layer-1][bitrateIndex] * 1000
+	case h.layer == 1:
+		bitrate = mp3Bitrates[3][bitrateIndex] * 1000
+	default:
+		bitrate = mp3Bitrates[4][bitrateIndex] * 1000
+	}
+	padding := uint32((data[2] >> 1) & 0x01)
+	switch {
+	case h.layer == 1:
+		h.samples = 384
+		h.size = (12*bitrate/h.sampleRate + padding) * 4
+	case h.layer == 3 && !h.mpeg1:
+		h.samples = 576
+		h.size = 72*bitrate/h.sampleRate + padding
+	default:
+		h.samples = 1152
+		h.size = 144*bitrate/h.sampleRate + padding
+	}
+
+	return
+}
+
+// Size of the ID3v2 tag at the beginning of data, 0 if there is none
+func id3v2TagSize(data []byte) int64 {
+	if len(data) < 10 || string(data[0:3]) != "ID3" {
+		return 0
+	}
+	size := int64(data[6]&0x7F)<<21 | int64(data[7]&0x7F)<<14 | int64(data[8]&0x7F)<<7 | int64(data[9]&0x7F)
+	size += 10
+	if data[5]&0x10 != 0 {
+		// Footer present
+		size += 10
+	}
+
+	return size
+}
+
+// Whether an MPEG audio frame only holds a Xing, Info or VBRI header of encoder information instead of audio
+func isMp3InfoFrame(h mp3FrameHeader, frame []byte) bool {
+	// The Xing / Info tag follows the layer III side information
+	sideInfoSize := 32
+	switch {
+	case h.mpeg1 && h.channels == 1:
+		sideInfoSize = 17
+	case !h.mpeg1 && h.channels == 2:
+		sideInfoSize = 17
+	case !h.mpeg1:
+		sideInfoSize = 9
+	}
+	for _, tag := range []struct {
+		offset int
+		name   string
+	}{{4 + sideInfoSize, "Xing"}, {4 + sideInfoSize, "Info"}, {36, "VBRI"}} {
+		if len(frame) >= tag.offset+4 && string(frame[tag.offset:tag.offset+4]) == tag.name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Read the sizes of at most maxFrames consecutive MPEG audio frames, the scan stops at the first
+// invalid header (eg: an ID3v1 or APE tag), at a change of sampling frequency or at a truncated frame
+func readMp3FrameSizes(r io.Reader, maxFrames uint32) (first mp3FrameHeader, sizes []uint32) {
+	br := bufio.NewReaderSize(r, 65536)
+	for uint32(len(sizes)) < maxFrames {
+		data, err := br.Peek(4)
+		if err != nil {
+			break
+		}
+		h, err := parseMp3FrameHeader(data)
+		if err != nil {
+			break
+		}
+		if len(sizes) == 0 {
+			first = h
+		} else if h.mpeg1 != first.mpeg1 || h.layer != first.layer || h.sampleRate != first.sampleRate {
+			break
+		}
+		n, _ := br.Discard(int(h.size))
+		if n < int(h.size) {
+			break
+		}
+		sizes = append(sizes, h.size)
+	}
+
+	return
+}
+
+// Read the STSZ Box of a raw MPEG audio track described by a DashConfig from its frame headers,
+// until the sample sampleCount
+func readMp3StszBox(f *os.File, dConf DashConfig, sampleCount uint32) (stsz StszBox) {
+	_, stsz.EntrySize = readMp3FrameSizes(io.NewSectionReader(f, dConf.MdatBoxOffset, int64(dConf.MdatBoxSize)), sampleCount)
+	stsz.SampleCount = uint32(len(stsz.EntrySize))
+	stsz.Size = 12 + stsz.SampleCount*4
+
+	return
+}
+
+// ***
+// *** Public functions
+// ***
+
+// Parse a raw MPEG audio file (eg: MP3) and return the boxes of an MP4 file with the same samples:
+// its frames after the ID3v2 tags and the Xing / Info frame are the mdat Box ones, the sample
+// table is rebuilt from the frame headers and the esds Box has the MPEG-1 or MPEG-2 audio object type
+func ParseMp3File(filename string, language string) (mp4 Mp4, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	finfo, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	// Skip the ID3v2 tags then look for two consecutive frames in the first 64 KiB
+	var offset int64
+	header := make([]byte, 10)
+	for {
+		if _, e := f.ReadAt(header, offset); e != nil {
+			break
+		}
+		size := id3v2TagSize(header)
+		if size == 0 {
+			break
+		}
+		offset += size
+	}
+	data := make([]byte, 65536)
+	n, _ := f.ReadAt(data, offset)
+	data = data[:n]
+	var h mp3FrameHeader
+	found := false
+	for i := 0; i+4 <= len(data) && !found; i++ {
+		if h, err = parseMp3FrameHeader(data[i:]); err != nil {
+			continue
+		}
+		next := i + int(h.size)
+		if next+4 > len(data) {
+			continue
+		}
+		if _, e := parseMp3FrameHeader(data[next:]); e == nil {
+			if isMp3InfoFrame(h, data[i:next]) {
+				i = next
+			}
+			offset += int64(i)
+			found = true
+		}
+	}
+	if !found {
+		err = errors.New("cannot find MPEG audio frames")
+		return
+	}
+
+	h, sizes := readMp3FrameSizes(io.NewSectionReader(f, offset, finfo.Size()-offset), ^uint32(0))
+	if len(sizes) == 0 {
+		err = errors.New("cannot find MPEG audio frames")
+		return
+	}
+
+	mp4.Filename = filename
+	mp4.Language = language
+	mp4.IsAudio = true
+	mp4.Boxes = make(map[string][]interface{})
+
+	var mdat MdatBox
+	mdat.Filename = filename
+	mdat.Offset = offset
+	for _, size := range sizes {
+		mdat.Size += uint64(size)
+	}
+	replaceBox(mp4.Boxes, "mdat", mdat)
+
+	var mdhd MdhdBox
+	mdhd.Timescale = h.sampleRate
+	mdhd.Duration = uint64(len(sizes)) * uint64(h.samples)
+	if len(language) == 3 {
+		mdhd.Language = uint16(language[0]-0x60)<<10 | uint16(language[1]-0x60)<<5 | uint16(language[2]-0x60)
+	}
+	mdhd.Size = 24
+	replaceBox(mp4.Boxes, "moov.trak.mdia.mdhd", mdhd)
+
+	var hdlr HdlrBox
+	hdlr.HandlerType = 0x736F756E // soun
+	hdlr.Name = []byte("SoundHandler\x00")
+	hdlr.Size = uint32(24 + len(hdlr.Name))
+	replaceBox(mp4.Boxes, "moov.trak.mdia.hdlr", hdlr)
+
+	var elst ElstBox
+	elst.EntryCount = 1
+	elst.SegmentDuration = mdhd.Duration
+	elst.MediaRateInteger = 1
+	elst.Size = 20
+	replaceBox(mp4.Boxes, "moov.trak.edts.elst", elst)
+
+	var stts SttsBox
+	stts.EntryCount = 1
+	stts.Entries = []SttsBoxEntry{{SampleCount: uint32(len(sizes)), SampleDelta: h.samples}}
+	stts.Size = 16
+	replaceBox(mp4.Boxes, "moov.trak.mdia.minf.stbl.stts", stts)
+
+	var stsz StszBox
+	stsz.SampleCount = uint32(len(sizes))
+	stsz.EntrySize = sizes
+	stsz.Size = 12 + stsz.SampleCount*4
+	replaceBox(mp4.Boxes, "moov.trak.mdia.minf.stbl.stsz", stsz)
+
+	var mp4a Mp4aBox
+	mp4a.DataReferenceIndex = 1
+	mp4a.NumberOfChannels = h.channels
+	mp4a.SampleSize = 16
+	mp4a.SampleRate = h.sampleRate << 16
+	var audio DashAudioEntry
+	audio.ObjectTypeIndication = mpeg2AudioObjectTypeIndication
+	if h.mpeg1 {
+		audio.ObjectTypeIndication = mpeg1AudioObjectTypeIndication
+	}
+	esds := createEsdsBox(&audio)
+	mp4a.Size = 28 + esds.Size + 8
+	replaceBox(mp4.Boxes, "moov.trak.mdia.minf.stbl.stsd.mp4a", mp4a)
+	replaceBox(mp4.Boxes, "moov.trak.mdia.minf.stbl.stsd.mp4a.esds", esds)
+
+	return
+}
@@ -34,17 +34,75 @@ package mp4
 import (
 	"encoding/binary"
 	"log"
+	"math"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 )
 
 var debugMode bool
 var funcBoxes map[string]interface{}
 
+// Sample entry types of the audio and video files that can be packaged
+var audioSampleEntries = []string{"mp4a", "ac-3", "ec-3", "Opus", "fLaC", "enca"}
+var videoSampleEntries = []string{"avc1", "hvc1", "hev1", "vp09", "av01", "mp4v", "encv"}
+
 type JsonConfig struct {
 	SegmentDuration uint32
 	Tracks          map[string][]TrackEntry
+	Drm             *DrmConfig           `json:",omitempty"`
+	HlsEncryption   *HlsEncryptionConfig `json:",omitempty"`
+}
+
+type DrmConfig struct {
+	KeyId       string           `json:",omitempty"` // Hexadecimal 16 bytes key identifier (eg: "10000000100010001000100000000001")
+	Key         string           `json:",omitempty"` // Hexadecimal 16 bytes content key
+	KeyFile     string           `json:",omitempty"` // File with "<key id>:<key>" hexadecimal lines, relative to the JSON file
+	Scheme      string           `json:",omitempty"` // "cenc" (AES-CTR, default) or "cbcs" (AES-CBC pattern encryption)
+	SkdUri      string           `json:",omitempty"` // Key URI of HLS SAMPLE-AES playlists (default: "skd://<key id>")
+	Widevine    *WidevineConfig  `json:",omitempty"` // Add a Widevine PSSH to init segments and MPD
+	PlayReady   *PlayReadyConfig `json:",omitempty"` // Add a PlayReady PSSH to init segments and MPD
+	Keys        []DrmKey         `json:",omitempty"` // Content keys, imported from a CPIX document
+	Systems     []DrmSystem      `json:",omitempty"` // DRM systems signalling, imported from a CPIX document
+	KeyPeriods  []DrmKeyPeriod   `json:",omitempty"` // Key periods, imported from a CPIX document
+	KeyRotation uint32           `json:",omitempty"` // Key rotation period in seconds, the keys of KeyFile or of KeyPeriods are used in turn (CPIX periods with start and end for their own duration)
+}
+
+type DrmKey struct {
+	KeyId     string // Hexadecimal 16 bytes key identifier
+	Key       string // Hexadecimal 16 bytes content key
+	TrackType string `json:",omitempty"` // "video" or "audio", the key is used for all the tracks if empty
+	PeriodId  string `json:",omitempty"` // Key period of the key
+}
+
+type DrmSystem struct {
+	SystemId string // DRM system identifier in UUID format (eg: "edef8ba9-79d6-4ace-a3c8-27dcd51d21ed")
+	KeyId    string // Hexadecimal 16 bytes key identifier
+	Pssh     string `json:",omitempty"` // Base64 PSSH Box added to init segments and MPD
+}
+
+type DrmKeyPeriod struct {
+	Id    string
+	Index uint32 `json:",omitempty"`
+	Start string `json:",omitempty"` // xs:dateTime
+	End   string `json:",omitempty"` // xs:dateTime
+}
+
+type HlsEncryptionConfig struct {
+	KeyId   string `json:",omitempty"` // Hexadecimal 16 bytes key identifier, the key URI is /keys/<asset>.json/<key id>
+	Key     string `json:",omitempty"` // Hexadecimal 16 bytes AES-128 key
+	KeyFile string `json:",omitempty"` // File with "<key id>:<key>" hexadecimal lines, relative to the JSON file
+	Iv      string `json:",omitempty"` // "sequence" (default: the media sequence number), "hash" (derived from the key id and the media sequence number) or hexadecimal 16 bytes IV
+}
+
+type WidevineConfig struct {
+	Provider  string `json:",omitempty"` // Provider name given by the DRM provider (eg: "widevine_test")
+	ContentId string `json:",omitempty"` // Hexadecimal content identifier given by the DRM provider
+}
+
+type PlayReadyConfig struct {
+	LaUrl string `json:",omitempty"` // License acquisition URL (eg: "https://playready.example.com/rightsmanager.asmx")
 }
 
 type TrackEntry struct {
@@ -52,7 +110,11 @@ type TrackEntry struct {
 	Bandwidth uint64
 	File      string
 	Lang      string
+	Role      string      `json:",omitempty"` // DASH role of an audio track: main (default), alternate, commentary or description
+	Label     string      `json:",omitempty"` // Name of the track shown by the players (default: language and role)
 	Config    *DashConfig `json:",omitempty"`
+
+	TrackId uint32 `json:",omitempty"` // track_ID of the track in File, the sample tables of Config are the ones of its TRAK Box
 }
 
 type DashAudioEntry struct {
@@ -61,6 +123,20 @@ type DashAudioEntry struct {
 	SampleSize       uint16 // MP4A MP4 Box Info (eg: 16)
 	CompressionId    uint16 // MP4A MP4 Box Info (eg: 0)
 	SampleRate       uint32 // MP4A MP4 Box Info (eg: 3145728000)
+	// ESDS MP4 Box info, tracks packaged without them are 48 kHz stereo AAC LC ones
+	ObjectTypeIndication byte   `json:",omitempty"` // DecoderConfigDescriptor object type (eg: 0x40 for MPEG-4 audio)
+	AudioSpecificConfig  []byte `json:",omitempty"` // AAC AudioSpecificConfig of the DecoderSpecificInfo
+	ObjectType           byte   `json:",omitempty"` // AAC core audio object type (eg: 2 for AAC LC)
+	Sbr                  bool   `json:",omitempty"` // Spectral band replication (HE-AAC)
+	Ps                   bool   `json:",omitempty"` // Parametric stereo (HE-AACv2)
+	ChannelConfiguration byte   `json:",omitempty"` // AAC channel configuration (eg: 6 for 5.1)
+
+	// AC-3 / E-AC-3 / Opus / FLAC tracks
+	Codec string   `json:",omitempty"` // Sample entry type: mp4a if empty, ac-3, ec-3, Opus or fLaC
+	Dac3  *Dac3Box `json:",omitempty"` // DAC3 MP4 Box of ac-3 tracks
+	Dec3  *Dec3Box `json:",omitempty"` // DEC3 MP4 Box of ec-3 tracks
+	Dops  *DopsBox `json:",omitempty"` // DOPS MP4 Box of Opus tracks
+	Dfla  *DflaBox `json:",omitempty"` // DFLA MP4 Box of fLaC tracks
 }
 
 type DashVideoEntry struct {
@@ -85,13 +161,23 @@ type DashVideoEntry struct {
 	StssBoxSize          uint32
 	CttsBoxOffset        int64
 	CttsBoxSize          uint32
+
+	// HEVC / VP9 / AV1 / MPEG-4 Part 2 tracks
+	Codec string   `json:",omitempty"` // Sample entry type: avc1 (default), hvc1, hev1, vp09, av01 or mp4v
+	HvcC  *HvcCBox `json:",omitempty"` // HVCC MP4 Box with the VPS, SPS and PPS arrays, NalUnitSize is set from it
+	VpcC  *VpcCBox `json:",omitempty"` // VPCC MP4 Box of vp09 tracks
+	Av1C  *Av1CBox `json:",omitempty"` // AV1C MP4 Box of av01 tracks with the sequence header OBU
+	Esds  *EsdsBox `json:",omitempty"` // ESDS MP4 Box of mp4v tracks with the decoder config and specific info
+
+	ObjectTypeIndication   byte `json:",omitempty"` // DecoderConfigDescriptor object type of mp4v tracks (eg: 0x20 for MPEG-4 Part 2)
+	ProfileLevelIndication byte `json:",omitempty"` // MPEG-4 Part 2 profile and level (eg: 0xF5 for Advanced Simple Profile L5)
 }
 
 type DashConfig struct {
 	StszBoxOffset int64
-	StszBoxSize   uint32
+	StszBoxSize   uint64
 	MdatBoxOffset int64
-	MdatBoxSize   uint32  // MDAT MP4 Box Size
+	MdatBoxSize   uint64  // MDAT MP4 Box Size
 	Type          string  // "audio" || "video
 	Rate          int32   // Typically 0x00010000 (1.0)
 	Volume        int16   // Typically 0x0100 (Full Volume)
@@ -104,6 +190,39 @@ type DashConfig struct {
 
 	Audio *DashAudioEntry `json:",omitempty"`
 	Video *DashVideoEntry `json:",omitempty"`
+
+	Encryption *DashEncryption `json:"-"`          // Set at runtime from the JsonConfig DRM configuration
+	Protection *DashProtection `json:",omitempty"` // Samples already encrypted in the source file (encv/enca)
+
+	RawMp3 bool `json:",omitempty"` // Raw MPEG audio file (.mp3): the STSZ Box is rebuilt from the frame headers
+
+	// STSC and STCO (or CO64) MP4 Boxes locating the samples, without them the samples are contiguous from MdatBoxOffset
+	StscBoxOffset int64  `json:",omitempty"`
+	StscBoxSize   uint32 `json:",omitempty"`
+	StcoBoxOffset int64  `json:",omitempty"`
+	StcoBoxSize   uint32 `json:",omitempty"`
+	Co64BoxOffset int64  `json:",omitempty"`
+	Co64BoxSize   uint32 `json:",omitempty"`
+}
+
+type DashSegment struct {
+	Number      uint32 // Fragment number to give to CreateDashFragmentWithConf (eg: 1)
+	Time        uint64 // TFDT MP4 Box BaseMediaDecodeTime of the segment in Timescale unit (eg: 0)
+	Duration    uint64 // Segment duration in Timescale unit (eg: 480000)
+	SampleStart uint32 // Index of the first sample of the segment (eg: 0)
+	SampleCount uint32 // Number of samples in the segment (eg: 240)
+}
+
+// Sample tables of a track needed to build its fragments
+type dashSampleTables struct {
+	stss *StssBox
+	stsz StszBox
+	ctts *CttsBox
+	// Sample auxiliary information of a pre-encrypted track
+	auxInfo *SencBox
+	// Chunks of a track whose samples are not contiguous
+	stsc         *StscBox
+	chunkOffsets []uint64
 }
 
 type Mp4 struct {
@@ -112,6 +231,10 @@ type Mp4 struct {
 	IsVideo  bool
 	IsAudio  bool
 	Boxes    map[string][]interface{}
+
+	// Files with several tracks
+	Tracks  map[uint32]map[string][]interface{} // Boxes of each TRAK Box by track_ID
+	TrackId uint32                              // track_ID of the track when Boxes are the ones of a single track (see Track)
 }
 
 type ParentBox struct {
@@ -119,6 +242,13 @@ type ParentBox struct {
 	Size uint32
 }
 
+// TRAK Box read apart from the other ones of the file, so that the boxes of its track are not mixed with theirs
+type TrakBox struct {
+	Size    uint32
+	TrackId uint32
+	Boxes   map[string][]interface{}
+}
+
 type FtypBox struct {
 	Size             uint32
 	MajorBrand       [4]byte
@@ -438,11 +568,43 @@ type Hvc1Box struct {
 	ColorTableIndex      int16
 }
 
+// VP codec configuration box (VP Codec ISO Media File Format Binding 2.2)
+type VpcCBox struct {
+	Size                    uint32
+	Version                 byte // 1
+	Flags                   [3]byte
+	Profile                 byte
+	Level                   byte // Level * 10 (eg: 41 for level 4.1)
+	BitDepth                byte
+	ChromaSubsampling       byte // 0 and 1 for 4:2:0, 2 for 4:2:2, 3 for 4:4:4
+	VideoFullRangeFlag      bool
+	ColourPrimaries         byte // ISO/IEC 23091-2 values (eg: 1 for BT.709)
+	TransferCharacteristics byte
+	MatrixCoefficients      byte
+	CodecInitializationData []byte // Empty for VP8 and VP9
+}
+
+// AV1 codec configuration box (AV1 Codec ISO Media File Format Binding 2.3)
+type Av1CBox struct {
+	Size                     uint32
+	Version                  byte // Marker bit and version (eg: 0x81)
+	SeqProfile               byte
+	SeqLevelIdx0             byte
+	SeqTier0                 byte
+	HighBitdepth             bool
+	TwelveBit                bool
+	Monochrome               bool
+	ChromaSubsamplingX       bool
+	ChromaSubsamplingY       bool
+	ChromaSamplePosition     byte
+	InitialPresentationDelay byte   // initial_presentation_delay_present << 4 | initial_presentation_delay_minus_one
+	ConfigObus               []byte // Sequence header OBU and metadata OBUs
+}
+
 type HvcCNalUnitArray struct {
-	NalUnitType   uint8
-	NumNalus      uint16
-	NalUnitLength uint16
-	NalUnit       []byte
+	NalUnitType uint8 /* array_completeness << 7 | NAL_unit_type (eg: 32 for VPS, 33 for SPS, 34 for PPS) */
+	NumNalus    uint16
+	NalUnits    [][]byte
 }
 
 type HvcCBox struct {
@@ -461,7 +623,7 @@ type HvcCBox struct {
 	AvgFrameRate                        uint16
 	ConstantFrameRate                   uint8 /* bit(2) constantFrameRate bit(3) numTemporalLayers bit(1) temporalIdNested unsigned int(2) lengthSizeMinusOne */
 	NumOfArrary                         uint8
-	NalArray                            [3]HvcCNalUnitArray
+	NalArray                            []HvcCNalUnitArray
 }
 
 /* MPEG-4 Bit Rate Box
@@ -479,8 +641,62 @@ type EsdsBox struct {
 	Data    []byte /* Unkown for the moment ??? */
 }
 
+// AC-3 specific box (ETSI TS 102 366 F.4)
+type Dac3Box struct {
+	Size        uint32
+	Fscod       byte // Sample rate code (eg: 0 for 48 kHz)
+	Bsid        byte // Bit stream identification (eg: 8)
+	Bsmod       byte // Bit stream mode (eg: 0 for complete main)
+	Acmod       byte // Audio coding mode (eg: 7 for 3/2)
+	LfeOn       bool // Low frequency effects channel
+	BitRateCode byte // Bit rate code (eg: 15 for 448 kbit/s)
+}
+
+// Opus specific box (Encapsulation of Opus in ISO Base Media File Format 4.3.2)
+type DopsBox struct {
+	Size                 uint32
+	Version              byte
+	OutputChannelCount   byte
+	PreSkip              uint16 // Samples to discard at 48 kHz at the start of the decoded stream
+	InputSampleRate      uint32
+	OutputGain           int16
+	ChannelMappingFamily byte
+	StreamCount          byte   // Channel mapping table if ChannelMappingFamily != 0
+	CoupledCount         byte   // Channel mapping table if ChannelMappingFamily != 0
+	ChannelMapping       []byte // Channel mapping table if ChannelMappingFamily != 0
+}
+
+// FLAC specific box (FLAC in ISO Base Media File Format 3.3.2)
+type DflaBox struct {
+	Size           uint32
+	Version        byte
+	Flags          [3]byte
+	MetadataBlocks []byte // FLAC metadata blocks, the first one is STREAMINFO
+}
+
+// Enhanced AC-3 specific box (ETSI TS 102 366 F.6)
+type Dec3Box struct {
+	Size       uint32
+	DataRate   uint16 // Data rate in kbit/s
+	NumIndSub  byte   // Number of independent substreams minus one
+	Substreams []Dec3Substream
+	Extension  []byte // Trailing bytes after the substreams (eg: Atmos JOC flags)
+}
+
+type Dec3Substream struct {
+	Fscod     byte
+	Bsid      byte
+	Asvc      bool
+	Bsmod     byte
+	Acmod     byte
+	LfeOn     bool
+	NumDepSub byte   // Number of dependent substreams
+	ChanLoc   uint16 // Channel locations of the dependent substreams if NumDepSub > 0
+}
+
 type StscBox struct {
 	Size       uint32
+	Offset     int64
 	Version    byte
 	Flags      [3]byte
 	EntryCount uint32
@@ -512,12 +728,22 @@ type SdtpBox struct {
 
 type StcoBox struct {
 	Size        uint32
+	Offset      int64
 	Version     byte
 	Reserved    [3]byte
 	EntryCount  uint32
 	ChunkOffset []uint32
 }
 
+type Co64Box struct {
+	Size        uint32
+	Offset      int64
+	Version     byte
+	Reserved    [3]byte
+	EntryCount  uint32
+	ChunkOffset []uint64
+}
+
 /* MOOF SubBoxes */
 type MfhdBox struct {
 	Size           uint32
@@ -578,16 +804,166 @@ type SchmBox struct {
 	SchemeUri     string
 }
 
-type MdatBox struct {
+type TencBox struct {
+	Size                   uint32
+	Version                byte
+	Flags                  [3]byte
+	DefaultCryptByteBlock  byte // Version 1 only
+	DefaultSkipByteBlock   byte // Version 1 only
+	DefaultIsProtected     byte
+	DefaultPerSampleIvSize byte
+	DefaultKid             [16]byte
+	DefaultConstantIvSize  byte // Only if DefaultIsProtected == 1 and DefaultPerSampleIvSize == 0
+	DefaultConstantIv      []byte
+}
+
+type SencBox struct {
+	Size        uint32
+	Offset      int64 // Offset of the box data in the source file
+	Version     byte
+	Flags       [3]byte // 0x000002 if subsamples are used
+	SampleCount uint32
+	Samples     []SencSample
+}
+
+type SencSample struct {
+	Iv         []byte
+	Subsamples []SencSubsample
+}
+
+type SencSubsample struct {
+	BytesOfClearData     uint16
+	BytesOfProtectedData uint32
+}
+
+type SaizBox struct {
+	Size                  uint32
+	Offset                int64 // Offset of the box data in the source file
+	Version               byte
+	Flags                 [3]byte
+	DefaultSampleInfoSize byte
+	SampleCount           uint32
+	SampleInfoSize        []byte // Only if DefaultSampleInfoSize == 0
+}
+
+type SaioBox struct {
+	Size       uint32
+	Version    byte
+	Flags      [3]byte
+	EntryCount uint32
+	Offsets    []uint64
+}
+
+type SgpdBox struct {
+	Size          uint32
+	Version       byte
+	Flags         [3]byte
+	GroupingType  [4]byte
+	DefaultLength uint32 // Version 1 only
+	EntryCount    uint32
+	Entries       [][]byte // Sample group description entries (eg: SeigEntry Bytes)
+}
+
+type SbgpBox struct {
+	Size         uint32
+	Version      byte
+	Flags        [3]byte
+	GroupingType [4]byte
+	EntryCount   uint32
+	Entries      []SbgpEntry
+}
+
+type SbgpEntry struct {
+	SampleCount           uint32
+	GroupDescriptionIndex uint32 // Indexes of the fragment local descriptions begin at 0x10001
+}
+
+// CENC sample encryption information group entry ('seig')
+type SeigEntry struct {
+	CryptByteBlock  byte
+	SkipByteBlock   byte
+	IsProtected     byte
+	PerSampleIvSize byte
+	Kid             [16]byte
+	ConstantIvSize  byte // Only if IsProtected == 1 and PerSampleIvSize == 0
+	ConstantIv      []byte
+}
+
+type PsshBox struct {
 	Size     uint32
+	Version  byte
+	Flags    [3]byte
+	SystemId [16]byte
+	KidCount uint32     // Version 1 only
+	Kids     [][16]byte // Version 1 only
+	DataSize uint32
+	Data     []byte
+}
+
+type SidxBox struct {
+	Size                     uint32
+	Version                  byte
+	Flags                    [3]byte
+	ReferenceId              uint32
+	Timescale                uint32
+	EarliestPresentationTime uint64
+	FirstOffset              uint64
+	Reserved                 uint16
+	ReferenceCount           uint16
+	References               []SidxReference
+}
+
+type SidxReference struct {
+	ReferenceType      byte   // 0 for a media reference, 1 for a SIDX Box reference
+	ReferencedSize     uint32 // Size of the referenced subsegment in bytes
+	SubsegmentDuration uint32 // Duration of the referenced subsegment in Timescale unit
+	StartsWithSap      byte
+	SapType            byte
+	SapDeltaTime       uint32
+}
+
+type UuidBox struct {
+	Size     uint32
+	UserType [16]byte // Extended type of the box (eg: tfxd 6D1D9B05-42D5-44E6-80E2-141DAFF757B2)
+	Data     []byte
+}
+
+type MdatBox struct {
+	Size     uint64
 	Filename string
 	Offset   int64
+	Data     []byte // Samples when they are not read from Filename (eg: encrypted samples)
+
+	Chunks []MdatChunk // Runs of samples read from Filename when they are not contiguous (eg: interleaved tracks)
+}
+
+type MdatChunk struct {
+	Offset int64
+	Size   uint32
 }
 
 // ***
 // *** Private functions
 // ***
 
+// Sample entry type of a video track
+func videoSampleEntry(vConf DashVideoEntry) string {
+	if vConf.Codec == "" {
+		return "avc1"
+	}
+
+	return vConf.Codec
+}
+
+// Sample entry type of an audio track
+func audioSampleEntry(aConf DashAudioEntry) string {
+	if aConf.Codec == "" {
+		return "mp4a"
+	}
+
+	return aConf.Codec
+}
+
 // Dump a box structure if debugMode is true
 func dumpBox(boxPath string, box interface{}) {
 	if debugMode {
@@ -1464,7 +1840,13 @@ func (esdsv EsdsvBox) Bytes() (data []byte) {
 }
 
 func readMp4vBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
-	data := make([]byte, size)
+	if size < 78 {
+		// Skip a truncated sample entry, its children would be read from the following boxes
+		log.Printf("ERROR: %s box is too short (%d bytes)", boxPath, size)
+		f.Seek(int64(size), 1)
+		return
+	}
+	data := make([]byte, 78)
 	_, err := f.Read(data)
 	if err != nil {
 		panic(err)
@@ -1472,25 +1854,30 @@ func readMp4vBox(f *os.File, size uint32, level int, boxPath string, mp4 map[str
 
 	var mp4v Mp4vBox
 	mp4v.Size = size
-	mp4v.DataReferenceIndex = binary.BigEndian.Uint16(data[0:2])
-	mp4v.Version = binary.BigEndian.Uint16(data[2:4])
-	mp4v.RevisionLevel = binary.BigEndian.Uint16(data[4:6])
-	mp4v.Vendor = binary.BigEndian.Uint32(data[6:10])
-	mp4v.TempQuality = binary.BigEndian.Uint32(data[10:14])
-	mp4v.SpatialQuality = binary.BigEndian.Uint32(data[14:18])
-	mp4v.Width = binary.BigEndian.Uint16(data[18:20])
-	mp4v.Height = binary.BigEndian.Uint16(data[20:22])
-	mp4v.Horizresol = binary.BigEndian.Uint32(data[22:26])
-	mp4v.Vertiresol = binary.BigEndian.Uint32(data[26:30])
-	mp4v.Reserved2 = binary.BigEndian.Uint32(data[30:34])
-	mp4v.Predefined1 = binary.BigEndian.Uint16(data[34:36])
-	mp4v.NameLength = binary.BigEndian.Uint16(data[36:38])
-	copy(mp4v.CompressorName[0:], data[38:68])
-	mp4v.Depth = binary.BigEndian.Uint16(data[68:70])
-	mp4v.Predefined2 = binary.BigEndian.Uint16(data[70:72])
+	copy(mp4v.Reserved[0:6], data[0:6])
+	mp4v.DataReferenceIndex = binary.BigEndian.Uint16(data[6:8])
+	mp4v.Version = binary.BigEndian.Uint16(data[8:10])
+	mp4v.RevisionLevel = binary.BigEndian.Uint16(data[10:12])
+	mp4v.Vendor = binary.BigEndian.Uint32(data[12:16])
+	mp4v.TempQuality = binary.BigEndian.Uint32(data[16:20])
+	mp4v.SpatialQuality = binary.BigEndian.Uint32(data[20:24])
+	mp4v.Width = binary.BigEndian.Uint16(data[24:26])
+	mp4v.Height = binary.BigEndian.Uint16(data[26:28])
+	mp4v.Horizresol = binary.BigEndian.Uint32(data[28:32])
+	mp4v.Vertiresol = binary.BigEndian.Uint32(data[32:36])
+	mp4v.Reserved2 = binary.BigEndian.Uint32(data[36:40])
+	mp4v.Predefined1 = binary.BigEndian.Uint16(data[40:42])
+	mp4v.NameLength = binary.BigEndian.Uint16(data[42:44])
+	copy(mp4v.CompressorName[0:], data[44:74])
+	mp4v.Depth = binary.BigEndian.Uint16(data[74:76])
+	mp4v.Predefined2 = binary.BigEndian.Uint16(data[76:78])
 
 	addBox(mp4, boxPath, mp4v)
 	dumpBox(boxPath, mp4v)
+
+	// The decoder config is in an ESDS Box
+	readBoxes(f, size-78, level+1, boxPath, mp4)
+
 	return
 }
 
@@ -1551,6 +1938,230 @@ func (esds EsdsBox) Bytes() (data []byte) {
 	return
 }
 
+func readDac3Box(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	if len(data) < 3 {
+		return
+	}
+
+	var dac3 Dac3Box
+	dac3.Size = 3
+	dac3.Fscod = data[0] >> 6
+	dac3.Bsid = (data[0] >> 1) & 0x1F
+	dac3.Bsmod = ((data[0] & 0x01) << 2) | (data[1] >> 6)
+	dac3.Acmod = (data[1] >> 3) & 0x07
+	dac3.LfeOn = data[1]&0x04 != 0
+	dac3.BitRateCode = ((data[1] & 0x03) << 3) | (data[2] >> 5)
+
+	addBox(mp4, boxPath, dac3)
+	dumpBox(boxPath, dac3)
+
+	return
+}
+
+func (dac3 Dac3Box) Bytes() (data []byte) {
+	boxSize := dac3.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'d', 'a', 'c', '3'})
+	data[8] = (dac3.Fscod << 6) | ((dac3.Bsid & 0x1F) << 1) | ((dac3.Bsmod >> 2) & 0x01)
+	data[9] = (dac3.Bsmod << 6) | ((dac3.Acmod & 0x07) << 3) | ((dac3.BitRateCode >> 3) & 0x03)
+	if dac3.LfeOn {
+		data[9] |= 0x04
+	}
+	data[10] = dac3.BitRateCode << 5
+
+	return
+}
+
+func readDopsBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	if len(data) < 11 {
+		return
+	}
+
+	var dOps DopsBox
+	dOps.Size = 11
+	dOps.Version = data[0]
+	dOps.OutputChannelCount = data[1]
+	dOps.PreSkip = binary.BigEndian.Uint16(data[2:4])
+	dOps.InputSampleRate = binary.BigEndian.Uint32(data[4:8])
+	dOps.OutputGain = int16(binary.BigEndian.Uint16(data[8:10]))
+	dOps.ChannelMappingFamily = data[10]
+	if dOps.ChannelMappingFamily != 0 && len(data) >= 13+int(dOps.OutputChannelCount) {
+		dOps.StreamCount = data[11]
+		dOps.CoupledCount = data[12]
+		dOps.ChannelMapping = data[13 : 13+int(dOps.OutputChannelCount)]
+		dOps.Size += 2 + uint32(dOps.OutputChannelCount)
+	}
+
+	addBox(mp4, boxPath, dOps)
+	dumpBox(boxPath, dOps)
+
+	return
+}
+
+func (dOps DopsBox) Bytes() (data []byte) {
+	boxSize := dOps.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'d', 'O', 'p', 's'})
+	data[8] = dOps.Version
+	data[9] = dOps.OutputChannelCount
+	binary.BigEndian.PutUint16(data[10:12], dOps.PreSkip)
+	binary.BigEndian.PutUint32(data[12:16], dOps.InputSampleRate)
+	binary.BigEndian.PutUint16(data[16:18], uint16(dOps.OutputGain))
+	data[18] = dOps.ChannelMappingFamily
+	if dOps.ChannelMappingFamily != 0 {
+		data[19] = dOps.StreamCount
+		data[20] = dOps.CoupledCount
+		copy(data[21:], dOps.ChannelMapping)
+	}
+
+	return
+}
+
+func readDflaBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	if len(data) < 4 {
+		return
+	}
+
+	var dfLa DflaBox
+	dfLa.Size = size
+	dfLa.Version = data[0]
+	copy(dfLa.Flags[:], data[1:4])
+	dfLa.MetadataBlocks = data[4:]
+
+	addBox(mp4, boxPath, dfLa)
+	dumpBox(boxPath, dfLa)
+
+	return
+}
+
+func (dfLa DflaBox) Bytes() (data []byte) {
+	boxSize := dfLa.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'d', 'f', 'L', 'a'})
+	data[8] = dfLa.Version
+	copy(data[9:12], dfLa.Flags[:])
+	copy(data[12:], dfLa.MetadataBlocks)
+
+	return
+}
+
+// Number of channels of the STREAMINFO metadata block of a DFLA Box, 0 if it is missing
+func (dfLa DflaBox) Channels() uint16 {
+	// Metadata block header: last block flag (1 bit), type (7 bits), length (24 bits)
+	// STREAMINFO: block sizes (4 bytes), frame sizes (6 bytes), sample rate (20 bits), channels - 1 (3 bits)
+	if len(dfLa.MetadataBlocks) < 4+13 || dfLa.MetadataBlocks[0]&0x7F != 0 {
+		return 0
+	}
+
+	return uint16((dfLa.MetadataBlocks[4+12]>>1)&0x07) + 1
+}
+
+func readDec3Box(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	if len(data) < 2 {
+		return
+	}
+
+	var dec3 Dec3Box
+	dec3.DataRate = binary.BigEndian.Uint16(data[0:2]) >> 3
+	dec3.NumIndSub = data[1] & 0x07
+	offset := 2
+	for i := 0; i <= int(dec3.NumIndSub) && offset+3 <= len(data); i++ {
+		var substream Dec3Substream
+		substream.Fscod = data[offset] >> 6
+		substream.Bsid = (data[offset] >> 1) & 0x1F
+		substream.Asvc = data[offset+1]&0x80 != 0
+		substream.Bsmod = (data[offset+1] >> 4) & 0x07
+		substream.Acmod = (data[offset+1] >> 1) & 0x07
+		substream.LfeOn = data[offset+1]&0x01 != 0
+		substream.NumDepSub = (data[offset+2] >> 1) & 0x0F
+		if substream.NumDepSub > 0 && offset+4 <= len(data) {
+			substream.ChanLoc = (uint16(data[offset+2]&0x01) << 8) | uint16(data[offset+3])
+			offset += 4
+		} else {
+			offset += 3
+		}
+		dec3.Substreams = append(dec3.Substreams, substream)
+	}
+	dec3.Extension = data[offset:]
+	dec3.Size = dec3.computeSize()
+
+	addBox(mp4, boxPath, dec3)
+	dumpBox(boxPath, dec3)
+
+	return
+}
+
+// Size of a DEC3 Box computed from its substreams
+func (dec3 Dec3Box) computeSize() (size uint32) {
+	size = 2
+	for _, substream := range dec3.Substreams {
+		size += 3
+		if substream.NumDepSub > 0 {
+			size++
+		}
+	}
+	size += uint32(len(dec3.Extension))
+
+	return
+}
+
+func (dec3 Dec3Box) Bytes() (data []byte) {
+	boxSize := dec3.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'d', 'e', 'c', '3'})
+	binary.BigEndian.PutUint16(data[8:10], (dec3.DataRate<<3)|uint16(dec3.NumIndSub&0x07))
+	offset := 10
+	for _, substream := range dec3.Substreams {
+		data[offset] = (substream.Fscod << 6) | ((substream.Bsid & 0x1F) << 1)
+		data[offset+1] = ((substream.Bsmod & 0x07) << 4) | ((substream.Acmod & 0x07) << 1)
+		if substream.Asvc {
+			data[offset+1] |= 0x80
+		}
+		if substream.LfeOn {
+			data[offset+1] |= 0x01
+		}
+		data[offset+2] = (substream.NumDepSub & 0x0F) << 1
+		if substream.NumDepSub > 0 {
+			data[offset+2] |= byte(substream.ChanLoc>>8) & 0x01
+			data[offset+3] = byte(substream.ChanLoc)
+			offset += 4
+		} else {
+			offset += 3
+		}
+	}
+	copy(data[offset:], dec3.Extension)
+
+	return
+}
+
 func readAvc1Box(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
 	data := make([]byte, 78)
 	_, err := f.Read(data)
@@ -1728,30 +2339,20 @@ func readHvcCBox(f *os.File, size uint32, level int, boxPath string, mp4 map[str
 	hvcC.ConstantFrameRate = data[21]
 	hvcC.NumOfArrary = data[22]
 
-	hvcC.NalArray[0].NalUnitType = data[23]
-	hvcC.NalArray[0].NumNalus = binary.BigEndian.Uint16(data[24:26])
-	hvcC.NalArray[0].NalUnitLength = binary.BigEndian.Uint16(data[26:28])
-	offset = 28
-	hvcC.NalArray[0].NalUnit = data[offset : offset+uint32(hvcC.NalArray[0].NalUnitLength)]
-	offset += uint32(hvcC.NalArray[0].NalUnitLength)
-
-	hvcC.NalArray[1].NalUnitType = data[offset]
-	offset++
-	hvcC.NalArray[1].NumNalus = binary.BigEndian.Uint16(data[offset : offset+2])
-	offset += 2
-	hvcC.NalArray[1].NalUnitLength = binary.BigEndian.Uint16(data[offset : offset+2])
-	offset += 2
-	hvcC.NalArray[1].NalUnit = data[offset : offset+uint32(hvcC.NalArray[1].NalUnitLength)]
-	offset += uint32(hvcC.NalArray[1].NalUnitLength)
-
-	hvcC.NalArray[2].NalUnitType = data[offset]
-	offset++
-	hvcC.NalArray[2].NumNalus = binary.BigEndian.Uint16(data[offset : offset+2])
-	offset += 2
-	hvcC.NalArray[2].NalUnitLength = binary.BigEndian.Uint16(data[offset : offset+2])
-	offset += 2
-	hvcC.NalArray[2].NalUnit = data[offset : offset+uint32(hvcC.NalArray[2].NalUnitLength)]
-	offset += uint32(hvcC.NalArray[2].NalUnitLength)
+	offset = 23
+	hvcC.NalArray = make([]HvcCNalUnitArray, hvcC.NumOfArrary)
+	for i := range hvcC.NalArray {
+		hvcC.NalArray[i].NalUnitType = data[offset]
+		hvcC.NalArray[i].NumNalus = binary.BigEndian.Uint16(data[offset+1 : offset+3])
+		offset += 3
+		hvcC.NalArray[i].NalUnits = make([][]byte, hvcC.NalArray[i].NumNalus)
+		for j := range hvcC.NalArray[i].NalUnits {
+			nalUnitLength := uint32(binary.BigEndian.Uint16(data[offset : offset+2]))
+			offset += 2
+			hvcC.NalArray[i].NalUnits[j] = data[offset : offset+nalUnitLength]
+			offset += nalUnitLength
+		}
+	}
 
 	addBox(mp4, boxPath, hvcC)
 	dumpBox(boxPath, hvcC)
@@ -1759,6 +2360,11 @@ func readHvcCBox(f *os.File, size uint32, level int, boxPath string, mp4 map[str
 	return
 }
 
+// NAL unit length size minus one of an HEVC track, packed in the low bits of ConstantFrameRate
+func (hvcC HvcCBox) LengthSizeMinusOne() byte {
+	return hvcC.ConstantFrameRate & 0x03
+}
+
 func (hvcC HvcCBox) Bytes() (data []byte) {
 	var offset uint32
 	boxSize := hvcC.Size + 8
@@ -1779,43 +2385,163 @@ func (hvcC HvcCBox) Bytes() (data []byte) {
 	binary.BigEndian.PutUint16(data[27:29], hvcC.AvgFrameRate)
 	data[29] = hvcC.ConstantFrameRate
 	data[30] = hvcC.NumOfArrary
-	data[31] = hvcC.NalArray[0].NalUnitType
-	binary.BigEndian.PutUint16(data[32:34], hvcC.NalArray[0].NumNalus)
-	binary.BigEndian.PutUint16(data[34:36], hvcC.NalArray[0].NalUnitLength)
-	offset = 36
-	copy(data[offset:offset+uint32(hvcC.NalArray[0].NalUnitLength)], hvcC.NalArray[0].NalUnit)
-	offset += uint32(hvcC.NalArray[0].NalUnitLength)
-
-	data[offset] = hvcC.NalArray[1].NalUnitType
-	offset++
-	binary.BigEndian.PutUint16(data[offset:offset+2], hvcC.NalArray[1].NumNalus)
-	offset += 2
-	binary.BigEndian.PutUint16(data[offset:offset+2], hvcC.NalArray[1].NalUnitLength)
-	offset += 2
-	copy(data[offset:offset+uint32(hvcC.NalArray[1].NalUnitLength)], hvcC.NalArray[1].NalUnit)
-	offset += uint32(hvcC.NalArray[1].NalUnitLength)
+	offset = 31
+	for _, nalArray := range hvcC.NalArray {
+		data[offset] = nalArray.NalUnitType
+		binary.BigEndian.PutUint16(data[offset+1:offset+3], nalArray.NumNalus)
+		offset += 3
+		for _, nalUnit := range nalArray.NalUnits {
+			binary.BigEndian.PutUint16(data[offset:offset+2], uint16(len(nalUnit)))
+			offset += 2
+			copy(data[offset:offset+uint32(len(nalUnit))], nalUnit)
+			offset += uint32(len(nalUnit))
+		}
+	}
 
-	data[offset] = hvcC.NalArray[2].NalUnitType
-	offset++
-	binary.BigEndian.PutUint16(data[offset:offset+2], hvcC.NalArray[2].NumNalus)
-	offset += 2
-	binary.BigEndian.PutUint16(data[offset:offset+2], hvcC.NalArray[2].NalUnitLength)
-	offset += 2
-	copy(data[offset:offset+uint32(hvcC.NalArray[2].NalUnitLength)], hvcC.NalArray[2].NalUnit)
-	offset += uint32(hvcC.NalArray[2].NalUnitLength)
+	return
+}
+
+func readVpcCBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	if len(data) < 12 {
+		return
+	}
+
+	var vpcC VpcCBox
+	vpcC.Version = data[0]
+	copy(vpcC.Flags[:], data[1:4])
+	vpcC.Profile = data[4]
+	vpcC.Level = data[5]
+	vpcC.BitDepth = data[6] >> 4
+	vpcC.ChromaSubsampling = (data[6] >> 1) & 0x07
+	vpcC.VideoFullRangeFlag = data[6]&0x01 != 0
+	vpcC.ColourPrimaries = data[7]
+	vpcC.TransferCharacteristics = data[8]
+	vpcC.MatrixCoefficients = data[9]
+	initSize := int(binary.BigEndian.Uint16(data[10:12]))
+	if 12+initSize <= len(data) {
+		vpcC.CodecInitializationData = data[12 : 12+initSize]
+	}
+	vpcC.Size = 12 + uint32(len(vpcC.CodecInitializationData))
+
+	addBox(mp4, boxPath, vpcC)
+	dumpBox(boxPath, vpcC)
 
 	return
 }
 
-func (avcC AvcCBox) Bytes() (data []byte) {
-	var offset uint32
-	boxSize := avcC.Size + 8
+func (vpcC VpcCBox) Bytes() (data []byte) {
+	boxSize := vpcC.Size + 8
 	data = make([]byte, boxSize)
 
 	binary.BigEndian.PutUint32(data[0:4], boxSize)
-	copy(data[4:8], []byte{'a', 'v', 'c', 'C'})
-	data[8] = avcC.ConfigurationVersion
-	data[9] = avcC.AVCProfileIndication
+	copy(data[4:8], []byte{'v', 'p', 'c', 'C'})
+	data[8] = vpcC.Version
+	copy(data[9:12], vpcC.Flags[:])
+	data[12] = vpcC.Profile
+	data[13] = vpcC.Level
+	data[14] = (vpcC.BitDepth << 4) | ((vpcC.ChromaSubsampling & 0x07) << 1)
+	if vpcC.VideoFullRangeFlag {
+		data[14] |= 0x01
+	}
+	data[15] = vpcC.ColourPrimaries
+	data[16] = vpcC.TransferCharacteristics
+	data[17] = vpcC.MatrixCoefficients
+	binary.BigEndian.PutUint16(data[18:20], uint16(len(vpcC.CodecInitializationData)))
+	copy(data[20:], vpcC.CodecInitializationData)
+
+	return
+}
+
+func readAv1CBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	if len(data) < 4 {
+		return
+	}
+
+	var av1C Av1CBox
+	av1C.Size = size
+	av1C.Version = data[0]
+	av1C.SeqProfile = data[1] >> 5
+	av1C.SeqLevelIdx0 = data[1] & 0x1F
+	av1C.SeqTier0 = data[2] >> 7
+	av1C.HighBitdepth = data[2]&0x40 != 0
+	av1C.TwelveBit = data[2]&0x20 != 0
+	av1C.Monochrome = data[2]&0x10 != 0
+	av1C.ChromaSubsamplingX = data[2]&0x08 != 0
+	av1C.ChromaSubsamplingY = data[2]&0x04 != 0
+	av1C.ChromaSamplePosition = data[2] & 0x03
+	av1C.InitialPresentationDelay = data[3] & 0x1F
+	av1C.ConfigObus = data[4:]
+
+	addBox(mp4, boxPath, av1C)
+	dumpBox(boxPath, av1C)
+
+	return
+}
+
+func (av1C Av1CBox) Bytes() (data []byte) {
+	boxSize := av1C.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'a', 'v', '1', 'C'})
+	data[8] = av1C.Version
+	data[9] = (av1C.SeqProfile << 5) | (av1C.SeqLevelIdx0 & 0x1F)
+	data[10] = (av1C.SeqTier0 << 7) | (av1C.ChromaSamplePosition & 0x03)
+	for i, flag := range []bool{av1C.HighBitdepth, av1C.TwelveBit, av1C.Monochrome, av1C.ChromaSubsamplingX, av1C.ChromaSubsamplingY} {
+		if flag {
+			data[10] |= 0x40 >> uint(i)
+		}
+	}
+	data[11] = av1C.InitialPresentationDelay & 0x1F
+	copy(data[12:], av1C.ConfigObus)
+
+	return
+}
+
+// Bit depth of an AV1 track: 8, 10 or 12
+func (av1C Av1CBox) BitDepth() int {
+	switch {
+	case av1C.HighBitdepth && av1C.TwelveBit:
+		return 12
+	case av1C.HighBitdepth:
+		return 10
+	}
+
+	return 8
+}
+
+// Size of an HVCC Box computed from its NAL unit arrays
+func (hvcC HvcCBox) computeSize() (size uint32) {
+	size = 23
+	for _, nalArray := range hvcC.NalArray {
+		size += 3
+		for _, nalUnit := range nalArray.NalUnits {
+			size += 2 + uint32(len(nalUnit))
+		}
+	}
+
+	return
+}
+
+func (avcC AvcCBox) Bytes() (data []byte) {
+	var offset uint32
+	boxSize := avcC.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'a', 'v', 'c', 'C'})
+	data[8] = avcC.ConfigurationVersion
+	data[9] = avcC.AVCProfileIndication
 	data[10] = avcC.ProfileCompatibility
 	data[11] = avcC.AVCLevelIndication
 	data[12] = avcC.NalUnitSize
@@ -1866,13 +2592,14 @@ func (btrt BtrtBox) Bytes() (data []byte) {
 }
 
 func readStscBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	var stsc StscBox
+	stsc.Offset, _ = f.Seek(0, os.SEEK_CUR)
 	data := make([]byte, size)
 	_, err := f.Read(data)
 	if err != nil {
 		panic(err)
 	}
 
-	var stsc StscBox
 	stsc.Size = size
 	stsc.Version = data[0]
 	copy(stsc.Flags[:], data[1:4])
@@ -2008,16 +2735,20 @@ func (sdtp SdtpBox) Bytes() (data []byte) {
 }
 
 func readStcoBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	var stco StcoBox
+	stco.Offset, _ = f.Seek(0, os.SEEK_CUR)
 	data := make([]byte, size)
 	_, err := f.Read(data)
 	if err != nil {
 		panic(err)
 	}
-	var stco StcoBox
 	stco.Size = size
 	stco.Version = data[0]
 	copy(stco.Reserved[:], data[1:4])
 	stco.EntryCount = binary.BigEndian.Uint32(data[4:8])
+	if entryCount := (size - 8) >> 2; entryCount < stco.EntryCount {
+		stco.EntryCount = entryCount
+	}
 	if stco.EntryCount > 0 {
 		stco.ChunkOffset = make([]uint32, stco.EntryCount)
 		var i uint32
@@ -2050,6 +2781,53 @@ func (stco StcoBox) Bytes() (data []byte) {
 	return
 }
 
+func readCo64Box(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	var co64 Co64Box
+	co64.Offset, _ = f.Seek(0, os.SEEK_CUR)
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	co64.Size = size
+	co64.Version = data[0]
+	copy(co64.Reserved[:], data[1:4])
+	co64.EntryCount = binary.BigEndian.Uint32(data[4:8])
+	if entryCount := (size - 8) >> 3; entryCount < co64.EntryCount {
+		co64.EntryCount = entryCount
+	}
+	if co64.EntryCount > 0 {
+		co64.ChunkOffset = make([]uint64, co64.EntryCount)
+		var i uint32
+		for i = 0; i < co64.EntryCount; i++ {
+			co64.ChunkOffset[i] = binary.BigEndian.Uint64(data[8+(i*8) : 16+(i*8)])
+		}
+	}
+	addBox(mp4, boxPath, co64)
+	dumpBox(boxPath, co64)
+}
+
+func (co64 Co64Box) Bytes() (data []byte) {
+	var offset uint32
+	boxSize := co64.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'c', 'o', '6', '4'})
+	data[8] = co64.Version
+	copy(data[9:12], co64.Reserved[:])
+	binary.BigEndian.PutUint32(data[12:16], co64.EntryCount)
+	offset = 16
+	if co64.EntryCount > 0 {
+		for _, v := range co64.ChunkOffset {
+			binary.BigEndian.PutUint64(data[offset:offset+8], v)
+			offset += 8
+		}
+	}
+
+	return
+}
+
 func readSttsBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
 	data := make([]byte, size)
 	_, err := f.Read(data)
@@ -2564,7 +3342,400 @@ func (schm SchmBox) Bytes() (data []byte) {
 	return
 }
 
-func readMdatBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+func readTencBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	var tenc TencBox
+	tenc.Size = size
+	tenc.Version = data[0]
+	copy(tenc.Flags[:], data[1:4])
+	if tenc.Version > 0 {
+		tenc.DefaultCryptByteBlock = data[5] >> 4
+		tenc.DefaultSkipByteBlock = data[5] & 0x0F
+	}
+	tenc.DefaultIsProtected = data[6]
+	tenc.DefaultPerSampleIvSize = data[7]
+	copy(tenc.DefaultKid[:], data[8:24])
+	if tenc.DefaultIsProtected == 1 && tenc.DefaultPerSampleIvSize == 0 && size > 24 {
+		tenc.DefaultConstantIvSize = data[24]
+		tenc.DefaultConstantIv = data[25 : 25+int(tenc.DefaultConstantIvSize)]
+	}
+	addBox(mp4, boxPath, tenc)
+	dumpBox(boxPath, tenc)
+}
+
+func (tenc TencBox) Bytes() (data []byte) {
+	boxSize := tenc.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'t', 'e', 'n', 'c'})
+	data[8] = tenc.Version
+	copy(data[9:12], tenc.Flags[:])
+	data[12] = 0
+	if tenc.Version > 0 {
+		data[13] = (tenc.DefaultCryptByteBlock << 4) | (tenc.DefaultSkipByteBlock & 0x0F)
+	}
+	data[14] = tenc.DefaultIsProtected
+	data[15] = tenc.DefaultPerSampleIvSize
+	copy(data[16:32], tenc.DefaultKid[:])
+	if tenc.DefaultIsProtected == 1 && tenc.DefaultPerSampleIvSize == 0 {
+		data[32] = tenc.DefaultConstantIvSize
+		copy(data[33:], tenc.DefaultConstantIv)
+	}
+
+	return
+}
+
+// Only the header of SENC Box is read, the sample auxiliary information needs the per sample IV size of TENC Box
+// (see readSampleAuxInfoWithConf)
+func readSencBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	var senc SencBox
+	senc.Offset, _ = f.Seek(0, os.SEEK_CUR)
+	data := make([]byte, 8)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	f.Seek(int64(size-8), 1)
+	senc.Size = size
+	senc.Version = data[0]
+	copy(senc.Flags[:], data[1:4])
+	senc.SampleCount = binary.BigEndian.Uint32(data[4:8])
+	addBox(mp4, boxPath, senc)
+	dumpBox(boxPath, senc)
+}
+
+func (senc SencBox) Bytes() (data []byte) {
+	boxSize := senc.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'s', 'e', 'n', 'c'})
+	data[8] = senc.Version
+	copy(data[9:12], senc.Flags[:])
+	binary.BigEndian.PutUint32(data[12:16], senc.SampleCount)
+	dataOffset := 16
+	for _, sample := range senc.Samples {
+		copy(data[dataOffset:], sample.Iv)
+		dataOffset += len(sample.Iv)
+		if senc.Flags[2]&0x02 != 0 {
+			binary.BigEndian.PutUint16(data[dataOffset:dataOffset+2], uint16(len(sample.Subsamples)))
+			dataOffset += 2
+			for _, subsample := range sample.Subsamples {
+				binary.BigEndian.PutUint16(data[dataOffset:dataOffset+2], subsample.BytesOfClearData)
+				binary.BigEndian.PutUint32(data[dataOffset+2:dataOffset+6], subsample.BytesOfProtectedData)
+				dataOffset += 6
+			}
+		}
+	}
+
+	return
+}
+
+func readSaizBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	var saiz SaizBox
+	saiz.Offset, _ = f.Seek(0, os.SEEK_CUR)
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	saiz.Size = size
+	saiz.Version = data[0]
+	copy(saiz.Flags[:], data[1:4])
+	offset := uint32(4)
+	if saiz.Flags[2]&0x01 != 0 {
+		// Skip aux_info_type and aux_info_type_parameter
+		offset += 8
+	}
+	saiz.DefaultSampleInfoSize = data[offset]
+	saiz.SampleCount = binary.BigEndian.Uint32(data[offset+1 : offset+5])
+	offset += 5
+	if saiz.DefaultSampleInfoSize == 0 {
+		// The box can be read only until a given sample
+		if size-offset < saiz.SampleCount {
+			saiz.SampleCount = size - offset
+		}
+		saiz.SampleInfoSize = data[offset : offset+saiz.SampleCount]
+	}
+	addBox(mp4, boxPath, saiz)
+	dumpBox(boxPath, saiz)
+}
+
+func (saiz SaizBox) Bytes() (data []byte) {
+	boxSize := saiz.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'s', 'a', 'i', 'z'})
+	data[8] = saiz.Version
+	copy(data[9:12], saiz.Flags[:])
+	data[12] = saiz.DefaultSampleInfoSize
+	binary.BigEndian.PutUint32(data[13:17], saiz.SampleCount)
+	if saiz.DefaultSampleInfoSize == 0 {
+		copy(data[17:], saiz.SampleInfoSize)
+	}
+
+	return
+}
+
+func readSaioBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	var saio SaioBox
+	saio.Size = size
+	saio.Version = data[0]
+	copy(saio.Flags[:], data[1:4])
+	offset := uint32(4)
+	if saio.Flags[2]&0x01 != 0 {
+		// Skip aux_info_type and aux_info_type_parameter
+		offset += 8
+	}
+	saio.EntryCount = binary.BigEndian.Uint32(data[offset : offset+4])
+	offset += 4
+	for i := uint32(0); i < saio.EntryCount && offset < size; i++ {
+		if saio.Version == 0 {
+			saio.Offsets = append(saio.Offsets, uint64(binary.BigEndian.Uint32(data[offset:offset+4])))
+			offset += 4
+		} else {
+			saio.Offsets = append(saio.Offsets, binary.BigEndian.Uint64(data[offset:offset+8]))
+			offset += 8
+		}
+	}
+	addBox(mp4, boxPath, saio)
+	dumpBox(boxPath, saio)
+}
+
+func (saio SaioBox) Bytes() (data []byte) {
+	boxSize := saio.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'s', 'a', 'i', 'o'})
+	data[8] = saio.Version
+	copy(data[9:12], saio.Flags[:])
+	binary.BigEndian.PutUint32(data[12:16], saio.EntryCount)
+	dataOffset := 16
+	for _, offset := range saio.Offsets {
+		if saio.Version == 0 {
+			binary.BigEndian.PutUint32(data[dataOffset:dataOffset+4], uint32(offset))
+			dataOffset += 4
+		} else {
+			binary.BigEndian.PutUint64(data[dataOffset:dataOffset+8], offset)
+			dataOffset += 8
+		}
+	}
+
+	return
+}
+
+func (sgpd SgpdBox) Bytes() (data []byte) {
+	boxSize := sgpd.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'s', 'g', 'p', 'd'})
+	data[8] = sgpd.Version
+	copy(data[9:12], sgpd.Flags[:])
+	copy(data[12:16], sgpd.GroupingType[:])
+	offset := 16
+	if sgpd.Version == 1 {
+		binary.BigEndian.PutUint32(data[offset:offset+4], sgpd.DefaultLength)
+		offset += 4
+	}
+	binary.BigEndian.PutUint32(data[offset:offset+4], sgpd.EntryCount)
+	offset += 4
+	for _, entry := range sgpd.Entries {
+		if sgpd.Version == 1 && sgpd.DefaultLength == 0 {
+			binary.BigEndian.PutUint32(data[offset:offset+4], uint32(len(entry)))
+			offset += 4
+		}
+		copy(data[offset:], entry)
+		offset += len(entry)
+	}
+
+	return
+}
+
+func (sbgp SbgpBox) Bytes() (data []byte) {
+	boxSize := sbgp.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'s', 'b', 'g', 'p'})
+	data[8] = sbgp.Version
+	copy(data[9:12], sbgp.Flags[:])
+	copy(data[12:16], sbgp.GroupingType[:])
+	binary.BigEndian.PutUint32(data[16:20], sbgp.EntryCount)
+	offset := 20
+	for _, entry := range sbgp.Entries {
+		binary.BigEndian.PutUint32(data[offset:offset+4], entry.SampleCount)
+		binary.BigEndian.PutUint32(data[offset+4:offset+8], entry.GroupDescriptionIndex)
+		offset += 8
+	}
+
+	return
+}
+
+func (seig SeigEntry) Bytes() (data []byte) {
+	data = make([]byte, 20)
+	data[1] = (seig.CryptByteBlock << 4) | (seig.SkipByteBlock & 0x0F)
+	data[2] = seig.IsProtected
+	data[3] = seig.PerSampleIvSize
+	copy(data[4:20], seig.Kid[:])
+	if seig.IsProtected == 1 && seig.PerSampleIvSize == 0 {
+		data = append(data, seig.ConstantIvSize)
+		data = append(data, seig.ConstantIv...)
+	}
+
+	return
+}
+
+func readPsshBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	var pssh PsshBox
+	pssh.Size = size
+	pssh.Version = data[0]
+	copy(pssh.Flags[:], data[1:4])
+	copy(pssh.SystemId[:], data[4:20])
+	offset := uint32(20)
+	if pssh.Version > 0 {
+		pssh.KidCount = binary.BigEndian.Uint32(data[offset : offset+4])
+		offset += 4
+		pssh.Kids = make([][16]byte, pssh.KidCount)
+		for i := range pssh.Kids {
+			copy(pssh.Kids[i][:], data[offset:offset+16])
+			offset += 16
+		}
+	}
+	pssh.DataSize = binary.BigEndian.Uint32(data[offset : offset+4])
+	offset += 4
+	pssh.Data = data[offset : offset+pssh.DataSize]
+	addBox(mp4, boxPath, pssh)
+	dumpBox(boxPath, pssh)
+}
+
+func (pssh PsshBox) Bytes() (data []byte) {
+	boxSize := pssh.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'p', 's', 's', 'h'})
+	data[8] = pssh.Version
+	copy(data[9:12], pssh.Flags[:])
+	copy(data[12:28], pssh.SystemId[:])
+	offset := 28
+	if pssh.Version > 0 {
+		binary.BigEndian.PutUint32(data[offset:offset+4], pssh.KidCount)
+		offset += 4
+		for _, kid := range pssh.Kids {
+			copy(data[offset:offset+16], kid[:])
+			offset += 16
+		}
+	}
+	binary.BigEndian.PutUint32(data[offset:offset+4], pssh.DataSize)
+	copy(data[offset+4:], pssh.Data)
+
+	return
+}
+
+func readSidxBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	var offset uint32
+	data := make([]byte, size)
+	_, err := f.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	var sidx SidxBox
+	sidx.Size = size
+	sidx.Version = data[0]
+	copy(sidx.Flags[:], data[1:4])
+	sidx.ReferenceId = binary.BigEndian.Uint32(data[4:8])
+	sidx.Timescale = binary.BigEndian.Uint32(data[8:12])
+	offset = 12
+	if sidx.Version == 0 {
+		sidx.EarliestPresentationTime = uint64(binary.BigEndian.Uint32(data[offset : offset+4]))
+		sidx.FirstOffset = uint64(binary.BigEndian.Uint32(data[offset+4 : offset+8]))
+		offset += 8
+	} else {
+		sidx.EarliestPresentationTime = binary.BigEndian.Uint64(data[offset : offset+8])
+		sidx.FirstOffset = binary.BigEndian.Uint64(data[offset+8 : offset+16])
+		offset += 16
+	}
+	sidx.Reserved = binary.BigEndian.Uint16(data[offset : offset+2])
+	sidx.ReferenceCount = binary.BigEndian.Uint16(data[offset+2 : offset+4])
+	offset += 4
+	sidx.References = make([]SidxReference, sidx.ReferenceCount)
+	for i := range sidx.References {
+		sidx.References[i].ReferenceType = data[offset] >> 7
+		sidx.References[i].ReferencedSize = binary.BigEndian.Uint32(data[offset:offset+4]) & 0x7FFFFFFF
+		sidx.References[i].SubsegmentDuration = binary.BigEndian.Uint32(data[offset+4 : offset+8])
+		sidx.References[i].StartsWithSap = data[offset+8] >> 7
+		sidx.References[i].SapType = (data[offset+8] >> 4) & 0x07
+		sidx.References[i].SapDeltaTime = binary.BigEndian.Uint32(data[offset+8:offset+12]) & 0x0FFFFFFF
+		offset += 12
+	}
+	addBox(mp4, boxPath, sidx)
+	dumpBox(boxPath, sidx)
+}
+
+func (sidx SidxBox) Bytes() (data []byte) {
+	boxSize := sidx.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'s', 'i', 'd', 'x'})
+	data[8] = sidx.Version
+	copy(data[9:12], sidx.Flags[:])
+	binary.BigEndian.PutUint32(data[12:16], sidx.ReferenceId)
+	binary.BigEndian.PutUint32(data[16:20], sidx.Timescale)
+	dataOffset := 20
+	if sidx.Version == 0 {
+		binary.BigEndian.PutUint32(data[dataOffset:dataOffset+4], uint32(sidx.EarliestPresentationTime))
+		binary.BigEndian.PutUint32(data[dataOffset+4:dataOffset+8], uint32(sidx.FirstOffset))
+		dataOffset += 8
+	} else {
+		binary.BigEndian.PutUint64(data[dataOffset:dataOffset+8], sidx.EarliestPresentationTime)
+		binary.BigEndian.PutUint64(data[dataOffset+8:dataOffset+16], sidx.FirstOffset)
+		dataOffset += 16
+	}
+	binary.BigEndian.PutUint16(data[dataOffset:dataOffset+2], sidx.Reserved)
+	binary.BigEndian.PutUint16(data[dataOffset+2:dataOffset+4], sidx.ReferenceCount)
+	dataOffset += 4
+	for _, reference := range sidx.References {
+		binary.BigEndian.PutUint32(data[dataOffset:dataOffset+4], (uint32(reference.ReferenceType)<<31)|(reference.ReferencedSize&0x7FFFFFFF))
+		binary.BigEndian.PutUint32(data[dataOffset+4:dataOffset+8], reference.SubsegmentDuration)
+		binary.BigEndian.PutUint32(data[dataOffset+8:dataOffset+12], (uint32(reference.StartsWithSap)<<31)|(uint32(reference.SapType&0x07)<<28)|(reference.SapDeltaTime&0x0FFFFFFF))
+		dataOffset += 12
+	}
+
+	return
+}
+
+func (uuid UuidBox) Bytes() (data []byte) {
+	boxSize := uuid.Size + 8
+	data = make([]byte, boxSize)
+
+	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	copy(data[4:8], []byte{'u', 'u', 'i', 'd'})
+	copy(data[8:24], uuid.UserType[:])
+	copy(data[24:], uuid.Data)
+
+	return
+}
+
+func readMdatBox(f *os.File, size uint64, level int, boxPath string, mp4 map[string][]interface{}) {
 	var mdat MdatBox
 
 	mdat.Size = size
@@ -2584,16 +3755,42 @@ func readMdatBox(f *os.File, size uint32, level int, boxPath string, mp4 map[str
 	dumpBox(boxPath, mdat)
 }
 
+// The MDAT Box header has a 64 bits largesize when the samples don't fit in a 32 bits size
 func (mdat MdatBox) Bytes() (data []byte) {
-	boxSize := mdat.Size + 8
+	headerSize := uint64(8)
+	if mdat.Size+headerSize > math.MaxUint32 {
+		headerSize = 16
+	}
+	boxSize := mdat.Size + headerSize
 	data = make([]byte, boxSize)
-	binary.BigEndian.PutUint32(data[0:4], boxSize)
+	if headerSize == 16 {
+		binary.BigEndian.PutUint32(data[0:4], 1)
+		binary.BigEndian.PutUint64(data[8:16], boxSize)
+	} else {
+		binary.BigEndian.PutUint32(data[0:4], uint32(boxSize))
+	}
 	copy(data[4:8], []byte{'m', 'd', 'a', 't'})
+	if mdat.Data != nil {
+		copy(data[headerSize:], mdat.Data)
+		return
+	}
 	f, err := os.Open(mdat.Filename)
 	if err != nil {
 		panic(err)
 	}
-	_, err = f.ReadAt(data[8:], mdat.Offset)
+	defer f.Close()
+	if mdat.Chunks != nil {
+		offset := headerSize
+		for _, chunk := range mdat.Chunks {
+			_, err = f.ReadAt(data[offset:offset+uint64(chunk.Size)], chunk.Offset)
+			if err != nil {
+				panic(err)
+			}
+			offset += uint64(chunk.Size)
+		}
+		return
+	}
+	_, err = f.ReadAt(data[headerSize:], mdat.Offset)
 	if err != nil {
 		panic(err)
 	}
@@ -2601,16 +3798,41 @@ func (mdat MdatBox) Bytes() (data []byte) {
 	return
 }
 
-// Read 8 bytes Box (4 bytes size and 4 bytes box name)
-func readBox(f *os.File, level int) (boxSize uint32, boxName string) {
+// Read a TRAK Box, its boxes are added to the file ones and kept in a map of their own by the TrakBox
+func readTrakBox(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
+	var trak TrakBox
+	trak.Size = size + 8
+	trak.Boxes = make(map[string][]interface{})
+	readBoxes(f, size, level, boxPath, trak.Boxes)
+	if trak.Boxes["moov.trak.tkhd"] != nil {
+		trak.TrackId = trak.Boxes["moov.trak.tkhd"][0].(TkhdBox).TrackID
+	}
+	for path, boxes := range trak.Boxes {
+		mp4[path] = append(mp4[path], boxes...)
+	}
+	addBox(mp4, boxPath, trak)
+}
+
+// Read a Box header: 4 bytes size and 4 bytes box name, followed by a 8 bytes
+// largesize when size is 1. A size of 0 means the box extends to the end of its parent.
+func readBox(f *os.File, level int) (boxSize uint64, headerSize uint64, boxName string) {
 	data := make([]byte, 8)
 	_, err := f.Read(data)
 	if err != nil {
 		panic(err)
 	}
 
-	boxSize = binary.BigEndian.Uint32(data[0:4])
+	boxSize = uint64(binary.BigEndian.Uint32(data[0:4]))
 	boxName = string(data[4:])
+	headerSize = 8
+	if boxSize == 1 {
+		_, err = f.Read(data)
+		if err != nil {
+			panic(err)
+		}
+		boxSize = binary.BigEndian.Uint64(data)
+		headerSize = 16
+	}
 
 	if debugMode {
 		log.Printf("( off %.8d ) [%s%s]", boxSize, strings.Repeat("*", level*2), boxName)
@@ -2620,11 +3842,21 @@ func readBox(f *os.File, level int) (boxSize uint32, boxName string) {
 }
 
 func readBoxes(f *os.File, size uint32, level int, boxPath string, mp4 map[string][]interface{}) {
-	var offset uint32
+	readLargeBoxes(f, uint64(size), level, boxPath, mp4)
+}
+
+// Read the boxes of a file or of a parent box whose size may not fit in 32 bits.
+// Readers registered with a uint64 size (mdat) get the whole box, the others are
+// skipped if their box is larger than 4 GB.
+func readLargeBoxes(f *os.File, size uint64, level int, boxPath string, mp4 map[string][]interface{}) {
+	var offset uint64
 	offset = 0
 
 	for offset < size {
-		boxSize, boxName := readBox(f, level)
+		boxSize, headerSize, boxName := readBox(f, level)
+		if boxSize == 0 {
+			boxSize = size - offset
+		}
 		var boxFullPath string
 		if boxPath == "" {
 			boxFullPath = boxName
@@ -2632,24 +3864,30 @@ func readBoxes(f *os.File, size uint32, level int, boxPath string, mp4 map[strin
 			boxFullPath = boxPath + "." + boxName
 		}
 
-		if funcBoxes[boxFullPath] != nil {
-			fb := reflect.ValueOf(funcBoxes[boxFullPath])
+		switch callFunc := funcBoxes[boxFullPath].(type) {
+		case func(*os.File, uint64, int, string, map[string][]interface{}):
+			callFunc(f, boxSize-headerSize, level+1, boxFullPath, mp4)
+		case func(*os.File, uint32, int, string, map[string][]interface{}):
+			if boxSize-headerSize > math.MaxUint32 {
+				log.Printf("ERROR: %s box is too large (%d bytes)", boxFullPath, boxSize)
+				f.Seek(int64(boxSize-headerSize), 1)
+				break
+			}
+			fb := reflect.ValueOf(callFunc)
 			rb := reflect.ValueOf(readBoxes)
 			if fb.Pointer() == rb.Pointer() {
 				var box ParentBox
 				copy(box.Name[:], []byte(boxName)[0:4])
-				box.Size = boxSize
+				box.Size = uint32(boxSize)
 				addBox(mp4, boxFullPath, box)
 			}
-			var callFunc func(*os.File, uint32, int, string, map[string][]interface{})
-			callFunc = funcBoxes[boxFullPath].(func(*os.File, uint32, int, string, map[string][]interface{}))
-			callFunc(f, boxSize-8, level+1, boxFullPath, mp4)
-		} else {
+			callFunc(f, uint32(boxSize-headerSize), level+1, boxFullPath, mp4)
+		default:
 			// Skip box because we don't know how to decode it
 			if debugMode {
 				log.Printf("ERROR: Unknown %s box", boxPath)
 			}
-			f.Seek(int64(boxSize-8), 1)
+			f.Seek(int64(boxSize-headerSize), 1)
 		}
 
 		offset += boxSize
@@ -2659,7 +3897,7 @@ func readBoxes(f *os.File, size uint32, level int, boxPath string, mp4 map[strin
 func writeBox(f *os.File, boxName [4]byte, box interface{}) {
 	var size uint32
 	size = uint32(binary.Size(box))
-	log.Printf("size of box is %u", size)
+	log.Printf("size of box is %d", size)
 	err := binary.Write(f, binary.BigEndian, size)
 	if err != nil {
 		log.Printf("cannot write box size: %v", err)
@@ -2677,6 +3915,189 @@ func writeBox(f *os.File, boxName [4]byte, box interface{}) {
 	}
 }
 
+// Read the STSS Box of a video track described by a DashConfig
+func readStssBoxWithConf(f *os.File, dConf DashConfig, mp4 map[string][]interface{}) *StssBox {
+	f.Seek(dConf.Video.StssBoxOffset, 0)
+	readStssBox(f, dConf.Video.StssBoxSize, 0, "moov.trak.mdia.minf.stbl.stss", mp4)
+	stss := mp4["moov.trak.mdia.minf.stbl.stss"][0].(StssBox)
+
+	return &stss
+}
+
+// Read the number of samples from the STSZ Box header of a track described by a DashConfig
+func readSampleCountWithConf(f *os.File, dConf DashConfig) (sampleCount uint32) {
+	if dConf.RawMp3 {
+		return uint32((dConf.StszBoxSize - 12) >> 2)
+	}
+	data := make([]byte, 12)
+	_, err := f.ReadAt(data, dConf.StszBoxOffset)
+	if err != nil {
+		return
+	}
+	sampleCount = binary.BigEndian.Uint32(data[8:12])
+
+	return
+}
+
+// Read the STSZ Box of a track described by a DashConfig until the sample sampleCount
+func readStszBoxWithConf(f *os.File, dConf DashConfig, sampleCount uint32) StszBox {
+	if dConf.RawMp3 {
+		return readMp3StszBox(f, dConf, sampleCount)
+	}
+	stszSize := 12 + (uint64(sampleCount) * 4)
+	if stszSize > dConf.StszBoxSize {
+		stszSize = dConf.StszBoxSize
+	}
+	mp4 := make(map[string][]interface{})
+	f.Seek(dConf.StszBoxOffset, 0)
+	readStszBox(f, uint32(stszSize), 0, "moov.trak.mdia.minf.stbl.stsz", mp4)
+
+	return mp4["moov.trak.mdia.minf.stbl.stsz"][0].(StszBox)
+}
+
+// Read the STSC Box and the chunk offsets of the STCO or CO64 Box of a track described by a DashConfig until
+// the chunk of the sample sampleCount, both are nil for the tracks packaged with contiguous samples
+func readChunkTablesWithConf(f *os.File, dConf DashConfig, sampleCount uint32) (stsc *StscBox, chunkOffsets []uint64) {
+	if dConf.StscBoxOffset == 0 || (dConf.StcoBoxOffset == 0 && dConf.Co64BoxOffset == 0) {
+		return
+	}
+	mp4 := make(map[string][]interface{})
+	f.Seek(dConf.StscBoxOffset, 0)
+	readStscBox(f, dConf.StscBoxSize, 0, "moov.trak.mdia.minf.stbl.stsc", mp4)
+	stscBox := mp4["moov.trak.mdia.minf.stbl.stsc"][0].(StscBox)
+	lastChunk := uint64(sampleChunk(stscBox, sampleCount))
+
+	// Read STCO or CO64 Box only until the chunk of the last sample
+	if dConf.Co64BoxOffset != 0 {
+		co64Size := 8 + (lastChunk * 8)
+		if co64Size > uint64(dConf.Co64BoxSize) {
+			co64Size = uint64(dConf.Co64BoxSize)
+		}
+		f.Seek(dConf.Co64BoxOffset, 0)
+		readCo64Box(f, uint32(co64Size), 0, "moov.trak.mdia.minf.stbl.co64", mp4)
+		co64Box := mp4["moov.trak.mdia.minf.stbl.co64"][0].(Co64Box)
+
+		return &stscBox, co64Box.ChunkOffset
+	}
+	stcoSize := 8 + (lastChunk * 4)
+	if stcoSize > uint64(dConf.StcoBoxSize) {
+		stcoSize = uint64(dConf.StcoBoxSize)
+	}
+	f.Seek(dConf.StcoBoxOffset, 0)
+	readStcoBox(f, uint32(stcoSize), 0, "moov.trak.mdia.minf.stbl.stco", mp4)
+	stcoBox := mp4["moov.trak.mdia.minf.stbl.stco"][0].(StcoBox)
+	chunkOffsets = make([]uint64, len(stcoBox.ChunkOffset))
+	for i, offset := range stcoBox.ChunkOffset {
+		chunkOffsets[i] = uint64(offset)
+	}
+
+	return &stscBox, chunkOffsets
+}
+
+// Chunk (starting at 1) holding a sample (starting at 1) from the STSC Box entries
+func sampleChunk(stsc StscBox, sample uint32) (chunk uint32) {
+	var first uint32 = 1
+	for i, entry := range stsc.Entries {
+		if entry.SamplesPerChunk == 0 {
+			continue
+		}
+		if i+1 < len(stsc.Entries) {
+			samples := (stsc.Entries[i+1].FirstChunk - entry.FirstChunk) * entry.SamplesPerChunk
+			if sample >= first+samples {
+				first += samples
+				continue
+			}
+		}
+		return entry.FirstChunk + (sample-first)/entry.SamplesPerChunk
+	}
+
+	return
+}
+
+// Runs of contiguous samples [sampleStart, sampleEnd] of a track in its file, from its chunks
+// when they are known or from the beginning of the mdat Box otherwise
+func sampleChunks(dConf DashConfig, tables dashSampleTables, sampleStart uint32, sampleEnd uint32) (chunks []MdatChunk) {
+	sampleSize := func(i uint32) uint32 {
+		if tables.stsz.SampleSize == 0 {
+			return tables.stsz.EntrySize[i]
+		}
+		return tables.stsz.SampleSize
+	}
+	add := func(offset int64, size uint32) {
+		if n := len(chunks); n > 0 && chunks[n-1].Offset+int64(chunks[n-1].Size) == offset {
+			chunks[n-1].Size += size
+			return
+		}
+		chunks = append(chunks, MdatChunk{Offset: offset, Size: size})
+	}
+
+	if tables.stsc == nil || tables.chunkOffsets == nil {
+		offset := dConf.MdatBoxOffset
+		var i uint32
+		for i = 0; i < sampleStart; i++ {
+			offset += int64(sampleSize(i))
+		}
+		for i = sampleStart; i <= sampleEnd; i++ {
+			add(offset, sampleSize(i))
+			offset += int64(sampleSize(i))
+		}
+		return
+	}
+
+	var sample uint32
+	for i, entry := range tables.stsc.Entries {
+		lastChunk := uint32(len(tables.chunkOffsets))
+		if i+1 < len(tables.stsc.Entries) && tables.stsc.Entries[i+1].FirstChunk-1 < lastChunk {
+			lastChunk = tables.stsc.Entries[i+1].FirstChunk - 1
+		}
+		for chunk := entry.FirstChunk; chunk <= lastChunk && sample <= sampleEnd; chunk++ {
+			if sample+entry.SamplesPerChunk <= sampleStart {
+				// Whole chunk before the first sample
+				sample += entry.SamplesPerChunk
+				continue
+			}
+			offset := int64(tables.chunkOffsets[chunk-1])
+			var j uint32
+			for j = 0; j < entry.SamplesPerChunk && sample <= sampleEnd; j++ {
+				if sample >= sampleStart {
+					add(offset, sampleSize(sample))
+				}
+				offset += int64(sampleSize(sample))
+				sample++
+			}
+		}
+	}
+
+	return
+}
+
+// Compute the first and the last sample (both included) of a fragment
+// For video tracks (stss != nil), the fragment starts and ends on an I-Frame
+func fragmentSampleRange(dConf DashConfig, stss *StssBox, fragmentNumber uint32, fragmentDuration uint32) (sampleStart uint32, sampleEnd uint32, lastSegment bool) {
+	sampleStart = uint32((((float64(fragmentNumber) - 1) * float64(fragmentDuration)) * float64(dConf.Timescale)) / float64(dConf.SampleDelta))
+	sampleEnd = uint32(((float64(fragmentNumber) * float64(fragmentDuration)) * float64(dConf.Timescale)) / float64(dConf.SampleDelta))
+
+	if stss != nil {
+		// Must match an I-Frame
+		var i uint32
+		sampleStartSet := false
+		for i = 0; (i < stss.EntryCount) && ((stss.SampleNumber[i] - 1) < sampleEnd); i++ {
+			if sampleStartSet == false && (stss.SampleNumber[i]-1) >= sampleStart {
+				sampleStart = stss.SampleNumber[i] - 1
+				sampleStartSet = true
+			}
+		}
+		if i < stss.EntryCount {
+			sampleEnd = stss.SampleNumber[i] - 1
+		} else {
+			lastSegment = true
+		}
+	}
+	sampleEnd--
+
+	return
+}
+
 // ***
 // *** Public functions
 // ***
@@ -2696,24 +4117,67 @@ func ParseFile(filename string, language string) (mp4 Mp4) {
 	if err != nil {
 		panic(err)
 	}
-	readBoxes(f, uint32(finfo.Size()), 0, "", mp4.Boxes)
+	readLargeBoxes(f, uint64(finfo.Size()), 0, "", mp4.Boxes)
 	if debugMode {
 		log.Printf("[ MP4 STRUCTURE ] %+v", mp4.Boxes)
 	}
 
 	mp4.Filename = filename
 	mp4.Language = language
-	if mp4.Boxes["moov.trak.mdia.minf.stbl.stsd.mp4a"] != nil {
-		mp4.IsAudio = true
-	} else {
-		mp4.IsAudio = false
+	mp4.Tracks = make(map[uint32]map[string][]interface{})
+	for _, box := range mp4.Boxes["moov.trak"] {
+		trak := box.(TrakBox)
+		mp4.Tracks[trak.TrackId] = trak.Boxes
 	}
+	mp4.setMediaTypes()
 
-	if mp4.Boxes["moov.trak.mdia.minf.stbl.stsd.avc1"] != nil {
-		mp4.IsVideo = true
-	} else {
-		mp4.IsVideo = false
+	return
+}
+
+// Set IsAudio and IsVideo from the sample entries of the boxes
+func (mp4 *Mp4) setMediaTypes() {
+	mp4.IsAudio = false
+	for _, entry := range audioSampleEntries {
+		if mp4.Boxes["moov.trak.mdia.minf.stbl.stsd."+entry] != nil {
+			mp4.IsAudio = true
+		}
+	}
+
+	mp4.IsVideo = false
+	for _, entry := range videoSampleEntries {
+		if mp4.Boxes["moov.trak.mdia.minf.stbl.stsd."+entry] != nil {
+			mp4.IsVideo = true
+		}
 	}
+}
+
+// track_ID of the tracks of a parsed file, in increasing order
+func (mp4 Mp4) TrackIds() (trackIds []uint32) {
+	for trackId := range mp4.Tracks {
+		trackIds = append(trackIds, trackId)
+	}
+	sort.Slice(trackIds, func(i, j int) bool { return trackIds[i] < trackIds[j] })
+
+	return
+}
+
+// Parsed file restricted to one of its tracks: the boxes of the file outside of the TRAK Boxes
+// (eg: mdat, moov.mvhd or moov.pssh) with the ones of the track, Tracks still has all of them
+func (mp4 Mp4) Track(trackId uint32) (track Mp4) {
+	track.Filename = mp4.Filename
+	track.Language = mp4.Language
+	track.Tracks = mp4.Tracks
+	track.TrackId = trackId
+	track.Boxes = make(map[string][]interface{})
+	for path, boxes := range mp4.Boxes {
+		if path != "moov.trak" && !strings.HasPrefix(path, "moov.trak.") {
+			track.Boxes[path] = boxes
+		}
+	}
+	for path, boxes := range mp4.Tracks[trackId] {
+		track.Boxes[path] = boxes
+	}
+	track.setMediaTypes()
 
 	return
 }
@@ -2725,6 +4189,15 @@ func boxToBytes(box interface{}, boxFullPath string) []byte {
 	case "ftyp":
 		ftyp := box.(FtypBox)
 		return ftyp.Bytes()
+	case "pssh":
+		pssh := box.(PsshBox)
+		return pssh.Bytes()
+	case "sgpd":
+		sgpd := box.(SgpdBox)
+		return sgpd.Bytes()
+	case "sbgp":
+		sbgp := box.(SbgpBox)
+		return sbgp.Bytes()
 	case "free":
 		free := box.(FreeBox)
 		return free.Bytes()
@@ -2773,10 +4246,35 @@ func boxToBytes(box interface{}, boxFullPath string) []byte {
 	case "mp4a":
 		mp4a := box.(Mp4aBox)
 		return mp4a.Bytes()
+	case "ac-3", "ec-3", "Opus", "fLaC":
+		// Same box as MP4A, the codec configuration is in the DAC3 / DEC3 / DOPS / DFLA Box
+		data := box.(Mp4aBox).Bytes()
+		copy(data[4:8], []byte(boxName))
+		return data
+	case "dac3":
+		dac3 := box.(Dac3Box)
+		return dac3.Bytes()
+	case "dec3":
+		dec3 := box.(Dec3Box)
+		return dec3.Bytes()
+	case "dOps":
+		dOps := box.(DopsBox)
+		return dOps.Bytes()
+	case "dfLa":
+		dfLa := box.(DflaBox)
+		return dfLa.Bytes()
 	// support jpeg
 	case "mp4v":
-		mp4v := box.(Mp4vBox)
-		return mp4v.Bytes()
+		// Init segments use the AVC1 Box with a new name
+		switch entry := box.(type) {
+		case Mp4vBox:
+			return entry.Bytes()
+		case Avc1Box:
+			data := entry.Bytes()
+			copy(data[4:8], boxName)
+			return data
+		}
+		return nil
 	case "esdsv":
 		esdsv := box.(EsdsvBox)
 		return esdsv.Bytes()
@@ -2792,12 +4290,26 @@ func boxToBytes(box interface{}, boxFullPath string) []byte {
 	case "avcC":
 		avcC := box.(AvcCBox)
 		return avcC.Bytes()
-	case "hev1":
-		avc1 := box.(Hvc1Box)
-		return avc1.Bytes()
+	case "hev1", "hvc1":
+		// Same box for both sample entry types, hvc1 has all its parameter sets in the HVCC Box
+		hvc1 := box.(Hvc1Box)
+		data := hvc1.Bytes()
+		copy(data[4:8], boxName)
+		return data
 	case "hvcC":
 		hvcC := box.(HvcCBox)
 		return hvcC.Bytes()
+	case "vp09", "av01":
+		// Same box as AVC1, the codec configuration is in the VPCC / AV1C Box
+		data := box.(Avc1Box).Bytes()
+		copy(data[4:8], boxName)
+		return data
+	case "vpcC":
+		vpcC := box.(VpcCBox)
+		return vpcC.Bytes()
+	case "av1C":
+		av1C := box.(Av1CBox)
+		return av1C.Bytes()
 	case "btrt":
 		btrt := box.(BtrtBox)
 		return btrt.Bytes()
@@ -2819,6 +4331,9 @@ func boxToBytes(box interface{}, boxFullPath string) []byte {
 	case "stco":
 		stco := box.(StcoBox)
 		return stco.Bytes()
+	case "co64":
+		co64 := box.(Co64Box)
+		return co64.Bytes()
 	case "stss":
 		stss := box.(StssBox)
 		return stss.Bytes()
@@ -2855,6 +4370,45 @@ func boxToBytes(box interface{}, boxFullPath string) []byte {
 	case "frma":
 		frma := box.(FrmaBox)
 		return frma.Bytes()
+	case "sidx":
+		sidx := box.(SidxBox)
+		return sidx.Bytes()
+	case "encv", "enca":
+		// Encrypted sample entries keep the original box with a new name
+		var data []byte
+		switch entry := box.(type) {
+		case Avc1Box:
+			data = entry.Bytes()
+		case Hvc1Box:
+			data = entry.Bytes()
+		case Mp4aBox:
+			data = entry.Bytes()
+		default:
+			return nil
+		}
+		copy(data[4:8], []byte(boxName))
+		return data
+	case "sinf", "schi":
+		parent := box.(ParentBox)
+		return parent.Bytes()
+	case "schm":
+		schm := box.(SchmBox)
+		return schm.Bytes()
+	case "tenc":
+		tenc := box.(TencBox)
+		return tenc.Bytes()
+	case "senc":
+		senc := box.(SencBox)
+		return senc.Bytes()
+	case "saiz":
+		saiz := box.(SaizBox)
+		return saiz.Bytes()
+	case "saio":
+		saio := box.(SaioBox)
+		return saio.Bytes()
+	case "tfxd", "tfrf":
+		uuid := box.(UuidBox)
+		return uuid.Bytes()
 	case "mdat":
 		mdat := box.(MdatBox)
 		return mdat.Bytes()
@@ -2868,12 +4422,21 @@ func MapToBytes(mp4 map[string][]interface{}) (data []byte) {
 		"ftyp",
 		"styp",
 		"free",
+		"sidx",
 		"moof",
 		"moof.mfhd",
 		"moof.traf",
 		"moof.traf.tfhd",
 		"moof.traf.tfdt",
 		"moof.traf.trun",
+		"moof.traf.senc",
+		"moof.traf.saiz",
+		"moof.traf.saio",
+		"moof.traf.sbgp",
+		"moof.traf.sgpd",
+		"moof.traf.tfxd",
+		"moof.traf.tfrf",
+		"moof.pssh",
 		"moov",
 		"moov.mvhd",
 		"moov.trak",
@@ -2890,15 +4453,57 @@ func MapToBytes(mp4 map[string][]interface{}) (data []byte) {
 		"moov.trak.mdia.minf.stbl.stsd",
 		"moov.trak.mdia.minf.stbl.stsd.mp4a",
 		"moov.trak.mdia.minf.stbl.stsd.mp4a.esds",
+		"moov.trak.mdia.minf.stbl.stsd.ac-3",
+		"moov.trak.mdia.minf.stbl.stsd.ac-3.dac3",
+		"moov.trak.mdia.minf.stbl.stsd.ec-3",
+		"moov.trak.mdia.minf.stbl.stsd.ec-3.dec3",
+		"moov.trak.mdia.minf.stbl.stsd.Opus",
+		"moov.trak.mdia.minf.stbl.stsd.Opus.dOps",
+		"moov.trak.mdia.minf.stbl.stsd.fLaC",
+		"moov.trak.mdia.minf.stbl.stsd.fLaC.dfLa",
 		"moov.trak.mdia.minf.stbl.stsd.mp4v",
 		"moov.trak.mdia.minf.stbl.stsd.mp4v.esdsv",
+		"moov.trak.mdia.minf.stbl.stsd.mp4v.esds",
 		"moov.trak.mdia.minf.stbl.stsd.mp4v.pasp",
+		"moov.trak.mdia.minf.stbl.stsd.mp4v.btrt",
 		"moov.trak.mdia.minf.stbl.stsd.avc1",
 		"moov.trak.mdia.minf.stbl.stsd.avc1.avcC",
 		"moov.trak.mdia.minf.stbl.stsd.avc1.btrt",
 		"moov.trak.mdia.minf.stbl.stsd.hev1",
 		"moov.trak.mdia.minf.stbl.stsd.hev1.hvcC",
 		"moov.trak.mdia.minf.stbl.stsd.hev1.btrt",
+		"moov.trak.mdia.minf.stbl.stsd.hvc1",
+		"moov.trak.mdia.minf.stbl.stsd.hvc1.hvcC",
+		"moov.trak.mdia.minf.stbl.stsd.hvc1.btrt",
+		"moov.trak.mdia.minf.stbl.stsd.vp09",
+		"moov.trak.mdia.minf.stbl.stsd.vp09.vpcC",
+		"moov.trak.mdia.minf.stbl.stsd.vp09.btrt",
+		"moov.trak.mdia.minf.stbl.stsd.av01",
+		"moov.trak.mdia.minf.stbl.stsd.av01.av1C",
+		"moov.trak.mdia.minf.stbl.stsd.av01.btrt",
+		"moov.trak.mdia.minf.stbl.stsd.enca",
+		"moov.trak.mdia.minf.stbl.stsd.enca.esds",
+		"moov.trak.mdia.minf.stbl.stsd.enca.dac3",
+		"moov.trak.mdia.minf.stbl.stsd.enca.dec3",
+		"moov.trak.mdia.minf.stbl.stsd.enca.dOps",
+		"moov.trak.mdia.minf.stbl.stsd.enca.dfLa",
+		"moov.trak.mdia.minf.stbl.stsd.enca.sinf",
+		"moov.trak.mdia.minf.stbl.stsd.enca.sinf.frma",
+		"moov.trak.mdia.minf.stbl.stsd.enca.sinf.schm",
+		"moov.trak.mdia.minf.stbl.stsd.enca.sinf.schi",
+		"moov.trak.mdia.minf.stbl.stsd.enca.sinf.schi.tenc",
+		"moov.trak.mdia.minf.stbl.stsd.encv",
+		"moov.trak.mdia.minf.stbl.stsd.encv.avcC",
+		"moov.trak.mdia.minf.stbl.stsd.encv.hvcC",
+		"moov.trak.mdia.minf.stbl.stsd.encv.vpcC",
+		"moov.trak.mdia.minf.stbl.stsd.encv.av1C",
+		"moov.trak.mdia.minf.stbl.stsd.encv.esds",
+		"moov.trak.mdia.minf.stbl.stsd.encv.btrt",
+		"moov.trak.mdia.minf.stbl.stsd.encv.sinf",
+		"moov.trak.mdia.minf.stbl.stsd.encv.sinf.frma",
+		"moov.trak.mdia.minf.stbl.stsd.encv.sinf.schm",
+		"moov.trak.mdia.minf.stbl.stsd.encv.sinf.schi",
+		"moov.trak.mdia.minf.stbl.stsd.encv.sinf.schi.tenc",
 		"moov.trak.mdia.minf.stbl.stts",
 		"moov.trak.mdia.minf.stbl.ctts",
 		"moov.trak.mdia.minf.stbl.stsc",
@@ -2908,18 +4513,19 @@ func MapToBytes(mp4 map[string][]interface{}) (data []byte) {
 		"moov.mvex",
 		"moov.mvex.mehd",
 		"moov.mvex.trex",
+		"moov.pssh",
 		"mdat",
 	}
 
 	for _, v := range boxPathOrder {
-		if mp4[v] == nil {
-			continue
-		}
-		b := boxToBytes(mp4[v][0], v)
-		if b == nil {
-			break
+		// Leaf boxes can be repeated (eg: one PSSH Box per DRM system)
+		for _, box := range mp4[v] {
+			b := boxToBytes(box, v)
+			if b == nil {
+				return
+			}
+			data = append(data, b...)
 		}
-		data = append(data, b...)
 	}
 
 	return
@@ -3007,13 +4613,12 @@ func CreateDashInit(mp4 map[string][]interface{}) (mp4Init map[string][]interfac
 	if mp4["moov.trak.mdia.minf.stbl.stsd.mp4a"] != nil {
 		mp4a := mp4["moov.trak.mdia.minf.stbl.stsd.mp4a"][0].(Mp4aBox)
 		esds := mp4["moov.trak.mdia.minf.stbl.stsd.mp4a.esds"][0].(EsdsBox)
-		esdsData := []byte{0x03, 0x19, 0x00, 0x01, 0x00, 0x04, 0x11, 0x40, 0x15, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xF3, 0xC2, 0x05, 0x02, 0x11, 0x90, 0x06, 0x01, 0x02}
-		copy(esds.Data[:], esdsData[:])
+		var audio DashAudioEntry
+		ReadEsdsAudioConfig(esds, &audio)
 		esdsOldSize := esds.Size
-		esds.Size = 31
+		esds = createEsdsBox(&audio)
 		stsd.Size += esds.Size - esdsOldSize
 		mp4a.Size += esds.Size - esdsOldSize
-		esds.Version = 0
 		replaceBox(mp4Init, "moov.trak.mdia.minf.stbl.stsd.mp4a.esds", esds)
 		replaceBox(mp4Init, "moov.trak.mdia.minf.stbl.stsd.mp4a", mp4a)
 	}
@@ -3235,7 +4840,7 @@ func CreateDashFragment(mp4 map[string][]interface{}, fragmentNumber uint32, fra
 			trun.Samples[i-sampleStart].Flags = 21037248
 			trun.Size += 4
 		}
-		mdat.Size += stsz.EntrySize[i]
+		mdat.Size += uint64(stsz.EntrySize[i])
 	}
 	if isVideo == true {
 		var i uint32
@@ -3424,10 +5029,28 @@ func CreateDashInitWithConf(dConf DashConfig) (mp4Init map[string][]interface{})
 		smhd.Size = 8
 		replaceBox(mp4Init, "moov.trak.mdia.minf.smhd", smhd)
 
-		var esds EsdsBox
-		esds.Data = []byte{0x03, 0x19, 0x00, 0x01, 0x00, 0x04, 0x11, 0x40, 0x15, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xF3, 0xC2, 0x05, 0x02, 0x11, 0x90, 0x06, 0x01, 0x02}
-		esds.Size = 31
-		replaceBox(mp4Init, "moov.trak.mdia.minf.stbl.stsd.mp4a.esds", esds)
+		// Codec configuration: ESDS for AAC, DAC3 / DEC3 for AC-3 / E-AC-3, DOPS for Opus, DFLA for FLAC
+		entry := audioSampleEntry(*dConf.Audio)
+		entryPath := "moov.trak.mdia.minf.stbl.stsd." + entry
+		var configSize uint32
+		switch {
+		case entry == "ac-3" && dConf.Audio.Dac3 != nil:
+			replaceBox(mp4Init, entryPath+".dac3", *dConf.Audio.Dac3)
+			configSize = dConf.Audio.Dac3.Size
+		case entry == "ec-3" && dConf.Audio.Dec3 != nil:
+			replaceBox(mp4Init, entryPath+".dec3", *dConf.Audio.Dec3)
+			configSize = dConf.Audio.Dec3.Size
+		case entry == "Opus" && dConf.Audio.Dops != nil:
+			replaceBox(mp4Init, entryPath+".dOps", *dConf.Audio.Dops)
+			configSize = dConf.Audio.Dops.Size
+		case entry == "fLaC" && dConf.Audio.Dfla != nil:
+			replaceBox(mp4Init, entryPath+".dfLa", *dConf.Audio.Dfla)
+			configSize = dConf.Audio.Dfla.Size
+		default:
+			esds := createEsdsBox(dConf.Audio)
+			replaceBox(mp4Init, entryPath+".esds", esds)
+			configSize = esds.Size
+		}
 
 		var mp4a Mp4aBox
 		mp4a.Reserved = [6]byte{0, 0, 0, 0, 0, 0}
@@ -3440,8 +5063,8 @@ func CreateDashInitWithConf(dConf DashConfig) (mp4Init map[string][]interface{})
 		mp4a.CompressionId = dConf.Audio.CompressionId
 		mp4a.Reserved2 = 0
 		mp4a.SampleRate = dConf.Audio.SampleRate
-		mp4a.Size = 28 + esds.Size + 8
-		replaceBox(mp4Init, "moov.trak.mdia.minf.stbl.stsd.mp4a", mp4a)
+		mp4a.Size = 28 + configSize + 8
+		replaceBox(mp4Init, entryPath, mp4a)
 
 		stsd.Size = 8 + mp4a.Size + 8
 
@@ -3454,7 +5077,7 @@ func CreateDashInitWithConf(dConf DashConfig) (mp4Init map[string][]interface{})
 		minf.Size = smhd.Size + 8 + dinf.Size + 8 + stbl.Size + 8
 	}
 
-	// Create AVC1 Box if Type == "video"
+	// Create AVC1, HVC1 / HEV1, VP09, AV01 or MP4V Box if Type == "video"
 	if dConf.Type == "video" {
 		var vmhd VmhdBox
 		vmhd.Version = 0
@@ -3464,27 +5087,48 @@ func CreateDashInitWithConf(dConf DashConfig) (mp4Init map[string][]interface{})
 		vmhd.Size = 12
 		replaceBox(mp4Init, "moov.trak.mdia.minf.vmhd", vmhd)
 
-		var avcC AvcCBox
-		avcC.ConfigurationVersion = 1
-		avcC.AVCProfileIndication = dConf.Video.CodecInfo[0]
-		avcC.ProfileCompatibility = dConf.Video.CodecInfo[1]
-		avcC.AVCLevelIndication = dConf.Video.CodecInfo[2]
-		avcC.NalUnitSize = dConf.Video.NalUnitSize
-		avcC.SPSEntryCount = dConf.Video.SPSEntryCount
-		avcC.SPSSize = dConf.Video.SPSSize
-		avcC.SPSData = dConf.Video.SPSData
-		avcC.PPSEntryCount = dConf.Video.PPSEntryCount
-		avcC.PPSSize = dConf.Video.PPSSize
-		avcC.PPSData = dConf.Video.PPSData
-		avcC.Size = 38
-		replaceBox(mp4Init, "moov.trak.mdia.minf.stbl.stsd.avc1.avcC", avcC)
+		entry := videoSampleEntry(*dConf.Video)
+		entryPath := "moov.trak.mdia.minf.stbl.stsd." + entry
+		var configSize uint32
+		switch {
+		case dConf.Video.HvcC != nil:
+			hvcC := *dConf.Video.HvcC
+			hvcC.Size = hvcC.computeSize()
+			replaceBox(mp4Init, entryPath+".hvcC", hvcC)
+			configSize = hvcC.Size
+		case dConf.Video.VpcC != nil:
+			replaceBox(mp4Init, entryPath+".vpcC", *dConf.Video.VpcC)
+			configSize = dConf.Video.VpcC.Size
+		case dConf.Video.Av1C != nil:
+			replaceBox(mp4Init, entryPath+".av1C", *dConf.Video.Av1C)
+			configSize = dConf.Video.Av1C.Size
+		case dConf.Video.Esds != nil:
+			replaceBox(mp4Init, entryPath+".esds", *dConf.Video.Esds)
+			configSize = dConf.Video.Esds.Size
+		default:
+			var avcC AvcCBox
+			avcC.ConfigurationVersion = 1
+			avcC.AVCProfileIndication = dConf.Video.CodecInfo[0]
+			avcC.ProfileCompatibility = dConf.Video.CodecInfo[1]
+			avcC.AVCLevelIndication = dConf.Video.CodecInfo[2]
+			avcC.NalUnitSize = dConf.Video.NalUnitSize
+			avcC.SPSEntryCount = dConf.Video.SPSEntryCount
+			avcC.SPSSize = dConf.Video.SPSSize
+			avcC.SPSData = dConf.Video.SPSData
+			avcC.PPSEntryCount = dConf.Video.PPSEntryCount
+			avcC.PPSSize = dConf.Video.PPSSize
+			avcC.PPSData = dConf.Video.PPSData
+			avcC.Size = 11 + uint32(avcC.SPSSize)*uint32(avcC.SPSEntryCount) + uint32(avcC.PPSSize)*uint32(avcC.PPSEntryCount)
+			replaceBox(mp4Init, entryPath+".avcC", avcC)
+			configSize = avcC.Size
+		}
 
 		var btrt BtrtBox
 		btrt.DecodingBufferSize = 0
 		btrt.MaxBitrate = 0
 		btrt.AvgBitrate = uint32(float64(dConf.MdatBoxSize) / (float64(dConf.Duration) / float64(dConf.Timescale)) * 8)
 		btrt.Size = 12
-		replaceBox(mp4Init, "moov.trak.mdia.minf.stbl.stsd.avc1.btrt", btrt)
+		replaceBox(mp4Init, entryPath+".btrt", btrt)
 
 		var avc1 Avc1Box
 		avc1.Reserved = [6]byte{0, 0, 0, 0, 0, 0}
@@ -3501,12 +5145,26 @@ func CreateDashInitWithConf(dConf DashConfig) (mp4Init map[string][]interface{})
 		avc1.EntryDataSize = 0
 		avc1.FramesPerSample = 1
 		compressorName := "AVC Coding"
+		switch {
+		case dConf.Video.HvcC != nil:
+			compressorName = "HEVC Coding"
+		case dConf.Video.VpcC != nil:
+			compressorName = "VP9 Coding"
+		case dConf.Video.Av1C != nil:
+			compressorName = "AV1 Coding"
+		case dConf.Video.Esds != nil:
+			compressorName = "MPEG-4 Visual Coding"
+		}
 		avc1.CompressorName[0] = byte(len(compressorName))
 		copy(avc1.CompressorName[1:], []byte(compressorName)[:])
 		avc1.BitDepth = dConf.Video.BitDepth
 		avc1.ColorTableIndex = dConf.Video.ColorTableIndex
-		avc1.Size = 78 + avcC.Size + 8 + btrt.Size + 8
-		replaceBox(mp4Init, "moov.trak.mdia.minf.stbl.stsd.avc1", avc1)
+		avc1.Size = 78 + configSize + 8 + btrt.Size + 8
+		if dConf.Video.HvcC != nil {
+			replaceBox(mp4Init, entryPath, Hvc1Box(avc1))
+		} else {
+			replaceBox(mp4Init, entryPath, avc1)
+		}
 
 		stsd.Size = 8 + avc1.Size + 8
 
@@ -3551,17 +5209,58 @@ func CreateDashInitWithConf(dConf DashConfig) (mp4Init map[string][]interface{})
 	moov.Size = mvhd.Size + 8 + trak.Size + 8 + mvex.Size + 8
 	replaceBox(mp4Init, "moov", moov)
 
+	// Pre-encrypted samples are never encrypted again
+	if dConf.Protection != nil {
+		protectDashInit(dConf, mp4Init)
+	} else if dConf.Encryption != nil {
+		encryptDashInit(dConf, mp4Init)
+	}
+
 	return
 }
 
 func CreateDashFragmentWithConf(dConf DashConfig, filename string, fragmentNumber uint32, fragmentDuration uint32) (fmp4 map[string][]interface{}) {
-	lastSegment := false
-	compositionTimeOffset := false
-
 	f, err := os.Open(filename)
 	if err != nil {
 		return
 	}
+	defer f.Close()
+
+	var tables dashSampleTables
+	mp4 := make(map[string][]interface{})
+	if dConf.Type == "video" && dConf.Video.CttsBoxOffset != 0 {
+		f.Seek(dConf.Video.CttsBoxOffset, 0)
+		readCttsBox(f, dConf.Video.CttsBoxSize, 0, "moov.trak.mdia.minf.stbl.ctts", mp4)
+		ctts := mp4["moov.trak.mdia.minf.stbl.ctts"][0].(CttsBox)
+		tables.ctts = &ctts
+	}
+	if dConf.Type == "video" {
+		tables.stss = readStssBoxWithConf(f, dConf, mp4)
+	}
+
+	// Read STSZ Box only until the last sample of the fragment
+	_, sampleEnd, _ := fragmentSampleRange(dConf, tables.stss, fragmentNumber, fragmentDuration)
+	tables.stsz = readStszBoxWithConf(f, dConf, sampleEnd+1)
+	tables.stsc, tables.chunkOffsets = readChunkTablesWithConf(f, dConf, sampleEnd+1)
+	if dConf.Protection != nil {
+		tables.auxInfo, err = readSampleAuxInfoWithConf(f, dConf, sampleEnd)
+		if err != nil {
+			if debugMode {
+				log.Printf("ERROR: cannot read sample auxiliary information: %v", err)
+			}
+			return
+		}
+	}
+
+	fmp4 = createDashFragmentWithTables(dConf, filename, tables, fragmentNumber, fragmentDuration)
+
+	return
+}
+
+// Create a DASH fragment from sample tables already read
+func createDashFragmentWithTables(dConf DashConfig, filename string, tables dashSampleTables, fragmentNumber uint32, fragmentDuration uint32) (fmp4 map[string][]interface{}) {
+	lastSegment := false
+	compositionTimeOffset := false
 	fmp4 = make(map[string][]interface{})
 
 	// FREE
@@ -3611,12 +5310,9 @@ func CreateDashFragmentWithConf(dConf DashConfig, filename string, fragmentNumbe
 	tfhd.DefaultSampleDuration = dConf.SampleDelta
 	replaceBox(fmp4, "moof.traf.tfhd", tfhd)
 
-	mp4 := make(map[string][]interface{})
 	var ctts CttsBox
-	if dConf.Type == "video" && dConf.Video.CttsBoxOffset != 0 {
-		f.Seek(dConf.Video.CttsBoxOffset, 0)
-		readCttsBox(f, dConf.Video.CttsBoxSize, 0, "moov.trak.mdia.minf.stbl.ctts", mp4)
-		ctts = mp4["moov.trak.mdia.minf.stbl.ctts"][0].(CttsBox)
+	if dConf.Type == "video" && tables.ctts != nil {
+		ctts = *tables.ctts
 		compositionTimeOffset = true
 	}
 
@@ -3645,45 +5341,24 @@ func CreateDashFragmentWithConf(dConf DashConfig, filename string, fragmentNumbe
 		}
 	}
 
-	sampleStart := uint32((((float64(fragmentNumber) - 1) * float64(fragmentDuration)) * float64(dConf.Timescale)) / float64(dConf.SampleDelta))
-	sampleEnd := uint32(((float64(fragmentNumber) * float64(fragmentDuration)) * float64(dConf.Timescale)) / float64(dConf.SampleDelta))
-
 	// Search Positions in STSS Box
-	var stss StssBox
+	stss := tables.stss
 	var iFramesToSet []uint32
-	if dConf.Type == "video" {
-		f.Seek(dConf.Video.StssBoxOffset, 0)
-		readStssBox(f, dConf.Video.StssBoxSize, 0, "moov.trak.mdia.minf.stbl.stss", mp4)
-		// Must match an I-Frame
-		stss = mp4["moov.trak.mdia.minf.stbl.stss"][0].(StssBox)
+	sampleStart, sampleEnd, lastSegment := fragmentSampleRange(dConf, stss, fragmentNumber, fragmentDuration)
+	if stss != nil {
 		var i uint32
-		sampleStartSet := false
-		for i = 0; (i < stss.EntryCount) && ((stss.SampleNumber[i] - 1) < sampleEnd); i++ {
+		for i = 0; (i < stss.EntryCount) && ((stss.SampleNumber[i] - 1) <= sampleEnd); i++ {
 			if (stss.SampleNumber[i] - 1) >= sampleStart {
-				if sampleStartSet == false {
-					sampleStart = stss.SampleNumber[i] - 1
-					sampleStartSet = true
-				}
 				iFramesToSet = append(iFramesToSet, stss.SampleNumber[i]-1-sampleStart)
 			}
 		}
-		if i < stss.EntryCount {
-			sampleEnd = stss.SampleNumber[i] - 1
-		} else {
-			lastSegment = true
-		}
 	}
-	sampleEnd--
 
-	// Read STSZ Box
-	f.Seek(dConf.StszBoxOffset, 0)
-	var stszSize uint32
-	stszSize = 12 + ((sampleEnd + 1) * 4)
-	if stszSize > dConf.StszBoxSize {
-		stszSize = dConf.StszBoxSize
+	stsz := tables.stsz
+	if sampleStart >= stsz.SampleCount {
+		fmp4 = nil
+		return
 	}
-	readStszBox(f, stszSize, 0, "moov.trak.mdia.minf.stbl.stsz", mp4)
-	stsz := mp4["moov.trak.mdia.minf.stbl.stsz"][0].(StszBox)
 	if sampleEnd > (stsz.SampleCount - 1) {
 		sampleEnd = stsz.SampleCount - 1
 	}
@@ -3695,16 +5370,15 @@ func CreateDashFragmentWithConf(dConf DashConfig, filename string, fragmentNumbe
 	cttsOffset = 0
 	cttsSampleCount = 0
 	var mdat MdatBox
-	mdat.Offset = dConf.MdatBoxOffset
 	mdat.Size = 0
 	mdat.Filename = filename
+	mdat.Chunks = sampleChunks(dConf, tables, sampleStart, sampleEnd)
+	if len(mdat.Chunks) == 1 {
+		mdat.Offset = mdat.Chunks[0].Offset
+		mdat.Chunks = nil
+	}
 	var i uint32
 	for i = 0; i < sampleStart; i++ {
-		if stsz.SampleSize == 0 {
-			mdat.Offset += int64(stsz.EntrySize[i])
-		} else {
-			mdat.Offset += int64(stsz.SampleSize)
-		}
 		if compositionTimeOffset == true {
 			if cttsSampleCount > 0 {
 				cttsSampleCount--
@@ -3757,7 +5431,7 @@ func CreateDashFragmentWithConf(dConf DashConfig, filename string, fragmentNumbe
 				}
 			}
 		}
-		mdat.Size += size
+		mdat.Size += uint64(size)
 	}
 	if dConf.Type == "video" {
 		for _, iframe := range iFramesToSet {
@@ -3783,6 +5457,20 @@ func CreateDashFragmentWithConf(dConf DashConfig, filename string, fragmentNumbe
 	replaceBox(fmp4, "moof", moof)
 	replaceBox(fmp4, "mdat", mdat)
 
+	var err error
+	if dConf.Protection != nil {
+		err = protectDashFragment(dConf, fmp4, tables.auxInfo, sampleStart)
+	} else if dConf.Encryption != nil {
+		err = encryptDashFragment(dConf, fmp4, sampleStart)
+	}
+	if err != nil {
+		if debugMode {
+			log.Printf("ERROR: cannot encrypt fragment %d: %v", fragmentNumber, err)
+		}
+		fmp4 = nil
+		return
+	}
+
 	// STYP
 	var styp StypBox
 	styp.MajorBrand = [4]byte{'i', 's', 'o', '6'}
@@ -3802,6 +5490,51 @@ func CreateDashFragmentWithConf(dConf DashConfig, filename string, fragmentNumbe
 	return
 }
 
+// Get the list of all segments of a track as they are generated by CreateDashFragmentWithConf
+// Fragments without any I-Frame are merged in the previous one by CreateDashFragmentWithConf, so they are skipped
+func GetDashSegmentsWithConf(dConf DashConfig, filename string, fragmentDuration uint32) (segments []DashSegment) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	sampleCount := readSampleCountWithConf(f, dConf)
+	if sampleCount == 0 || dConf.SampleDelta == 0 {
+		return
+	}
+	var stss *StssBox
+	if dConf.Type == "video" {
+		stss = readStssBoxWithConf(f, dConf, make(map[string][]interface{}))
+	}
+
+	var nextSample uint32
+	var fragmentNumber uint32
+	for fragmentNumber = 1; nextSample < sampleCount; fragmentNumber++ {
+		sampleStart, sampleEnd, lastSegment := fragmentSampleRange(dConf, stss, fragmentNumber, fragmentDuration)
+		if sampleStart < nextSample {
+			continue
+		}
+		if sampleEnd >= sampleCount-1 {
+			sampleEnd = sampleCount - 1
+			lastSegment = true
+		}
+		var segment DashSegment
+		segment.Number = fragmentNumber
+		segment.SampleStart = sampleStart
+		segment.SampleCount = sampleEnd - sampleStart + 1
+		segment.Time = uint64(sampleStart) * uint64(dConf.SampleDelta)
+		segment.Duration = uint64(segment.SampleCount) * uint64(dConf.SampleDelta)
+		segments = append(segments, segment)
+		nextSample = sampleEnd + 1
+		if lastSegment == true {
+			break
+		}
+	}
+
+	return
+}
+
 // ***
 // *** Package initialization
 // ***
@@ -3809,73 +5542,114 @@ func CreateDashFragmentWithConf(dConf DashConfig, filename string, fragmentNumbe
 func init() {
 	debugMode = false
 	funcBoxes = map[string]interface{}{
-		"ftyp":                                         readFtypBox,
-		"styp":                                         readStypBox,
-		"free":                                         readFreeBox,
-		"moov":                                         readBoxes,
-		"moov.mvhd":                                    readMvhdBox,
-		"moov.trak":                                    readBoxes,
-		"moov.trak.tkhd":                               readTkhdBox,
-		"moov.trak.edts":                               readBoxes,
-		"moov.trak.edts.elst":                          readElstBox,
-		"moov.trak.mdia":                               readBoxes,
-		"moov.trak.mdia.mdhd":                          readMdhdBox,
-		"moov.trak.mdia.hdlr":                          readHdlrBox,
-		"moov.trak.mdia.minf":                          readBoxes,
-		"moov.trak.mdia.minf.vmhd":                     readVmhdBox,
-		"moov.trak.mdia.minf.smhd":                     readSmhdBox,
-		"moov.trak.mdia.minf.hmhd":                     readVmhdBox,
-		"moov.trak.mdia.minf.dinf":                     readBoxes,
-		"moov.trak.mdia.minf.dinf.dref":                readDrefBox,
-		"moov.trak.mdia.minf.stbl":                     readBoxes,
-		"moov.trak.mdia.minf.stbl.stts":                readSttsBox,
-		"moov.trak.mdia.minf.stbl.ctts":                readCttsBox,
-		"moov.trak.mdia.minf.stbl.stsd":                readStsdBox,
-		"moov.trak.mdia.minf.stbl.stsd.mp4a":           readMp4aBox,
-		"moov.trak.mdia.minf.stbl.stsd.mp4v":           readMp4vBox,
-		"moov.trak.mdia.minf.stbl.stsd.mp4a.esds":      readEsdsBox,
-		"moov.trak.mdia.minf.stbl.stsd.mp4v.esdsv":     readEsdsvBox,
-		"moov.trak.mdia.minf.stbl.stsd.mp4v.pasp":      readPaspBox,
-		"moov.trak.mdia.minf.stbl.stsd.avc1":           readAvc1Box,
-		"moov.trak.mdia.minf.stbl.stsd.avc1.avcC":      readAvcCBox,
-		"moov.trak.mdia.minf.stbl.stsd.avc1.btrt":      readBtrtBox,
-		"moov.trak.mdia.minf.stbl.stsd.hev1":           readHvc1Box,
-		"moov.trak.mdia.minf.stbl.stsd.hev1.hvcC":      readHvcCBox,
-		"moov.trak.mdia.minf.stbl.stsd.hev1.btrt":      readBtrtBox,
-		"moov.trak.mdia.minf.stbl.stsd.encv":           readAvc1Box,
-		"moov.trak.mdia.minf.stbl.stsd.encv.avcC":      readAvcCBox,
-		"moov.trak.mdia.minf.stbl.stsd.encv.btrt":      readBtrtBox,
-		"moov.trak.mdia.minf.stbl.stsd.encv.sinf":      readBoxes,
-		"moov.trak.mdia.minf.stbl.stsd.encv.sinf.frma": readFrmaBox,
-		"moov.trak.mdia.minf.stbl.stsd.encv.sinf.schm": readSchmBox,
-		"moov.trak.mdia.minf.stbl.stsd.encv.sinf.schi": readBoxes,
-		"moov.trak.mdia.minf.stbl.stsc":                readStscBox,
-		"moov.trak.mdia.minf.stbl.stsz":                readStszBox,
-		"moov.trak.mdia.minf.stbl.sdtp":                readSdtpBox,
-		"moov.trak.mdia.minf.stbl.stco":                readStcoBox,
-		"moov.trak.mdia.minf.stbl.stss":                readStssBox,
-		"moov.mvex":                                    readBoxes,
-		"moov.mvex.mehd":                               readMehdBox,
-		"moov.mvex.trex":                               readTrexBox,
-		"moov.udta":                                    readBoxes,
-		"moof":                                         readBoxes,
-		"moof.mfhd":                                    readMfhdBox,
-		"moof.traf":                                    readBoxes,
-		"moof.traf.tfhd":                               readTfhdBox,
-		"moof.traf.trun":                               readTrunBox,
-		"moof.traf.tfdt":                               readTfdtBox,
-		"mfra":                                         readBoxes,
-		"skip":                                         readBoxes,
-		"skip.udta":                                    readBoxes,
-		"skip.udta.cprt":                               readBoxes,
-		"meta":                                         readBoxes,
-		"meta.dinf":                                    readBoxes,
-		"meta.ipro":                                    readBoxes,
-		"meta.ipro.sinf":                               readBoxes,
-		"meta.ipro.sinf.frma":                          readFrmaBox,
-		"meta.flin":                                    readBoxes,
-		"meta.flin.paen":                               readBoxes,
-		"meco":                                         readBoxes,
-		"mdat":                                         readMdatBox,
+		"ftyp":                                              readFtypBox,
+		"styp":                                              readStypBox,
+		"free":                                              readFreeBox,
+		"moov":                                              readBoxes,
+		"moov.mvhd":                                         readMvhdBox,
+		"moov.trak":                                         readTrakBox,
+		"moov.trak.tkhd":                                    readTkhdBox,
+		"moov.trak.edts":                                    readBoxes,
+		"moov.trak.edts.elst":                               readElstBox,
+		"moov.trak.mdia":                                    readBoxes,
+		"moov.trak.mdia.mdhd":                               readMdhdBox,
+		"moov.trak.mdia.hdlr":                               readHdlrBox,
+		"moov.trak.mdia.minf":                               readBoxes,
+		"moov.trak.mdia.minf.vmhd":                          readVmhdBox,
+		"moov.trak.mdia.minf.smhd":                          readSmhdBox,
+		"moov.trak.mdia.minf.hmhd":                          readVmhdBox,
+		"moov.trak.mdia.minf.dinf":                          readBoxes,
+		"moov.trak.mdia.minf.dinf.dref":                     readDrefBox,
+		"moov.trak.mdia.minf.stbl":                          readBoxes,
+		"moov.trak.mdia.minf.stbl.stts":                     readSttsBox,
+		"moov.trak.mdia.minf.stbl.ctts":                     readCttsBox,
+		"moov.trak.mdia.minf.stbl.stsd":                     readStsdBox,
+		"moov.trak.mdia.minf.stbl.stsd.mp4a":                readMp4aBox,
+		"moov.trak.mdia.minf.stbl.stsd.mp4v":                readMp4vBox,
+		"moov.trak.mdia.minf.stbl.stsd.mp4a.esds":           readEsdsBox,
+		"moov.trak.mdia.minf.stbl.stsd.ac-3":                readMp4aBox,
+		"moov.trak.mdia.minf.stbl.stsd.ac-3.dac3":           readDac3Box,
+		"moov.trak.mdia.minf.stbl.stsd.ec-3":                readMp4aBox,
+		"moov.trak.mdia.minf.stbl.stsd.ec-3.dec3":           readDec3Box,
+		"moov.trak.mdia.minf.stbl.stsd.Opus":                readMp4aBox,
+		"moov.trak.mdia.minf.stbl.stsd.Opus.dOps":           readDopsBox,
+		"moov.trak.mdia.minf.stbl.stsd.fLaC":                readMp4aBox,
+		"moov.trak.mdia.minf.stbl.stsd.fLaC.dfLa":           readDflaBox,
+		"moov.trak.mdia.minf.stbl.stsd.mp4v.esdsv":          readEsdsvBox,
+		"moov.trak.mdia.minf.stbl.stsd.mp4v.pasp":           readPaspBox,
+		"moov.trak.mdia.minf.stbl.stsd.mp4v.esds":           readEsdsBox,
+		"moov.trak.mdia.minf.stbl.stsd.mp4v.btrt":           readBtrtBox,
+		"moov.trak.mdia.minf.stbl.stsd.avc1":                readAvc1Box,
+		"moov.trak.mdia.minf.stbl.stsd.avc1.avcC":           readAvcCBox,
+		"moov.trak.mdia.minf.stbl.stsd.avc1.btrt":           readBtrtBox,
+		"moov.trak.mdia.minf.stbl.stsd.hev1":                readHvc1Box,
+		"moov.trak.mdia.minf.stbl.stsd.hev1.hvcC":           readHvcCBox,
+		"moov.trak.mdia.minf.stbl.stsd.hev1.btrt":           readBtrtBox,
+		"moov.trak.mdia.minf.stbl.stsd.hvc1":                readHvc1Box,
+		"moov.trak.mdia.minf.stbl.stsd.hvc1.hvcC":           readHvcCBox,
+		"moov.trak.mdia.minf.stbl.stsd.hvc1.btrt":           readBtrtBox,
+		"moov.trak.mdia.minf.stbl.stsd.vp09":                readAvc1Box,
+		"moov.trak.mdia.minf.stbl.stsd.vp09.vpcC":           readVpcCBox,
+		"moov.trak.mdia.minf.stbl.stsd.vp09.btrt":           readBtrtBox,
+		"moov.trak.mdia.minf.stbl.stsd.av01":                readAvc1Box,
+		"moov.trak.mdia.minf.stbl.stsd.av01.av1C":           readAv1CBox,
+		"moov.trak.mdia.minf.stbl.stsd.av01.btrt":           readBtrtBox,
+		"moov.trak.mdia.minf.stbl.stsd.encv":                readAvc1Box,
+		"moov.trak.mdia.minf.stbl.stsd.encv.avcC":           readAvcCBox,
+		"moov.trak.mdia.minf.stbl.stsd.encv.hvcC":           readHvcCBox,
+		"moov.trak.mdia.minf.stbl.stsd.encv.vpcC":           readVpcCBox,
+		"moov.trak.mdia.minf.stbl.stsd.encv.av1C":           readAv1CBox,
+		"moov.trak.mdia.minf.stbl.stsd.encv.esds":           readEsdsBox,
+		"moov.trak.mdia.minf.stbl.stsd.encv.btrt":           readBtrtBox,
+		"moov.trak.mdia.minf.stbl.stsd.encv.sinf":           readBoxes,
+		"moov.trak.mdia.minf.stbl.stsd.encv.sinf.frma":      readFrmaBox,
+		"moov.trak.mdia.minf.stbl.stsd.encv.sinf.schm":      readSchmBox,
+		"moov.trak.mdia.minf.stbl.stsd.encv.sinf.schi":      readBoxes,
+		"moov.trak.mdia.minf.stbl.stsd.encv.sinf.schi.tenc": readTencBox,
+		"moov.trak.mdia.minf.stbl.stsd.enca":                readMp4aBox,
+		"moov.trak.mdia.minf.stbl.stsd.enca.esds":           readEsdsBox,
+		"moov.trak.mdia.minf.stbl.stsd.enca.dac3":           readDac3Box,
+		"moov.trak.mdia.minf.stbl.stsd.enca.dec3":           readDec3Box,
+		"moov.trak.mdia.minf.stbl.stsd.enca.dOps":           readDopsBox,
+		"moov.trak.mdia.minf.stbl.stsd.enca.dfLa":           readDflaBox,
+		"moov.trak.mdia.minf.stbl.stsd.enca.sinf":           readBoxes,
+		"moov.trak.mdia.minf.stbl.stsd.enca.sinf.frma":      readFrmaBox,
+		"moov.trak.mdia.minf.stbl.stsd.enca.sinf.schm":      readSchmBox,
+		"moov.trak.mdia.minf.stbl.stsd.enca.sinf.schi":      readBoxes,
+		"moov.trak.mdia.minf.stbl.stsd.enca.sinf.schi.tenc": readTencBox,
+		"moov.trak.mdia.minf.stbl.stsc":                     readStscBox,
+		"moov.trak.mdia.minf.stbl.stsz":                     readStszBox,
+		"moov.trak.mdia.minf.stbl.sdtp":                     readSdtpBox,
+		"moov.trak.mdia.minf.stbl.stco":                     readStcoBox,
+		"moov.trak.mdia.minf.stbl.co64":                     readCo64Box,
+		"moov.trak.mdia.minf.stbl.stss":                     readStssBox,
+		"moov.trak.mdia.minf.stbl.senc":                     readSencBox,
+		"moov.trak.mdia.minf.stbl.saiz":                     readSaizBox,
+		"moov.trak.mdia.minf.stbl.saio":                     readSaioBox,
+		"moov.mvex":                                         readBoxes,
+		"moov.mvex.mehd":                                    readMehdBox,
+		"moov.mvex.trex":                                    readTrexBox,
+		"moov.pssh":                                         readPsshBox,
+		"moov.udta":                                         readBoxes,
+		"sidx":                                              readSidxBox,
+		"moof":                                              readBoxes,
+		"moof.mfhd":                                         readMfhdBox,
+		"moof.traf":                                         readBoxes,
+		"moof.traf.tfhd":                                    readTfhdBox,
+		"moof.traf.trun":                                    readTrunBox,
+		"moof.traf.tfdt":                                    readTfdtBox,
+		"mfra":                                              readBoxes,
+		"skip":                                              readBoxes,
+		"skip.udta":                                         readBoxes,
+		"skip.udta.cprt":                                    readBoxes,
+		"meta":                                              readBoxes,
+		"meta.dinf":                                         readBoxes,
+		"meta.ipro":                                         readBoxes,
+		"meta.ipro.sinf":                                    readBoxes,
+		"meta.ipro.sinf.frma":                               readFrmaBox,
+		"meta.flin":                                         readBoxes,
+		"meta.flin.paen":                                    readBoxes,
+		"meco":                                              readBoxes,
+		"mdat":                                              readMdatBox,
 	}
 }
@@ -0,0 +1,371 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// Box with a 32 bits size
+func testBox(name string, payloads ...[]byte) (box []byte) {
+	box = make([]byte, 8)
+	copy(box[4:8], name)
+	for _, payload := range payloads {
+		box = append(box, payload...)
+	}
+	binary.BigEndian.PutUint32(box[0:4], uint32(len(box)))
+
+	return
+}
+
+// Box with a 64 bits largesize
+func testLargeBox(name string, payload []byte) (box []byte) {
+	box = make([]byte, 16)
+	binary.BigEndian.PutUint32(box[0:4], 1)
+	copy(box[4:8], name)
+	binary.BigEndian.PutUint64(box[8:16], uint64(16+len(payload)))
+
+	return append(box, payload...)
+}
+
+// Full box payload: version and flags followed by 32 or 64 bits values
+func testFullBoxPayload(values ...interface{}) (payload []byte) {
+	payload = make([]byte, 4)
+	for _, value := range values {
+		data := make([]byte, binary.Size(value))
+		switch v := value.(type) {
+		case uint32:
+			binary.BigEndian.PutUint32(data, v)
+		case uint64:
+			binary.BigEndian.PutUint64(data, v)
+		}
+		payload = append(payload, data...)
+	}
+
+	return
+}
+
+func writeTestFile(t *testing.T, data []byte) (filename string) {
+	dir, err := ioutil.TempDir("", "mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename = path.Join(dir, "test.mp4")
+	err = ioutil.WriteFile(filename, data, 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestReadBox(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     []byte
+		boxSize    uint64
+		headerSize uint64
+		boxName    string
+	}{
+		{"32 bits size", testBox("free", []byte{1, 2, 3, 4})[:8], 12, 8, "free"},
+		{"largesize", []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 1, 0, 0, 0, 0x10}, 0x100000010, 16, "mdat"},
+		{"size 0", []byte{0, 0, 0, 0, 'm', 'd', 'a', 't'}, 0, 8, "mdat"},
+	}
+	for _, test := range tests {
+		filename := writeTestFile(t, append(test.header, 0xFF))
+		defer os.RemoveAll(path.Dir(filename))
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		boxSize, headerSize, boxName := readBox(f, 0)
+		offset, _ := f.Seek(0, os.SEEK_CUR)
+		f.Close()
+		if boxSize != test.boxSize || headerSize != test.headerSize || boxName != test.boxName {
+			t.Errorf("%s: box %q of %d bytes with a %d bytes header, want %q of %d bytes with a %d bytes header", test.name,
+				boxName, boxSize, headerSize, test.boxName, test.boxSize, test.headerSize)
+		}
+		if uint64(offset) != test.headerSize {
+			t.Errorf("%s: read %d bytes of the %d bytes header", test.name, offset, test.headerSize)
+		}
+	}
+}
+
+func TestParseFileLargeBoxes(t *testing.T) {
+	ftyp := testBox("ftyp", []byte("isom"), make([]byte, 4), []byte("isom"))
+	samples := byteRange(0, 100)
+	tests := []struct {
+		name       string
+		data       []byte
+		mdatOffset int64
+		mdatSize   uint64
+		free       string // Data of the FREE Box after MDAT or MOOV Box
+	}{
+		{"largesize mdat", append(append(append([]byte{}, ftyp...), testLargeBox("mdat", samples)...), testBox("free", []byte("end"))...), 20 + 16, 100, "end"},
+		{"size 0 mdat until the end of the file", append(append([]byte{}, ftyp...), append([]byte{0, 0, 0, 0, 'm', 'd', 'a', 't'}, samples...)...), 20 + 8, 100, ""},
+		{"largesize free", append(append([]byte{}, ftyp...), testLargeBox("free", []byte("end"))...), 0, 0, "end"},
+		{"size 0 box until the end of its parent", append(append([]byte{}, ftyp...), append(testBox("moov", []byte{0, 0, 0, 0, 's', 'k', 'i', 'p'}, samples), testBox("free", []byte("end"))...)...), 0, 0, "end"},
+	}
+	for _, test := range tests {
+		filename := writeTestFile(t, test.data)
+		defer os.RemoveAll(path.Dir(filename))
+		mp4 := ParseFile(filename, "und")
+		if test.mdatSize == 0 {
+			if mp4.Boxes["mdat"] != nil {
+				t.Errorf("%s: MDAT Box %+v", test.name, mp4.Boxes["mdat"][0])
+			}
+		} else if mp4.Boxes["mdat"] == nil {
+			t.Errorf("%s: no MDAT Box", test.name)
+		} else {
+			mdat := mp4.Boxes["mdat"][0].(MdatBox)
+			if mdat.Offset != test.mdatOffset || mdat.Size != test.mdatSize {
+				t.Errorf("%s: MDAT Box of %d bytes at %d, want %d bytes at %d", test.name, mdat.Size, mdat.Offset, test.mdatSize, test.mdatOffset)
+			}
+		}
+		if test.free == "" {
+			if mp4.Boxes["free"] != nil {
+				t.Errorf("%s: FREE Box %+v", test.name, mp4.Boxes["free"][0])
+			}
+		} else if mp4.Boxes["free"] == nil || string(mp4.Boxes["free"][0].(FreeBox).Data) != test.free {
+			t.Errorf("%s: FREE Box %v, want %q", test.name, mp4.Boxes["free"], test.free)
+		}
+	}
+}
+
+func TestParseFileCo64(t *testing.T) {
+	chunkOffsets := []uint64{0x100000000, 0x100000100, 0x1FFFFFFF0}
+	// 2 samples per chunk
+	stsc := testBox("stsc", testFullBoxPayload(uint32(1), uint32(1), uint32(2), uint32(1)))
+	co64 := testBox("co64", testFullBoxPayload(uint32(len(chunkOffsets)), chunkOffsets[0], chunkOffsets[1], chunkOffsets[2]))
+	// Entry count larger than the box
+	co64[15] = 5
+	moov := testBox("moov", testBox("trak", testBox("mdia", testBox("minf", testBox("stbl", stsc, co64)))))
+	filename := writeTestFile(t, moov)
+	defer os.RemoveAll(path.Dir(filename))
+
+	mp4 := ParseFile(filename, "und")
+	if mp4.Boxes["moov.trak.mdia.minf.stbl.co64"] == nil || mp4.Boxes["moov.trak.mdia.minf.stbl.stsc"] == nil {
+		t.Fatalf("no CO64 or STSC Box")
+	}
+	co64Box := mp4.Boxes["moov.trak.mdia.minf.stbl.co64"][0].(Co64Box)
+	if co64Box.EntryCount != 3 || len(co64Box.ChunkOffset) != 3 {
+		t.Fatalf("CO64 Box %+v", co64Box)
+	}
+	for i := range chunkOffsets {
+		if co64Box.ChunkOffset[i] != chunkOffsets[i] {
+			t.Errorf("chunk offset %d: 0x%X, want 0x%X", i, co64Box.ChunkOffset[i], chunkOffsets[i])
+		}
+	}
+
+	// Chunk tables read from a DashConfig, only until the chunk of the last sample
+	stscBox := mp4.Boxes["moov.trak.mdia.minf.stbl.stsc"][0].(StscBox)
+	var dConf DashConfig
+	dConf.StscBoxOffset = stscBox.Offset
+	dConf.StscBoxSize = stscBox.Size
+	dConf.Co64BoxOffset = co64Box.Offset
+	dConf.Co64BoxSize = co64Box.Size
+	tests := []struct {
+		sampleCount uint32
+		chunks      int
+	}{
+		{1, 1},
+		{3, 2},
+		{6, 3},
+		{100, 3},
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, test := range tests {
+		stsc, offsets := readChunkTablesWithConf(f, dConf, test.sampleCount)
+		if stsc == nil || len(offsets) != test.chunks {
+			t.Errorf("%d samples: STSC Box %v and chunk offsets %X, want %d chunks", test.sampleCount, stsc, offsets, test.chunks)
+			continue
+		}
+		for i := range offsets {
+			if offsets[i] != chunkOffsets[i] {
+				t.Errorf("%d samples: chunk offset %d: 0x%X, want 0x%X", test.sampleCount, i, offsets[i], chunkOffsets[i])
+			}
+		}
+	}
+
+	// Samples of 10 bytes located after 4 GB
+	var tables dashSampleTables
+	tables.stsz.SampleSize = 10
+	tables.stsc, tables.chunkOffsets = readChunkTablesWithConf(f, dConf, 6)
+	chunks := sampleChunks(dConf, tables, 1, 4)
+	want := []MdatChunk{{0x10000000A, 10}, {0x100000100, 20}, {0x1FFFFFFF0, 10}}
+	if len(chunks) != len(want) {
+		t.Fatalf("chunks %X, want %X", chunks, want)
+	}
+	for i := range chunks {
+		if chunks[i] != want[i] {
+			t.Errorf("chunks %X, want %X", chunks, want)
+			break
+		}
+	}
+}
+
+// mp4v sample entry of a width x height video with its child boxes
+func testMp4vBox(width uint16, height uint16, children ...[]byte) []byte {
+	entry := make([]byte, 78)
+	binary.BigEndian.PutUint16(entry[6:8], 1)
+	binary.BigEndian.PutUint16(entry[24:26], width)
+	binary.BigEndian.PutUint16(entry[26:28], height)
+
+	return testBox("mp4v", append([][]byte{entry}, children...)...)
+}
+
+func TestReadMp4vBox(t *testing.T) {
+	pasp := testBox("pasp", []byte{0, 0, 0, 4, 0, 0, 0, 3})
+	tests := []struct {
+		name  string
+		box   []byte
+		width uint16 // 0 if the box is skipped
+		pasp  bool
+	}{
+		{"empty", testBox("mp4v"), 0, false},
+		{"40 bytes", testBox("mp4v", byteRange(0, 40)), 0, false},
+		{"77 bytes", testBox("mp4v", byteRange(0, 77)), 0, false},
+		{"sample entry", testMp4vBox(640, 480), 640, false},
+		{"sample entry with a PASP Box", testMp4vBox(640, 480, pasp), 640, true},
+	}
+	for _, test := range tests {
+		// The following sample entry is read from the end of the tested one
+		data := append(append([]byte{}, test.box...), testMp4vBox(1280, 720)...)
+		filename := writeTestFile(t, data)
+		defer os.RemoveAll(path.Dir(filename))
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		boxes := make(map[string][]interface{})
+		readBoxes(f, uint32(len(data)), 0, "moov.trak.mdia.minf.stbl.stsd", boxes)
+		f.Close()
+
+		var widths []uint16
+		for _, box := range boxes["moov.trak.mdia.minf.stbl.stsd.mp4v"] {
+			widths = append(widths, box.(Mp4vBox).Width)
+		}
+		want := []uint16{1280}
+		if test.width != 0 {
+			want = []uint16{test.width, 1280}
+		}
+		if fmt.Sprint(widths) != fmt.Sprint(want) {
+			t.Errorf("%s: sample entries of widths %v, want %v", test.name, widths, want)
+		}
+		if (boxes["moov.trak.mdia.minf.stbl.stsd.mp4v.pasp"] != nil) != test.pasp {
+			t.Errorf("%s: PASP Box %v", test.name, boxes["moov.trak.mdia.minf.stbl.stsd.mp4v.pasp"])
+		}
+	}
+}
+
+// TRAK Box of a track with its TKHD Box and its sample tables, its samples are in chunks of 2 samples
+func testTrakBox(trackId uint32, sampleSizes []uint32, chunkOffsets []uint32) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:16], trackId)
+	stsz := []interface{}{uint32(0), uint32(len(sampleSizes))}
+	for _, size := range sampleSizes {
+		stsz = append(stsz, size)
+	}
+	stco := []interface{}{uint32(len(chunkOffsets))}
+	for _, offset := range chunkOffsets {
+		stco = append(stco, offset)
+	}
+	stbl := testBox("stbl", testBox("stsz", testFullBoxPayload(stsz...)), testBox("stsc", testFullBoxPayload(uint32(1), uint32(1), uint32(2), uint32(1))),
+		testBox("stco", testFullBoxPayload(stco...)))
+
+	return testBox("trak", testBox("tkhd", tkhd), testBox("mdia", testBox("minf", stbl)))
+}
+
+func TestParseFileTracks(t *testing.T) {
+	const stbl = "moov.trak.mdia.minf.stbl"
+	tracks := []struct {
+		trackId      uint32
+		sampleSizes  []uint32
+		chunkOffsets []uint32
+		chunks       []MdatChunk
+	}{
+		{2, []uint32{10, 20, 30}, []uint32{1000, 2000}, []MdatChunk{{1000, 30}, {2000, 30}}},
+		{1, []uint32{5, 6, 7, 8}, []uint32{3000, 4000}, []MdatChunk{{3000, 11}, {4000, 15}}},
+	}
+	var traks [][]byte
+	for _, track := range tracks {
+		traks = append(traks, testTrakBox(track.trackId, track.sampleSizes, track.chunkOffsets))
+	}
+	filename := writeTestFile(t, append(testBox("moov", traks...), testBox("mdat", byteRange(0, 16))...))
+	defer os.RemoveAll(path.Dir(filename))
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	mp4 := ParseFile(filename, "und")
+	if fmt.Sprint(mp4.TrackIds()) != "[1 2]" {
+		t.Errorf("track ids %v, want [1 2]", mp4.TrackIds())
+	}
+	// The flat map has the boxes of all the tracks in the order of the file
+	if len(mp4.Boxes["moov.trak"]) != 2 || len(mp4.Boxes[stbl+".stsz"]) != 2 || mp4.Boxes[stbl+".stsz"][0].(StszBox).SampleCount != 3 {
+		t.Errorf("boxes of the tracks %v", mp4.Boxes[stbl+".stsz"])
+	}
+	for _, test := range tracks {
+		track := mp4.Track(test.trackId)
+		if track.TrackId != test.trackId || track.Boxes["moov.trak"] != nil || track.Boxes["mdat"] == nil {
+			t.Errorf("track %d: track id %d, TRAK Boxes %v, MDAT Box %v", test.trackId, track.TrackId, track.Boxes["moov.trak"], track.Boxes["mdat"])
+		}
+		if len(track.Boxes["moov.trak.tkhd"]) != 1 || track.Boxes["moov.trak.tkhd"][0].(TkhdBox).TrackID != test.trackId {
+			t.Errorf("track %d: TKHD Boxes %v", test.trackId, track.Boxes["moov.trak.tkhd"])
+		}
+		if len(track.Boxes[stbl+".stsz"]) != 1 || len(track.Boxes[stbl+".stsc"]) != 1 || len(track.Boxes[stbl+".stco"]) != 1 {
+			t.Errorf("track %d: STSZ, STSC and STCO Boxes %v %v %v", test.trackId, track.Boxes[stbl+".stsz"], track.Boxes[stbl+".stsc"], track.Boxes[stbl+".stco"])
+			continue
+		}
+		stsz := track.Boxes[stbl+".stsz"][0].(StszBox)
+		stsc := track.Boxes[stbl+".stsc"][0].(StscBox)
+		stco := track.Boxes[stbl+".stco"][0].(StcoBox)
+		if fmt.Sprint(stsz.EntrySize) != fmt.Sprint(test.sampleSizes) || fmt.Sprint(stco.ChunkOffset) != fmt.Sprint(test.chunkOffsets) ||
+			len(stsc.Entries) != 1 || stsc.Entries[0].SamplesPerChunk != 2 {
+			t.Errorf("track %d: sample sizes %v, chunk offsets %v, STSC entries %v", test.trackId, stsz.EntrySize, stco.ChunkOffset, stsc.Entries)
+		}
+
+		// Samples located from the tables of the track
+		var dConf DashConfig
+		dConf.StszBoxOffset = stsz.Offset
+		dConf.StszBoxSize = uint64(stsz.Size)
+		dConf.StscBoxOffset = stsc.Offset
+		dConf.StscBoxSize = stsc.Size
+		dConf.StcoBoxOffset = stco.Offset
+		dConf.StcoBoxSize = stco.Size
+		sampleCount := uint32(len(test.sampleSizes))
+		var tables dashSampleTables
+		tables.stsz = readStszBoxWithConf(f, dConf, sampleCount)
+		tables.stsc, tables.chunkOffsets = readChunkTablesWithConf(f, dConf, sampleCount)
+		if chunks := sampleChunks(dConf, tables, 0, sampleCount-1); fmt.Sprint(chunks) != fmt.Sprint(test.chunks) {
+			t.Errorf("track %d: chunks %v, want %v", test.trackId, chunks, test.chunks)
+		}
+	}
+
+	// A file with one track has the same boxes in its flat map and in its track
+	filename = writeTestFile(t, testBox("moov", testTrakBox(1, tracks[0].sampleSizes, tracks[0].chunkOffsets)))
+	defer os.RemoveAll(path.Dir(filename))
+	mp4 = ParseFile(filename, "und")
+	track := mp4.Track(1)
+	if fmt.Sprint(mp4.TrackIds()) != "[1]" || len(mp4.Boxes) != len(track.Boxes)+1 || len(mp4.Boxes["moov.trak"]) != 1 {
+		t.Errorf("track ids %v, %d boxes and %d boxes in the track", mp4.TrackIds(), len(mp4.Boxes), len(track.Boxes))
+	}
+	for boxPath, boxes := range track.Boxes {
+		if fmt.Sprint(mp4.Boxes[boxPath]) != fmt.Sprint(boxes) {
+			t.Errorf("%s: boxes %v, want %v", boxPath, mp4.Boxes[boxPath], boxes)
+		}
+	}
+	if stsz := mp4.Boxes[stbl+".stsz"]; len(stsz) != 1 || fmt.Sprint(stsz[0].(StszBox).EntrySize) != fmt.Sprint(tracks[0].sampleSizes) {
+		t.Errorf("STSZ Boxes %v", stsz)
+	}
+}
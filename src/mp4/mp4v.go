@@ -0,0 +1,65 @@
+// Copyright (c) 2015
+//      Sebastien Petit & Afrostream - www.afrostream.tv - spebsd@gmail.com.
+//      All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+// LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+// OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+// SUCH DAMAGE.
+
+package mp4
+
+import (
+	"bytes"
+)
+
+// MPEG-4 visual object type indication of the DecoderConfigDescriptor (ISO/IEC 14496-1)
+const mpeg4VisualObjectTypeIndication = 0x20
+
+// Start code of the visual object sequence header of MPEG-4 Part 2 streams (ISO/IEC 14496-2 6.2.2)
+var visualObjectSequenceStartCode = []byte{0x00, 0x00, 0x01, 0xB0}
+
+// ***
+// *** Public functions
+// ***
+
+// Read the video configuration of the ESDS Box of an mp4v track: object type indication and, for MPEG-4 Part 2,
+// the profile and level indication of the visual object sequence header in the DecoderSpecificInfo
+func ReadEsdsVideoConfig(esds EsdsBox, video *DashVideoEntry) (err error) {
+	objectTypeIndication, decoderSpecificInfo, err := readEsdsDecoderConfig(esds)
+	if err != nil {
+		return
+	}
+	video.Esds = &esds
+	video.ObjectTypeIndication = objectTypeIndication
+	if objectTypeIndication != mpeg4VisualObjectTypeIndication {
+		return
+	}
+	if i := bytes.Index(decoderSpecificInfo, visualObjectSequenceStartCode); i >= 0 && i+4 < len(decoderSpecificInfo) {
+		video.ProfileLevelIndication = decoderSpecificInfo[i+4]
+	}
+
+	return
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2015
+//      Sebastien Petit & Afrostream - www.afrostream.tv - spebsd@gmail.com.
+//      All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+// LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+// OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+// SUCH DAMAGE.
+
+package mp4
+
+import (
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Limits of the SIDX Box: 16 bits reference count and 31 bits referenced sizes
+const (
+	maxSidxReferences     = 0xFFFF
+	maxSidxReferencedSize = 0x7FFFFFFF
+)
+
+// Single fragmented MP4 file of a DASH on-demand representation, it is never written on disk:
+// the init segment is followed by a SIDX Box indexing all fragments, then by the fragments (MOOF and MDAT Boxes)
+type DashOnDemandFile struct {
+	Filename         string
+	Config           DashConfig
+	FragmentDuration uint32
+	Header           []byte // Init segment followed by the SIDX Box
+	InitSize         int64  // Size of the init segment, the SIDX Box starts at this offset
+	Size             int64  // Size of the whole file
+	Segments         []DashSegment
+	Offsets          []int64 // Offset of each fragment in the file
+
+	tables       dashSampleTables
+	mutex        sync.Mutex
+	lastFragment int // Last fragment generated, kept for the following reads of the same fragment
+	lastData     []byte
+}
+
+// Create a fragment of the on-demand file, STYP and FREE Boxes are not needed in a single file
+func (file *DashOnDemandFile) fragment(i int) (fmp4 map[string][]interface{}) {
+	fmp4 = createDashFragmentWithTables(file.Config, file.Filename, file.tables, file.Segments[i].Number, file.FragmentDuration)
+	if fmp4 == nil {
+		return
+	}
+	delete(fmp4, "styp")
+	delete(fmp4, "free")
+
+	return
+}
+
+func (file *DashOnDemandFile) fragmentBytes(i int) (data []byte) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	if file.lastData != nil && file.lastFragment == i {
+		return file.lastData
+	}
+	data = MapToBytes(file.fragment(i))
+	file.lastFragment = i
+	file.lastData = data
+
+	return
+}
+
+// ***
+// *** Public functions
+// ***
+
+// Create the layout of the on-demand file of a track, all sample tables are read once and kept for the fragments
+func CreateDashOnDemandFileWithConf(dConf DashConfig, filename string, fragmentDuration uint32) (file *DashOnDemandFile) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var tables dashSampleTables
+	mp4 := make(map[string][]interface{})
+	if dConf.Type == "video" && dConf.Video.CttsBoxOffset != 0 {
+		f.Seek(dConf.Video.CttsBoxOffset, 0)
+		readCttsBox(f, dConf.Video.CttsBoxSize, 0, "moov.trak.mdia.minf.stbl.ctts", mp4)
+		ctts := mp4["moov.trak.mdia.minf.stbl.ctts"][0].(CttsBox)
+		tables.ctts = &ctts
+	}
+	if dConf.Type == "video" {
+		tables.stss = readStssBoxWithConf(f, dConf, mp4)
+	}
+	tables.stsz = readStszBoxWithConf(f, dConf, readSampleCountWithConf(f, dConf))
+	tables.stsc, tables.chunkOffsets = readChunkTablesWithConf(f, dConf, tables.stsz.SampleCount)
+	if dConf.Protection != nil {
+		tables.auxInfo, err = readSampleAuxInfoWithConf(f, dConf, tables.stsz.SampleCount)
+		if err != nil {
+			return
+		}
+	}
+
+	segments := GetDashSegmentsWithConf(dConf, filename, fragmentDuration)
+	if segments == nil || len(segments) > maxSidxReferences {
+		// A single SIDX Box indexes at most 65535 fragments
+		return
+	}
+	file = &DashOnDemandFile{Filename: filename, Config: dConf, FragmentDuration: fragmentDuration, Segments: segments, tables: tables}
+
+	// SIDX
+	var sidx SidxBox
+	sidx.Version = 1
+	sidx.ReferenceId = 1
+	sidx.Timescale = dConf.Timescale
+	sidx.EarliestPresentationTime = segments[0].Time
+	sidx.FirstOffset = 0
+	sidx.ReferenceCount = uint16(len(segments))
+	sidx.References = make([]SidxReference, len(segments))
+	// The fragments are sized without reading their samples, the ones of an encrypted track are encrypted only when
+	// they are read
+	layoutConf := dConf
+	layoutConf.Encryption = nil
+	for i, segment := range segments {
+		fmp4 := createDashFragmentWithTables(layoutConf, filename, tables, segment.Number, fragmentDuration)
+		if fmp4 == nil {
+			return nil
+		}
+		if dConf.Protection == nil && dConf.Encryption != nil && layoutEncryptedDashFragment(dConf, f, fmp4, segment.SampleStart) != nil {
+			return nil
+		}
+		moof := fmp4["moof"][0].(ParentBox)
+		mdat := fmp4["mdat"][0].(MdatBox)
+		referencedSize := uint64(moof.Size) + 8 + mdat.Size + 8
+		if referencedSize > maxSidxReferencedSize {
+			return nil
+		}
+		sidx.References[i].ReferenceType = 0
+		sidx.References[i].ReferencedSize = uint32(referencedSize)
+		sidx.References[i].SubsegmentDuration = uint32(segment.Duration)
+		sidx.References[i].StartsWithSap = 1
+		sidx.References[i].SapType = 1
+		sidx.References[i].SapDeltaTime = 0
+	}
+	sidx.Size = 32 + 12*uint32(len(segments))
+
+	file.Header = MapToBytes(CreateDashInitWithConf(dConf))
+	file.InitSize = int64(len(file.Header))
+	file.Header = append(file.Header, sidx.Bytes()...)
+	file.Size = int64(len(file.Header))
+	file.Offsets = make([]int64, len(segments))
+	for i, reference := range sidx.References {
+		file.Offsets[i] = file.Size
+		file.Size += int64(reference.ReferencedSize)
+	}
+
+	return
+}
+
+// Implements io.ReaderAt, only the fragments in the requested range are generated
+func (file *DashOnDemandFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off >= file.Size {
+		return 0, io.EOF
+	}
+	for n < len(p) && off < file.Size {
+		var count int
+		if off < int64(len(file.Header)) {
+			count = copy(p[n:], file.Header[off:])
+		} else {
+			i := sort.Search(len(file.Offsets), func(i int) bool { return file.Offsets[i] > off }) - 1
+			data := file.fragmentBytes(i)
+			if off-file.Offsets[i] >= int64(len(data)) {
+				return n, io.ErrUnexpectedEOF
+			}
+			count = copy(p[n:], data[off-file.Offsets[i]:])
+		}
+		n += count
+		off += int64(count)
+	}
+	if n < len(p) {
+		err = io.EOF
+	}
+
+	return
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2015
+//      Sebastien Petit & Afrostream - www.afrostream.tv - spebsd@gmail.com.
+//      All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+// LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+// OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+// SUCH DAMAGE.
+
+package mp4
+
+import (
+	"errors"
+	"log"
+	"os"
+)
+
+// Protection of a track whose samples are already encrypted in the source file (encv/enca sample entry), the
+// samples are copied as they are with their sample auxiliary information and the protection boxes of the source
+type DashProtection struct {
+	Scheme          string   // SCHM Box scheme type (eg: "cenc", "cbcs")
+	SchemeVersion   uint32   // SCHM Box scheme version (eg: 0x00010000)
+	TencVersion     byte     // TENC Box version, 1 with a pattern
+	CryptByteBlock  byte     // TENC Box pattern, encrypted 16 bytes blocks
+	SkipByteBlock   byte     // TENC Box pattern, clear 16 bytes blocks
+	IsProtected     byte     // TENC Box default_isProtected
+	PerSampleIvSize byte     // TENC Box default_Per_Sample_IV_Size, 0 with a constant IV
+	KeyId           [16]byte // TENC Box default_KID
+	ConstantIv      []byte   `json:",omitempty"` // TENC Box default_constant_IV
+	Pssh            []string `json:",omitempty"` // Base64 PSSH Boxes of the source file
+	SaizBoxOffset   int64    // SAIZ Box of the source file, 0 if there is only a SENC Box
+	SaizBoxSize     uint32
+	Subsamples      bool   // Without SAIZ Box, SENC Box has subsamples (flag 0x000002)
+	AuxInfoOffset   int64  // Offset of the sample auxiliary information of the first sample in the source file
+	AuxInfoSize     uint32 // Size of the sample auxiliary information of all the samples, 0 with a constant IV without subsamples
+}
+
+func (protection DashProtection) tencBox() (tenc TencBox) {
+	tenc.Version = protection.TencVersion
+	tenc.DefaultCryptByteBlock = protection.CryptByteBlock
+	tenc.DefaultSkipByteBlock = protection.SkipByteBlock
+	tenc.DefaultIsProtected = protection.IsProtected
+	tenc.DefaultPerSampleIvSize = protection.PerSampleIvSize
+	tenc.DefaultKid = protection.KeyId
+	tenc.Size = 24
+	if tenc.DefaultIsProtected == 1 && tenc.DefaultPerSampleIvSize == 0 {
+		tenc.DefaultConstantIvSize = byte(len(protection.ConstantIv))
+		tenc.DefaultConstantIv = protection.ConstantIv
+		tenc.Size += 1 + uint32(tenc.DefaultConstantIvSize)
+	}
+
+	return
+}
+
+// Add the protection boxes of the source file of a pre-encrypted track to its init segment
+func protectDashInit(dConf DashConfig, mp4Init map[string][]interface{}) {
+	var psshBoxes []PsshBox
+	for _, b64 := range dConf.Protection.Pssh {
+		pssh, err := ParsePsshBox(b64)
+		if err != nil {
+			if debugMode {
+				log.Printf("ERROR: cannot read PSSH Box of the source: %v", err)
+			}
+			continue
+		}
+		psshBoxes = append(psshBoxes, pssh)
+	}
+	addDashInitProtection(dConf, mp4Init, dConf.Protection.Scheme, dConf.Protection.SchemeVersion, dConf.Protection.tencBox(), psshBoxes)
+}
+
+// Read the sample auxiliary information (IV and subsamples) of a pre-encrypted track described by a DashConfig,
+// from the first sample until sampleEnd
+func readSampleAuxInfoWithConf(f *os.File, dConf DashConfig, sampleEnd uint32) (auxInfo *SencBox, err error) {
+	protection := dConf.Protection
+	auxInfo = new(SencBox)
+	if protection.AuxInfoSize == 0 {
+		return
+	}
+	var saiz *SaizBox
+	if protection.SaizBoxOffset != 0 {
+		flags := make([]byte, 4)
+		_, err = f.ReadAt(flags, protection.SaizBoxOffset)
+		if err != nil {
+			return
+		}
+		// Version and flags, aux_info_type and aux_info_type_parameter if flags & 1, default_sample_info_size and
+		// sample_count, then the sizes of the samples: read SAIZ Box only until sampleEnd
+		saizSize := uint32(9)
+		if flags[3]&0x01 != 0 {
+			saizSize += 8
+		}
+		saizSize += sampleEnd + 1
+		if saizSize > protection.SaizBoxSize {
+			saizSize = protection.SaizBoxSize
+		}
+		mp4 := make(map[string][]interface{})
+		f.Seek(protection.SaizBoxOffset, 0)
+		readSaizBox(f, saizSize, 0, "moov.trak.mdia.minf.stbl.saiz", mp4)
+		box := mp4["moov.trak.mdia.minf.stbl.saiz"][0].(SaizBox)
+		saiz = &box
+	}
+	// Read the sample auxiliary information only until sampleEnd, its size is unknown without SAIZ Box when the
+	// samples have subsamples
+	size := uint64(protection.AuxInfoSize)
+	if saiz != nil && saiz.DefaultSampleInfoSize != 0 {
+		size = uint64(saiz.DefaultSampleInfoSize) * (uint64(sampleEnd) + 1)
+	} else if saiz != nil {
+		size = 0
+		for _, infoSize := range saiz.SampleInfoSize {
+			size += uint64(infoSize)
+		}
+	} else if !protection.Subsamples {
+		size = uint64(protection.PerSampleIvSize) * (uint64(sampleEnd) + 1)
+	}
+	if size > uint64(protection.AuxInfoSize) {
+		size = uint64(protection.AuxInfoSize)
+	}
+	data := make([]byte, size)
+	_, err = f.ReadAt(data, protection.AuxInfoOffset)
+	if err != nil {
+		return
+	}
+
+	ivSize := int(protection.PerSampleIvSize)
+	offset := 0
+	for i := uint32(0); i <= sampleEnd && offset < len(data); i++ {
+		subsamples := protection.Subsamples
+		infoSize := 0
+		if saiz != nil {
+			if saiz.DefaultSampleInfoSize != 0 {
+				infoSize = int(saiz.DefaultSampleInfoSize)
+			} else if i < uint32(len(saiz.SampleInfoSize)) {
+				infoSize = int(saiz.SampleInfoSize[i])
+			} else {
+				break
+			}
+			subsamples = infoSize > ivSize
+		}
+		var sample SencSample
+		if offset+ivSize > len(data) {
+			err = errors.New("truncated sample auxiliary information")
+			return
+		}
+		sample.Iv = data[offset : offset+ivSize]
+		position := offset + ivSize
+		if subsamples {
+			if position+2 > len(data) {
+				err = errors.New("truncated sample auxiliary information")
+				return
+			}
+			count := int(data[position])<<8 | int(data[position+1])
+			position += 2
+			if position+6*count > len(data) {
+				err = errors.New("truncated sample auxiliary information")
+				return
+			}
+			sample.Subsamples = make([]SencSubsample, count)
+			for j := range sample.Subsamples {
+				sample.Subsamples[j].BytesOfClearData = uint16(data[position])<<8 | uint16(data[position+1])
+				sample.Subsamples[j].BytesOfProtectedData = uint32(data[position+2])<<24 | uint32(data[position+3])<<16 | uint32(data[position+4])<<8 | uint32(data[position+5])
+				position += 6
+			}
+		}
+		if saiz == nil {
+			infoSize = position - offset
+		}
+		offset += infoSize
+		auxInfo.Samples = append(auxInfo.Samples, sample)
+		if subsamples {
+			auxInfo.Flags = [3]byte{0x00, 0x00, 0x02}
+		}
+	}
+	auxInfo.SampleCount = uint32(len(auxInfo.Samples))
+
+	return
+}
+
+// Add the sample auxiliary information of the source file to a fragment of a pre-encrypted track, its samples are
+// already encrypted
+// sampleStart is the index of the first sample of the fragment in the track
+func protectDashFragment(dConf DashConfig, fmp4 map[string][]interface{}, auxInfo *SencBox, sampleStart uint32) (err error) {
+	if dConf.Protection.AuxInfoSize == 0 {
+		return
+	}
+	trun := fmp4["moof.traf.trun"][0].(TrunBox)
+	if auxInfo == nil || uint64(sampleStart)+uint64(trun.SampleCount) > uint64(len(auxInfo.Samples)) {
+		err = errors.New("missing sample auxiliary information")
+		return
+	}
+	var senc SencBox
+	senc.Version = 0
+	senc.Flags = auxInfo.Flags
+	senc.SampleCount = trun.SampleCount
+	senc.Samples = auxInfo.Samples[sampleStart : sampleStart+trun.SampleCount]
+	growDashFragment(fmp4, addSencBox(fmp4, senc), 0)
+
+	return
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2015
+//      Sebastien Petit & Afrostream - www.afrostream.tv - spebsd@gmail.com.
+//      All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+// LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+// OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+// SUCH DAMAGE.
+
+package mp4
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"unicode/utf16"
+)
+
+var (
+	WidevineSystemId  = [16]byte{0xed, 0xef, 0x8b, 0xa9, 0x79, 0xd6, 0x4a, 0xce, 0xa3, 0xc8, 0x27, 0xdc, 0xd5, 0x1d, 0x21, 0xed}
+	PlayReadySystemId = [16]byte{0x9a, 0x04, 0xf0, 0x79, 0x98, 0x40, 0x42, 0x86, 0xab, 0x92, 0xe6, 0x5b, 0xe0, 0x88, 0x5f, 0x95}
+	// W3C Common PSSH Box, used by Clear Key
+	CommonSystemId = [16]byte{0x10, 0x77, 0xef, 0xec, 0xc0, 0xb2, 0x4d, 0x02, 0xac, 0xe3, 0x3c, 0x1e, 0x52, 0xe2, 0xfb, 0x4b}
+)
+
+func appendVarint(data []byte, v uint64) []byte {
+	for v >= 0x80 {
+		data = append(data, byte(v)|0x80)
+		v >>= 7
+	}
+
+	return append(data, byte(v))
+}
+
+// Protobuf length delimited field
+func appendProtobufBytes(data []byte, field uint64, value []byte) []byte {
+	data = appendVarint(data, field<<3|2)
+	data = appendVarint(data, uint64(len(value)))
+
+	return append(data, value...)
+}
+
+// Widevine PSSH Box, its data is a WidevinePsshData protobuf message with the key ids, the provider,
+// the content id and the protection scheme
+func WidevinePsshBox(keyIds [][16]byte, provider string, contentId []byte, scheme string) (pssh PsshBox) {
+	var data []byte
+	for _, keyId := range keyIds {
+		data = appendProtobufBytes(data, 2, keyId[:])
+	}
+	if provider != "" {
+		data = appendProtobufBytes(data, 3, []byte(provider))
+	}
+	if len(contentId) > 0 {
+		data = appendProtobufBytes(data, 4, contentId)
+	}
+	if scheme != "" {
+		data = appendVarint(data, 9<<3|0)
+		data = appendVarint(data, uint64(binary.BigEndian.Uint32([]byte(scheme))))
+	}
+	pssh.Version = 0
+	pssh.SystemId = WidevineSystemId
+	pssh.DataSize = uint32(len(data))
+	pssh.Data = data
+	pssh.Size = 24 + pssh.DataSize
+
+	return
+}
+
+// W3C Common PSSH Box, version 1 with the key ids and without data
+func CommonPsshBox(keyIds [][16]byte) (pssh PsshBox) {
+	pssh.Version = 1
+	pssh.SystemId = CommonSystemId
+	pssh.KidCount = uint32(len(keyIds))
+	pssh.Kids = keyIds
+	pssh.DataSize = 0
+	pssh.Size = 28 + 16*pssh.KidCount
+
+	return
+}
+
+// PlayReady uses key ids as little endian GUIDs
+func playReadyKeyId(keyId [16]byte) (guid [16]byte) {
+	guid = keyId
+	guid[0], guid[1], guid[2], guid[3] = keyId[3], keyId[2], keyId[1], keyId[0]
+	guid[4], guid[5] = keyId[5], keyId[4]
+	guid[6], guid[7] = keyId[7], keyId[6]
+
+	return
+}
+
+// PlayReady Header Object with one rights management header (WRMHEADER 4.0.0.0 for cenc, 4.3.0.0 for cbcs)
+func PlayReadyHeaderObject(keyId [16]byte, key [16]byte, scheme string, laUrl string) (pro []byte) {
+	guid := playReadyKeyId(keyId)
+	kid := base64.StdEncoding.EncodeToString(guid[:])
+	var header bytes.Buffer
+	if scheme == "cbcs" {
+		header.WriteString(`<WRMHEADER xmlns="http://schemas.microsoft.com/DRM/2007/03/PlayReadyHeader" version="4.3.0.0"><DATA><PROTECTINFO><KIDS>`)
+		header.WriteString(`<KID ALGID="AESCBC" VALUE="` + kid + `"></KID></KIDS></PROTECTINFO>`)
+	} else {
+		// The checksum is the first 8 bytes of the key id encrypted with the content key
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return
+		}
+		checksum := make([]byte, aes.BlockSize)
+		block.Encrypt(checksum, guid[:])
+		header.WriteString(`<WRMHEADER xmlns="http://schemas.microsoft.com/DRM/2007/03/PlayReadyHeader" version="4.0.0.0"><DATA><PROTECTINFO><KEYLEN>16</KEYLEN><ALGID>AESCTR</ALGID></PROTECTINFO>`)
+		header.WriteString(`<KID>` + kid + `</KID><CHECKSUM>` + base64.StdEncoding.EncodeToString(checksum[:8]) + `</CHECKSUM>`)
+	}
+	if laUrl != "" {
+		header.WriteString(`<LA_URL>`)
+		xml.EscapeText(&header, []byte(laUrl))
+		header.WriteString(`</LA_URL>`)
+	}
+	header.WriteString(`</DATA></WRMHEADER>`)
+
+	// UTF-16LE record
+	record := utf16.Encode([]rune(header.String()))
+	recordSize := 2 * len(record)
+	pro = make([]byte, 10+recordSize)
+	binary.LittleEndian.PutUint32(pro[0:4], uint32(len(pro)))
+	binary.LittleEndian.PutUint16(pro[4:6], 1)
+	binary.LittleEndian.PutUint16(pro[6:8], 1) // Rights management header
+	binary.LittleEndian.PutUint16(pro[8:10], uint16(recordSize))
+	for i, c := range record {
+		binary.LittleEndian.PutUint16(pro[10+2*i:12+2*i], c)
+	}
+
+	return
+}
+
+// PlayReady PSSH Box, its data is a PlayReady Header Object
+func PlayReadyPsshBox(keyId [16]byte, key [16]byte, scheme string, laUrl string) (pssh PsshBox) {
+	pssh.Version = 0
+	pssh.SystemId = PlayReadySystemId
+	pssh.Data = PlayReadyHeaderObject(keyId, key, scheme, laUrl)
+	pssh.DataSize = uint32(len(pssh.Data))
+	pssh.Size = 24 + pssh.DataSize
+
+	return
+}
+
+// Parse a base64 PSSH Box, like the ones of CPIX documents and MPD cenc:pssh elements
+func ParsePsshBox(b64 string) (pssh PsshBox, err error) {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return
+	}
+	if len(data) < 32 || string(data[4:8]) != "pssh" || binary.BigEndian.Uint32(data[0:4]) != uint32(len(data)) {
+		err = errors.New("invalid PSSH box")
+		return
+	}
+	pssh.Size = uint32(len(data)) - 8
+	pssh.Version = data[8]
+	copy(pssh.Flags[:], data[9:12])
+	copy(pssh.SystemId[:], data[12:28])
+	offset := uint32(28)
+	if pssh.Version > 0 {
+		pssh.KidCount = binary.BigEndian.Uint32(data[offset : offset+4])
+		offset += 4
+		if uint64(offset)+16*uint64(pssh.KidCount)+4 > uint64(len(data)) {
+			err = errors.New("invalid PSSH box")
+			return
+		}
+		pssh.Kids = make([][16]byte, pssh.KidCount)
+		for i := range pssh.Kids {
+			copy(pssh.Kids[i][:], data[offset:offset+16])
+			offset += 16
+		}
+	}
+	pssh.DataSize = binary.BigEndian.Uint32(data[offset : offset+4])
+	offset += 4
+	if uint64(offset)+uint64(pssh.DataSize) != uint64(len(data)) {
+		err = errors.New("invalid PSSH box")
+		return
+	}
+	pssh.Data = data[offset:]
+
+	return
+}
@@ -0,0 +1,102 @@
+package mp4
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"unicode/utf16"
+)
+
+// Key id 10000000-1000-1000-1000-100000000001 and its PlayReady little endian GUID in base64
+var testPsshKeyId = [16]byte{0x10, 0, 0, 0, 0x10, 0, 0x10, 0, 0x10, 0, 0x10, 0, 0, 0, 0, 0x01}
+
+const testPlayReadyKid = "AAAAEAAQABAQABAAAAAAAQ=="
+
+func TestWidevinePsshBox(t *testing.T) {
+	tests := []struct {
+		name      string
+		provider  string
+		contentId []byte
+		scheme    string
+		pssh      string
+	}{
+		// Key id (field 2), provider (3), content id (4) and protection scheme (9, 'cenc' as a varint)
+		{"provider, content id and scheme", "widevine_test", []byte{0x2a}, "cenc",
+			"0000004a7073736800000000edef8ba979d64acea3c827dcd51d21ed0000002a" +
+				"1210" + "10000000100010001000100000000001" + "1a0d7769646576696e655f74657374" + "22012a" + "48e3dc959b06"},
+		{"cbcs scheme", "", nil, "cbcs",
+			"000000387073736800000000edef8ba979d64acea3c827dcd51d21ed00000018" +
+				"1210" + "10000000100010001000100000000001" + "48f3c6899b06"},
+		{"key id only", "", nil, "",
+			"000000327073736800000000edef8ba979d64acea3c827dcd51d21ed00000012" +
+				"1210" + "10000000100010001000100000000001"},
+	}
+	for _, test := range tests {
+		pssh := WidevinePsshBox([][16]byte{testPsshKeyId}, test.provider, test.contentId, test.scheme)
+		if data := hex.EncodeToString(pssh.Bytes()); data != test.pssh {
+			t.Errorf("%s: PSSH Box %s, want %s", test.name, data, test.pssh)
+		}
+	}
+
+	// The same Widevine PSSH Box in base64, as found in CPIX documents
+	pssh, err := ParsePsshBox("AAAASnBzc2gAAAAA7e+LqXnWSs6jyCfc1R0h7QAAACoSEBAAAAAQABAAEAAQAAAAAAEaDXdpZGV2aW5lX3Rlc3QiASpI49yVmwY=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pssh.SystemId != WidevineSystemId || hex.EncodeToString(pssh.Data) != hex.EncodeToString(WidevinePsshBox([][16]byte{testPsshKeyId}, "widevine_test", []byte{0x2a}, "cenc").Data) {
+		t.Errorf("parsed PSSH Box %+v", pssh)
+	}
+}
+
+func TestCommonPsshBox(t *testing.T) {
+	pssh := CommonPsshBox([][16]byte{testPsshKeyId})
+	want := "0000003470737368010000001077efecc0b24d02ace33c1e52e2fb4b00000001" + "10000000100010001000100000000001" + "00000000"
+	if data := hex.EncodeToString(pssh.Bytes()); data != want {
+		t.Errorf("PSSH Box %s, want %s", data, want)
+	}
+}
+
+func TestPlayReadyHeaderObject(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme string
+		laUrl  string
+		header string
+	}{
+		// The checksum is the first 8 bytes of the GUID encrypted with the key with AES-ECB
+		{"cenc", "cenc", "", `<WRMHEADER xmlns="http://schemas.microsoft.com/DRM/2007/03/PlayReadyHeader" version="4.0.0.0"><DATA>` +
+			`<PROTECTINFO><KEYLEN>16</KEYLEN><ALGID>AESCTR</ALGID></PROTECTINFO><KID>` + testPlayReadyKid + `</KID>` +
+			`<CHECKSUM>lDayfUU9YD4=</CHECKSUM></DATA></WRMHEADER>`},
+		{"cenc with license URL", "cenc", "https://pr.example.com/rightsmanager.asmx?a=1&b=2",
+			`<WRMHEADER xmlns="http://schemas.microsoft.com/DRM/2007/03/PlayReadyHeader" version="4.0.0.0"><DATA>` +
+				`<PROTECTINFO><KEYLEN>16</KEYLEN><ALGID>AESCTR</ALGID></PROTECTINFO><KID>` + testPlayReadyKid + `</KID>` +
+				`<CHECKSUM>lDayfUU9YD4=</CHECKSUM><LA_URL>https://pr.example.com/rightsmanager.asmx?a=1&amp;b=2</LA_URL></DATA></WRMHEADER>`},
+		{"cbcs", "cbcs", "", `<WRMHEADER xmlns="http://schemas.microsoft.com/DRM/2007/03/PlayReadyHeader" version="4.3.0.0"><DATA>` +
+			`<PROTECTINFO><KIDS><KID ALGID="AESCBC" VALUE="` + testPlayReadyKid + `"></KID></KIDS></PROTECTINFO></DATA></WRMHEADER>`},
+	}
+	for _, test := range tests {
+		pro := PlayReadyHeaderObject(testPsshKeyId, testKey, test.scheme, test.laUrl)
+		// Length, record count, record type 1 (rights management header) and record length
+		recordSize := 2 * len(test.header)
+		if len(pro) != 10+recordSize || binary.LittleEndian.Uint32(pro[0:4]) != uint32(len(pro)) ||
+			binary.LittleEndian.Uint16(pro[4:6]) != 1 || binary.LittleEndian.Uint16(pro[6:8]) != 1 ||
+			binary.LittleEndian.Uint16(pro[8:10]) != uint16(recordSize) {
+			t.Errorf("%s: PlayReady Header Object of %d bytes, header %x", test.name, len(pro), pro[:10])
+			continue
+		}
+		record := make([]uint16, recordSize/2)
+		for i := range record {
+			record[i] = binary.LittleEndian.Uint16(pro[10+2*i : 12+2*i])
+		}
+		if header := string(utf16.Decode(record)); header != test.header {
+			t.Errorf("%s: WRMHEADER %s, want %s", test.name, header, test.header)
+		}
+
+		pssh := PlayReadyPsshBox(testPsshKeyId, testKey, test.scheme, test.laUrl)
+		parsed, err := ParsePsshBox(base64.StdEncoding.EncodeToString(pssh.Bytes()))
+		if err != nil || parsed.SystemId != PlayReadySystemId || string(parsed.Data) != string(pro) {
+			t.Errorf("%s: PSSH Box %x (%v)", test.name, pssh.Bytes(), err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2015
+//      Sebastien Petit & Afrostream - www.afrostream.tv - spebsd@gmail.com.
+//      All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+// LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+// OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+// SUCH DAMAGE.
+
+package mp4
+
+import (
+	"encoding/binary"
+)
+
+var (
+	// PIFF 1.1 extended types
+	tfxdUserType = [16]byte{0x6D, 0x1D, 0x9B, 0x05, 0x42, 0xD5, 0x44, 0xE6, 0x80, 0xE2, 0x14, 0x1D, 0xAF, 0xF7, 0x57, 0xB2}
+	tfrfUserType = [16]byte{0xD4, 0x80, 0x7E, 0xF2, 0xCA, 0x39, 0x46, 0x95, 0x8E, 0x54, 0x26, 0xCB, 0x9E, 0x46, 0xA7, 0x9F}
+)
+
+// ***
+// *** Public functions
+// ***
+
+// AAC AudioSpecificConfig of an audio track, 2 bytes one of an AAC LC stream if it was not packaged (used as Smooth Streaming CodecPrivateData)
+func AacAudioSpecificConfig(aConf DashConfig) (config []byte) {
+	if len(aConf.Audio.AudioSpecificConfig) > 0 {
+		return aConf.Audio.AudioSpecificConfig
+	}
+	objectType := byte(2) // AAC LC
+	samplingFrequencyIndex := aacSamplingFrequencyIndex(aConf)
+	channelConfiguration := byte(aConf.Audio.NumberOfChannels)
+
+	config = make([]byte, 2)
+	config[0] = (objectType << 3) | (samplingFrequencyIndex >> 1)
+	config[1] = ((samplingFrequencyIndex & 0x01) << 7) | ((channelConfiguration & 0x0F) << 3)
+
+	return
+}
+
+// Create a Smooth Streaming fragment (MOOF and MDAT Boxes) starting at time fragmentTime (in track timescale unit)
+// TFXD Box gives the fragment time and duration, TFRF Box gives the ones of the next fragment
+func CreateSmoothFragmentWithConf(dConf DashConfig, filename string, fragmentTime uint64, fragmentDuration uint32) (fmp4 map[string][]interface{}) {
+	segments := GetDashSegmentsWithConf(dConf, filename, fragmentDuration)
+	for i, segment := range segments {
+		if segment.Time != fragmentTime {
+			continue
+		}
+		fmp4 = CreateDashFragmentWithConf(dConf, filename, segment.Number, fragmentDuration)
+		if fmp4 == nil {
+			return
+		}
+		delete(fmp4, "styp")
+		delete(fmp4, "free")
+
+		// TFXD
+		var tfxd UuidBox
+		tfxd.UserType = tfxdUserType
+		tfxd.Data = make([]byte, 20)
+		tfxd.Data[0] = 1 // Version 1 (64 bits times)
+		binary.BigEndian.PutUint64(tfxd.Data[4:12], segment.Time)
+		binary.BigEndian.PutUint64(tfxd.Data[12:20], segment.Duration)
+		tfxd.Size = 16 + uint32(len(tfxd.Data))
+		replaceBox(fmp4, "moof.traf.tfxd", tfxd)
+
+		// TFRF
+		var tfrf UuidBox
+		tfrf.UserType = tfrfUserType
+		tfrf.Data = []byte{1, 0, 0, 0, 0} // Version 1 (64 bits times) and FragmentCount
+		if i+1 < len(segments) {
+			tfrf.Data[4] = 1
+			entry := make([]byte, 16)
+			binary.BigEndian.PutUint64(entry[0:8], segments[i+1].Time)
+			binary.BigEndian.PutUint64(entry[8:16], segments[i+1].Duration)
+			tfrf.Data = append(tfrf.Data, entry...)
+		}
+		tfrf.Size = 16 + uint32(len(tfrf.Data))
+		replaceBox(fmp4, "moof.traf.tfrf", tfrf)
+
+		uuidSize := tfxd.Size + 8 + tfrf.Size + 8
+		traf := fmp4["moof.traf"][0].(ParentBox)
+		traf.Size += uuidSize
+		replaceBox(fmp4, "moof.traf", traf)
+		moof := fmp4["moof"][0].(ParentBox)
+		moof.Size += uuidSize
+		replaceBox(fmp4, "moof", moof)
+		trun := fmp4["moof.traf.trun"][0].(TrunBox)
+		trun.DataOffset += int32(uuidSize)
+		replaceBox(fmp4, "moof.traf.trun", trun)
+
+		return
+	}
+
+	return
+}
@@ -0,0 +1,500 @@
+// Copyright (c) 2015
+//      Sebastien Petit & Afrostream - www.afrostream.tv - spebsd@gmail.com.
+//      All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT
+// LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY
+// OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF
+// SUCH DAMAGE.
+
+package mp4
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"log"
+	"os"
+)
+
+const (
+	tsPacketSize = 188
+	tsPmtPid     = 0x1000
+	tsVideoPid   = 0x0100
+	tsAudioPid   = 0x0101
+	// All timestamps are shifted by 1.4s like most of the muxers, so DTS are never negative
+	tsTimestampOffset = 126000
+	// Number of ADTS frames sent in the same audio PES packet
+	tsAdtsFramesPerPes = 8
+)
+
+var aacSamplingFrequencies = []uint32{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+type tsSample struct {
+	Data     []byte
+	Dts      int64 // in 90kHz unit
+	Pts      int64 // in 90kHz unit
+	KeyFrame bool
+}
+
+type tsMuxer struct {
+	data              []byte
+	continuityCounter map[uint16]byte
+}
+
+// MPEG-2 CRC32 (polynom 0x04C11DB7, not reflected) used by PSI sections
+func crc32Mpeg2(data []byte) (crc uint32) {
+	crc = 0xFFFFFFFF
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = (crc << 1) ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return
+}
+
+// Encode a 33 bits PTS or DTS in the 5 bytes PES format
+func tsTimestamp(prefix byte, ts int64) (data []byte) {
+	data = make([]byte, 5)
+	data[0] = (prefix << 4) | byte((ts>>29)&0x0E) | 0x01
+	data[1] = byte(ts >> 22)
+	data[2] = byte((ts>>14)&0xFE) | 0x01
+	data[3] = byte(ts >> 7)
+	data[4] = byte((ts<<1)&0xFE) | 0x01
+
+	return
+}
+
+// Write a payload in as many TS packets as needed
+// pcr is written in the first packet if pcr >= 0
+func (ts *tsMuxer) writePackets(pid uint16, payload []byte, payloadUnitStart bool, pcr int64, randomAccess bool) {
+	first := true
+	for first || len(payload) > 0 {
+		var af []byte // Adaptation field without its length byte, nil if there is no adaptation field
+		if first && (pcr >= 0 || randomAccess) {
+			af = []byte{0x00}
+			if randomAccess {
+				af[0] |= 0x40
+			}
+			if pcr >= 0 {
+				af[0] |= 0x10
+				af = append(af, byte(pcr>>25), byte(pcr>>17), byte(pcr>>9), byte(pcr>>1), byte((pcr&0x01)<<7)|0x7E, 0x00)
+			}
+		}
+		afSize := 0
+		if af != nil {
+			afSize = 1 + len(af)
+		}
+		size := tsPacketSize - 4 - afSize
+		if len(payload) < size {
+			stuffing := size - len(payload)
+			if af == nil {
+				af = []byte{}
+				stuffing--
+				if stuffing > 0 {
+					af = append(af, 0x00)
+					stuffing--
+				}
+			}
+			for ; stuffing > 0; stuffing-- {
+				af = append(af, 0xFF)
+			}
+			size = len(payload)
+		}
+
+		packet := make([]byte, 4, tsPacketSize)
+		packet[0] = 0x47
+		packet[1] = byte(pid>>8) & 0x1F
+		if first && payloadUnitStart {
+			packet[1] |= 0x40
+		}
+		packet[2] = byte(pid)
+		packet[3] = 0x10 | (ts.continuityCounter[pid] & 0x0F)
+		ts.continuityCounter[pid]++
+		if af != nil {
+			packet[3] |= 0x20
+			packet = append(packet, byte(len(af)))
+			packet = append(packet, af...)
+		}
+		packet = append(packet, payload[:size]...)
+		payload = payload[size:]
+		ts.data = append(ts.data, packet...)
+		first = false
+	}
+}
+
+// Write a PSI section (PAT or PMT) with its CRC
+func (ts *tsMuxer) writeSection(pid uint16, tableId byte, tableIdExtension uint16, content []byte) {
+	section := make([]byte, 8)
+	section[0] = tableId
+	binary.BigEndian.PutUint16(section[1:3], 0xB000|uint16(5+len(content)+4))
+	binary.BigEndian.PutUint16(section[3:5], tableIdExtension)
+	section[5] = 0xC1 // Version 0 and current_next_indicator
+	section[6] = 0x00 // section_number
+	section[7] = 0x00 // last_section_number
+	section = append(section, content...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32Mpeg2(section))
+	section = append(section, crc...)
+	// Pointer field
+	ts.writePackets(pid, append([]byte{0x00}, section...), true, -1, false)
+}
+
+func (ts *tsMuxer) writePatPmt(hasVideo bool, hasAudio bool) {
+	pat := make([]byte, 4)
+	binary.BigEndian.PutUint16(pat[0:2], 1) // program_number
+	binary.BigEndian.PutUint16(pat[2:4], 0xE000|tsPmtPid)
+	ts.writeSection(0, 0x00, 1, pat)
+
+	pmt := make([]byte, 4)
+	if hasVideo {
+		binary.BigEndian.PutUint16(pmt[0:2], 0xE000|tsVideoPid) // PCR PID
+	} else {
+		binary.BigEndian.PutUint16(pmt[0:2], 0xE000|tsAudioPid)
+	}
+	binary.BigEndian.PutUint16(pmt[2:4], 0xF000) // program_info_length
+	if hasVideo {
+		pmt = append(pmt, 0x1B, 0xE0|byte(tsVideoPid>>8), byte(tsVideoPid&0xFF), 0xF0, 0x00) // H.264
+	}
+	if hasAudio {
+		pmt = append(pmt, 0x0F, 0xE0|byte(tsAudioPid>>8), byte(tsAudioPid&0xFF), 0xF0, 0x00) // AAC ADTS
+	}
+	ts.writeSection(tsPmtPid, 0x02, 1, pmt)
+}
+
+func (ts *tsMuxer) writePes(pid uint16, streamId byte, sample tsSample, withPcr bool) {
+	header := []byte{0x00, 0x00, 0x01, streamId, 0x00, 0x00, 0x80}
+	if sample.Dts != sample.Pts {
+		header = append(header, 0xC0, 10)
+		header = append(header, tsTimestamp(0x03, sample.Pts)...)
+		header = append(header, tsTimestamp(0x01, sample.Dts)...)
+	} else {
+		header = append(header, 0x80, 5)
+		header = append(header, tsTimestamp(0x02, sample.Pts)...)
+	}
+	pesLength := len(header) - 6 + len(sample.Data)
+	if pesLength <= 0xFFFF && streamId != 0xE0 {
+		binary.BigEndian.PutUint16(header[4:6], uint16(pesLength))
+	}
+	pcr := int64(-1)
+	if withPcr {
+		pcr = sample.Dts
+	}
+	ts.writePackets(pid, append(header, sample.Data...), true, pcr, sample.KeyFrame)
+}
+
+// Convert an AVC sample (NAL units prefixed by their size) to Annex-B format
+// An access unit delimiter is added and SPS/PPS are injected before each I-Frame
+func avcSampleToAnnexB(vConf DashVideoEntry, sample []byte, keyFrame bool) (data []byte) {
+	startCode := []byte{0x00, 0x00, 0x00, 0x01}
+	nalLengthSize := int(vConf.NalUnitSize&0x03) + 1
+
+	data = append(data, startCode...)
+	data = append(data, 0x09, 0xF0)
+	parameterSetsPresent := false
+	var nalUnits [][]byte
+	for offset := 0; offset+nalLengthSize <= len(sample); {
+		var nalSize int
+		for i := 0; i < nalLengthSize; i++ {
+			nalSize = (nalSize << 8) | int(sample[offset+i])
+		}
+		offset += nalLengthSize
+		if nalSize == 0 || offset+nalSize > len(sample) {
+			break
+		}
+		nalType := sample[offset] & 0x1F
+		if nalType == 7 {
+			parameterSetsPresent = true
+		}
+		if nalType != 9 {
+			nalUnits = append(nalUnits, sample[offset:offset+nalSize])
+		}
+		offset += nalSize
+	}
+	if keyFrame && parameterSetsPresent == false {
+		var i uint32
+		for i = 0; i < uint32(vConf.SPSEntryCount); i++ {
+			data = append(data, startCode...)
+			data = append(data, vConf.SPSData[i*uint32(vConf.SPSSize):(i+1)*uint32(vConf.SPSSize)]...)
+		}
+		for i = 0; i < uint32(vConf.PPSEntryCount); i++ {
+			data = append(data, startCode...)
+			data = append(data, vConf.PPSData[i*uint32(vConf.PPSSize):(i+1)*uint32(vConf.PPSSize)]...)
+		}
+	}
+	for _, nalUnit := range nalUnits {
+		data = append(data, startCode...)
+		data = append(data, nalUnit...)
+	}
+
+	return
+}
+
+// Index of the audio track sample rate in the AAC sampling frequencies table
+func aacSamplingFrequencyIndex(aConf DashConfig) (samplingFrequencyIndex byte) {
+	sampleRate := aConf.Audio.SampleRate >> 16
+	if sampleRate == 0 {
+		sampleRate = aConf.Timescale
+	}
+	for i, v := range aacSamplingFrequencies {
+		if v == sampleRate {
+			samplingFrequencyIndex = byte(i)
+		}
+	}
+
+	return
+}
+
+// Create the 7 bytes ADTS header of an AAC frame
+func adtsHeader(aConf DashConfig, frameSize int) (header []byte) {
+	samplingFrequencyIndex := aacSamplingFrequencyIndex(aConf)
+	profile := byte(1) // AAC LC (object type 2) - 1
+	channelConfiguration := byte(aConf.Audio.NumberOfChannels)
+	// HE-AAC frames are signalled with the core AAC configuration
+	if asc, err := parseAacAudioSpecificConfig(aConf.Audio.AudioSpecificConfig); err == nil && asc.objectType >= 1 && asc.objectType <= 4 {
+		if asc.samplingFrequencyIndex < 0x0F {
+			samplingFrequencyIndex = asc.samplingFrequencyIndex
+		}
+		profile = asc.objectType - 1
+		channelConfiguration = asc.channelConfiguration
+	}
+	frameLength := frameSize + 7
+
+	header = make([]byte, 7)
+	header[0] = 0xFF
+	header[1] = 0xF1 // MPEG-4, layer 0, no CRC
+	header[2] = (profile << 6) | (samplingFrequencyIndex << 2) | ((channelConfiguration >> 2) & 0x01)
+	header[3] = ((channelConfiguration & 0x03) << 6) | byte((frameLength>>11)&0x03)
+	header[4] = byte(frameLength >> 3)
+	header[5] = byte((frameLength&0x07)<<5) | 0x1F
+	header[6] = 0xFC
+
+	return
+}
+
+// Read the data of samples [sampleStart, sampleEnd] of a track
+func readSamplesWithConf(dConf DashConfig, filename string, sampleStart uint32, sampleEnd uint32) (samples [][]byte) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	stsz := readStszBoxWithConf(f, dConf, sampleEnd+1)
+	if stsz.SampleCount == 0 || sampleStart >= stsz.SampleCount {
+		return
+	}
+	if sampleEnd > stsz.SampleCount-1 {
+		sampleEnd = stsz.SampleCount - 1
+	}
+
+	tables := dashSampleTables{stsz: stsz}
+	tables.stsc, tables.chunkOffsets = readChunkTablesWithConf(f, dConf, sampleEnd+1)
+	var data []byte
+	for _, chunk := range sampleChunks(dConf, tables, sampleStart, sampleEnd) {
+		chunkData := make([]byte, chunk.Size)
+		_, err = f.ReadAt(chunkData, chunk.Offset)
+		if err != nil {
+			return
+		}
+		data = append(data, chunkData...)
+	}
+	var i uint32
+	for i = sampleStart; i <= sampleEnd; i++ {
+		size := stsz.SampleSize
+		if size == 0 {
+			size = stsz.EntrySize[i]
+		}
+		samples = append(samples, data[:size])
+		data = data[size:]
+	}
+
+	return
+}
+
+// Read the samples of a DASH fragment as they are described by its TRUN Box
+func readFragmentSamples(dConf DashConfig, filename string, fragmentNumber uint32, fragmentDuration uint32) (samples []tsSample) {
+	fragment := CreateDashFragmentWithConf(dConf, filename, fragmentNumber, fragmentDuration)
+	if fragment == nil {
+		return
+	}
+	trun := fragment["moof.traf.trun"][0].(TrunBox)
+	tfdt := fragment["moof.traf.tfdt"][0].(TfdtBox)
+	mdat := fragment["mdat"][0].(MdatBox)
+	data := mdat.Bytes()[8:]
+
+	decodeTime := int64(tfdt.BaseMediaDecodeTime)
+	for _, s := range trun.Samples {
+		var sample tsSample
+		sample.Data = data[:s.Size]
+		data = data[s.Size:]
+		// Composition time offsets are already shifted by the edit list, so DTS are shifted the same way
+		pts := decodeTime + s.CompositionTimeOffset
+		dts := pts
+		if trun.Flags[1]&0x08 != 0 {
+			dts = decodeTime - dConf.MediaTime
+		}
+		sample.Pts = tsTimestampOffset + (pts*90000)/int64(dConf.Timescale)
+		sample.Dts = tsTimestampOffset + (dts*90000)/int64(dConf.Timescale)
+		sample.KeyFrame = s.Flags&0x00010000 == 0
+		samples = append(samples, sample)
+		decodeTime += int64(dConf.SampleDelta)
+	}
+
+	return
+}
+
+// Read the audio samples presented between start and end (in seconds)
+func readAudioSamplesByTime(aConf DashConfig, filename string, start float64, end float64, lastSegment bool) (samples []tsSample) {
+	sampleStart := uint32(start*float64(aConf.Timescale)/float64(aConf.SampleDelta) + 0.5)
+	sampleEnd := uint32(end*float64(aConf.Timescale)/float64(aConf.SampleDelta)+0.5) - 1
+	if lastSegment {
+		sampleEnd = 0xFFFFFFFE
+	}
+	if sampleEnd < sampleStart {
+		return
+	}
+	dts := int64(sampleStart) * int64(aConf.SampleDelta)
+	for _, data := range readSamplesWithConf(aConf, filename, sampleStart, sampleEnd) {
+		var sample tsSample
+		sample.Data = data
+		sample.Dts = tsTimestampOffset + (dts*90000)/int64(aConf.Timescale)
+		sample.Pts = sample.Dts
+		sample.KeyFrame = true
+		samples = append(samples, sample)
+		dts += int64(aConf.SampleDelta)
+	}
+
+	return
+}
+
+func (ts *tsMuxer) writeAudioSamples(aConf DashConfig, samples []tsSample, withPcr bool) {
+	for i := 0; i < len(samples); i += tsAdtsFramesPerPes {
+		var pes tsSample
+		pes.Dts = samples[i].Dts
+		pes.Pts = samples[i].Pts
+		pes.KeyFrame = true
+		for j := i; j < i+tsAdtsFramesPerPes && j < len(samples); j++ {
+			pes.Data = append(pes.Data, adtsHeader(aConf, len(samples[j].Data))...)
+			pes.Data = append(pes.Data, samples[j].Data...)
+		}
+		ts.writePes(tsAudioPid, 0xC0, pes, withPcr)
+	}
+}
+
+// ***
+// *** Public functions
+// ***
+
+// Create a MPEG-2 Transport Stream segment
+// Video samples are the ones of the DASH fragment number fragmentNumber, audio samples are the ones
+// presented at the same time. vConf or aConf can be nil for an audio only or a video only segment.
+func CreateTsSegmentWithConf(vConf *DashConfig, vFilename string, aConf *DashConfig, aFilename string, fragmentNumber uint32, fragmentDuration uint32) (data []byte) {
+	var ts tsMuxer
+	ts.continuityCounter = make(map[uint16]byte)
+
+	if vConf == nil {
+		if aConf == nil {
+			return
+		}
+		audioSamples := readFragmentSamples(*aConf, aFilename, fragmentNumber, fragmentDuration)
+		if audioSamples == nil {
+			return
+		}
+		ts.writePatPmt(false, true)
+		ts.writeAudioSamples(*aConf, audioSamples, true)
+		data = ts.data
+
+		return
+	}
+
+	if vConf.Video == nil || vConf.Video.SPSData == nil {
+		if debugMode {
+			log.Printf("ERROR: only AVC video tracks can be muxed in MPEG-2 TS")
+		}
+		return
+	}
+	videoSamples := readFragmentSamples(*vConf, vFilename, fragmentNumber, fragmentDuration)
+	if videoSamples == nil {
+		return
+	}
+	var audioSamples []tsSample
+	if aConf != nil {
+		segments := GetDashSegmentsWithConf(*vConf, vFilename, fragmentDuration)
+		for i, segment := range segments {
+			if segment.Number == fragmentNumber {
+				start := float64(segment.Time) / float64(vConf.Timescale)
+				end := float64(segment.Time+segment.Duration) / float64(vConf.Timescale)
+				audioSamples = readAudioSamplesByTime(*aConf, aFilename, start, end, i == len(segments)-1)
+				break
+			}
+		}
+	}
+
+	ts.writePatPmt(true, aConf != nil)
+	// Interleave audio and video PES packets by decoding time
+	a := 0
+	for _, sample := range videoSamples {
+		for a < len(audioSamples) && audioSamples[a].Dts <= sample.Dts {
+			end := a + tsAdtsFramesPerPes
+			if end > len(audioSamples) {
+				end = len(audioSamples)
+			}
+			ts.writeAudioSamples(*aConf, audioSamples[a:end], false)
+			a = end
+		}
+		sample.Data = avcSampleToAnnexB(*vConf.Video, sample.Data, sample.KeyFrame)
+		ts.writePes(tsVideoPid, 0xE0, sample, true)
+	}
+	if a < len(audioSamples) {
+		ts.writeAudioSamples(*aConf, audioSamples[a:], false)
+	}
+	data = ts.data
+
+	return
+}
+
+// Encrypt a MPEG-2 Transport Stream segment for HLS METHOD=AES-128: AES-CBC with PKCS7 padding
+func EncryptTsSegmentWithAes128(segment []byte, key [16]byte, iv [16]byte) (data []byte) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return
+	}
+	padding := aes.BlockSize - len(segment)%aes.BlockSize
+	data = make([]byte, len(segment)+padding)
+	copy(data, segment)
+	for i := len(segment); i < len(data); i++ {
+		data[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv[:]).CryptBlocks(data, data)
+
+	return
+}
@@ -0,0 +1,226 @@
+package mp4
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// TS packet header and adaptation field fields
+type testTsPacket struct {
+	Pid              uint16
+	PayloadUnitStart bool
+	Continuity       byte
+	RandomAccess     bool
+	Pcr              int64 // -1 without PCR
+	Payload          []byte
+}
+
+// Split TS packets and check their size, sync byte and adaptation field length
+func readTestTsPackets(t *testing.T, data []byte) (packets []testTsPacket) {
+	if len(data)%tsPacketSize != 0 {
+		t.Fatalf("%d bytes, not a multiple of the TS packet size", len(data))
+	}
+	for offset := 0; offset < len(data); offset += tsPacketSize {
+		p := data[offset : offset+tsPacketSize]
+		if p[0] != 0x47 {
+			t.Fatalf("packet %d: sync byte 0x%02x", offset/tsPacketSize, p[0])
+		}
+		packet := testTsPacket{Pcr: -1}
+		packet.Pid = binary.BigEndian.Uint16(p[1:3]) & 0x1FFF
+		packet.PayloadUnitStart = p[1]&0x40 != 0
+		packet.Continuity = p[3] & 0x0F
+		payload := p[4:]
+		if p[3]&0x20 != 0 {
+			afLength := int(payload[0])
+			if 1+afLength > len(payload) {
+				t.Fatalf("packet %d: adaptation field of %d bytes", offset/tsPacketSize, afLength)
+			}
+			af := payload[1 : 1+afLength]
+			if afLength > 0 {
+				packet.RandomAccess = af[0]&0x40 != 0
+				if af[0]&0x10 != 0 {
+					// PCR base, the extension is always 0
+					packet.Pcr = int64(af[1])<<25 | int64(af[2])<<17 | int64(af[3])<<9 | int64(af[4])<<1 | int64(af[5])>>7
+				}
+			}
+			payload = payload[1+afLength:]
+		}
+		packet.Payload = payload
+		packets = append(packets, packet)
+	}
+
+	return
+}
+
+func TestCrc32Mpeg2(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		crc  uint32
+	}{
+		{"check value", hex.EncodeToString([]byte("123456789")), 0x0376E6E7},
+		{"empty", "", 0xFFFFFFFF},
+		{"PAT", "00b00d0001c100000001f000", 0x2AB104B2},
+		{"PMT", "02b0170001c10000e100f0001be100f0000fe101f000", 0x2F44B99B},
+		{"PAT with its CRC", "00b00d0001c100000001f0002ab104b2", 0},
+	}
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+		if crc := crc32Mpeg2(data); crc != test.crc {
+			t.Errorf("%s: CRC 0x%08X, want 0x%08X", test.name, crc, test.crc)
+		}
+	}
+}
+
+func TestTsTimestamp(t *testing.T) {
+	tests := []struct {
+		prefix byte
+		ts     int64
+		data   string
+	}{
+		{0x02, 0, "2100010001"},
+		{0x02, tsTimestampOffset, "210007d861"},
+		{0x03, 0x1FFFFFFFF, "3fffffffff"},
+		{0x01, 0x100000000, "1900010001"},
+	}
+	for _, test := range tests {
+		data := tsTimestamp(test.prefix, test.ts)
+		if hex.EncodeToString(data) != test.data {
+			t.Errorf("tsTimestamp(0x%02x, %d) = %x, want %s", test.prefix, test.ts, data, test.data)
+		}
+		ts := int64(data[0]&0x0E)<<29 | int64(data[1])<<22 | int64(data[2]>>1)<<15 | int64(data[3])<<7 | int64(data[4]>>1)
+		if ts != test.ts || data[0]>>4 != test.prefix {
+			t.Errorf("tsTimestamp(0x%02x, %d) decoded as %d", test.prefix, test.ts, ts)
+		}
+	}
+}
+
+func TestWritePatPmt(t *testing.T) {
+	tests := []struct {
+		name     string
+		hasVideo bool
+		hasAudio bool
+		pmt      string
+	}{
+		{"video and audio", true, true, "02b0170001c10000e100f0001be100f0000fe101f0002f44b99b"},
+		{"audio only", false, true, "02b0120001c10000e101f0000fe101f000ece2b094"},
+		{"video only", true, false, "02b0120001c10000e100f0001be100f00015bd4d56"},
+	}
+	for _, test := range tests {
+		ts := tsMuxer{continuityCounter: make(map[uint16]byte)}
+		ts.writePatPmt(test.hasVideo, test.hasAudio)
+		packets := readTestTsPackets(t, ts.data)
+		if len(packets) != 2 {
+			t.Errorf("%s: %d packets, want PAT and PMT packets", test.name, len(packets))
+			continue
+		}
+		sections := []struct {
+			pid     uint16
+			section string
+		}{
+			{0, "00b00d0001c100000001f0002ab104b2"},
+			{tsPmtPid, test.pmt},
+		}
+		for i, section := range sections {
+			packet := packets[i]
+			if packet.Pid != section.pid || !packet.PayloadUnitStart || packet.Continuity != 0 {
+				t.Errorf("%s: packet %d: %+v", test.name, i, packet)
+			}
+			// Pointer field followed by the section, the packet is filled by the adaptation field
+			if hex.EncodeToString(packet.Payload) != "00"+section.section {
+				t.Errorf("%s: packet %d: payload %x, want 00%s", test.name, i, packet.Payload, section.section)
+			}
+			if crc32Mpeg2(packet.Payload[1:]) != 0 {
+				t.Errorf("%s: packet %d: wrong section CRC", test.name, i)
+			}
+		}
+	}
+}
+
+func TestWritePackets(t *testing.T) {
+	tests := []struct {
+		name         string
+		size         int
+		pcr          int64
+		randomAccess bool
+		packets      int
+	}{
+		{"empty", 0, -1, false, 1},
+		{"1 byte", 1, -1, false, 1},
+		{"1 byte of stuffing", 183, -1, false, 1},
+		{"full packet", 184, -1, false, 1},
+		{"2 packets", 185, -1, false, 2},
+		{"PCR", 176, 0x1FFFFFFFF, false, 1},
+		{"PCR and 1 byte more", 177, 126000, false, 2},
+		{"random access", 182, -1, true, 1},
+		{"PCR and random access", 1000, 900000, true, 6},
+	}
+	ts := tsMuxer{continuityCounter: make(map[uint16]byte)}
+	continuity := byte(0)
+	for _, test := range tests {
+		payload := byteRange(0, test.size)
+		ts.data = nil
+		ts.writePackets(tsVideoPid, payload, true, test.pcr, test.randomAccess)
+		packets := readTestTsPackets(t, ts.data)
+		if len(packets) != test.packets {
+			t.Errorf("%s: %d packets, want %d", test.name, len(packets), test.packets)
+		}
+		var data []byte
+		for i, packet := range packets {
+			if packet.Pid != tsVideoPid || packet.PayloadUnitStart != (i == 0) || packet.Continuity != continuity&0x0F {
+				t.Errorf("%s: packet %d: pid 0x%X, payload unit start %v, continuity counter %d, want %d", test.name, i,
+					packet.Pid, packet.PayloadUnitStart, packet.Continuity, continuity&0x0F)
+			}
+			continuity++
+			if i == 0 && (packet.Pcr != test.pcr || packet.RandomAccess != test.randomAccess) {
+				t.Errorf("%s: PCR %d and random access %v, want %d and %v", test.name, packet.Pcr, packet.RandomAccess, test.pcr, test.randomAccess)
+			}
+			if i > 0 && (packet.Pcr >= 0 || packet.RandomAccess) {
+				t.Errorf("%s: packet %d: PCR or random access after the first packet", test.name, i)
+			}
+			data = append(data, packet.Payload...)
+		}
+		if !bytes.Equal(data, payload) {
+			t.Errorf("%s: payload of %d bytes, want %d", test.name, len(data), len(payload))
+		}
+	}
+}
+
+func TestEncryptTsSegmentWithAes128(t *testing.T) {
+	var iv [16]byte
+	copy(iv[:], fromHex(t, testCbcIv))
+	tests := []struct {
+		name    string
+		segment []byte
+		padding int
+	}{
+		{"blocks", fromHex(t, testCbcP1+testCbcP2+testCbcP3+testCbcP4), 16},
+		{"TS packet", byteRange(0x47, tsPacketSize), 4},
+		{"empty", nil, 16},
+	}
+	for _, test := range tests {
+		data := EncryptTsSegmentWithAes128(test.segment, testKey, iv)
+		if len(data) != len(test.segment)+test.padding {
+			t.Errorf("%s: %d bytes, want %d", test.name, len(data), len(test.segment)+test.padding)
+			continue
+		}
+		block, _ := aes.NewCipher(testKey[:])
+		cipher.NewCBCDecrypter(block, iv[:]).CryptBlocks(data, data)
+		if !bytes.Equal(data[:len(test.segment)], test.segment) {
+			t.Errorf("%s: decrypted segment %x", test.name, data[:len(test.segment)])
+		}
+		if !bytes.Equal(data[len(test.segment):], bytes.Repeat([]byte{byte(test.padding)}, test.padding)) {
+			t.Errorf("%s: padding %x", test.name, data[len(test.segment):])
+		}
+	}
+
+	// NIST SP 800-38A F.2.1 followed by the encrypted padding block
+	data := EncryptTsSegmentWithAes128(fromHex(t, testCbcP1+testCbcP2+testCbcP3+testCbcP4), testKey, iv)
+	if hex.EncodeToString(data[:64]) != testCbcC1+testCbcC2+testCbcC3+testCbcC4 {
+		t.Errorf("ciphertext %x", data[:64])
+	}
+}